@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redigo/pkg/aof/scanner"
+	"redigo/pkg/rdb/codec"
+	"sort"
+	"strings"
+)
+
+/*
+check-aof 检查aof文件，报告第一条不完整或损坏的记录的位置，-fix 将文件截断到该位置。
+参数可以是aof文件，也可以是 appendDirName 目录，目录中所有的base和incr文件都会被检查。
+
+	check-aof [-fix] appendonlydir
+	check-aof [-fix] appendonlydir/appendonly.aof.3.incr.aof
+*/
+
+// report 一个文件的检查结果
+type report struct {
+	path     string
+	size     int64
+	commands int
+	keys     int  // keys RDB前缀中的key数量
+	preamble bool // preamble 文件是否以RDB数据开头
+	offset   int64
+	err      error
+	fixable  bool // fixable 错误出现在aof命令部分，可以截断修复；RDB前缀损坏时无法修复
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file at the first bad record")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [-fix] <aof file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	files, err := collectFiles(flag.Args())
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ok := true
+	for _, path := range files {
+		r, err := check(path)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+		printReport(r)
+		if r.err == nil {
+			continue
+		}
+		if !*fix || !r.fixable {
+			ok = false
+			continue
+		}
+		if err := os.Truncate(path, r.offset); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: truncate error: %v\n", path, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s: fixed, %d bytes discarded\n", path, r.size-r.offset)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// collectFiles 展开参数中的目录，目录中的base和incr文件按文件名排序
+func collectFiles(args []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, arg := range args {
+		stat, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0)
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, "temp-") {
+				continue
+			}
+			if strings.Contains(name, ".base.") || strings.HasSuffix(name, ".incr.aof") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(arg, name))
+		}
+	}
+	return files, nil
+}
+
+// check 读取文件中的所有记录，直到文件结束或者遇到第一条错误的记录
+func check(path string) (*report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &report{path: path, size: stat.Size()}
+	s := scanner.New(file)
+	r.preamble, r.err = s.ReadPreamble(func(reader *bufio.Reader) error {
+		return codec.NewDecoder(reader).Walk(func(_ *codec.Object) error {
+			r.keys++
+			return nil
+		})
+	})
+	if r.err != nil {
+		return r, nil
+	}
+	r.fixable = true
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			r.err = err
+			break
+		}
+		r.commands++
+	}
+	r.offset = s.Offset()
+	return r, nil
+}
+
+func printReport(r *report) {
+	if r.preamble {
+		fmt.Printf("%s: RDB preamble with %d keys, %d commands, %d bytes\n", r.path, r.keys, r.commands, r.size)
+	} else {
+		fmt.Printf("%s: %d commands, %d bytes\n", r.path, r.commands, r.size)
+	}
+	switch {
+	case r.err == nil:
+		fmt.Printf("%s: OK\n", r.path)
+	case r.err == scanner.ErrTruncated:
+		fmt.Printf("%s: truncated, incomplete record at offset %d\n", r.path, r.offset)
+	default:
+		fmt.Printf("%s: bad record at offset %d: %s\n", r.path, r.offset, strings.TrimSpace(r.err.Error()))
+	}
+}
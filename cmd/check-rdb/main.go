@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"redigo/pkg/rdb/codec"
+	"sort"
+	"strings"
+)
+
+/*
+check-rdb 检查RDB文件：统计每个数据库的key数量和类型分布，校验文件结尾的校验和，
+文件损坏时报告第一条损坏的记录的位置。
+
+	check-rdb dump.rdb
+*/
+
+var typeNames = map[byte]string{
+	codec.StringType:    "string",
+	codec.ListType:      "list",
+	codec.SetType:       "set",
+	codec.SortedSetType: "zset",
+	codec.HashType:      "hash",
+}
+
+// dbStats 一个数据库的统计信息
+type dbStats struct {
+	keys    int
+	expires int
+	types   map[byte]int
+}
+
+func main() {
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s <rdb file>\n", os.Args[0])
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	stats := make(map[int]*dbStats)
+	decoder := codec.NewDecoder(bufio.NewReader(file))
+	// good 最后一条完整记录结束的位置，出错时就是第一条损坏的记录的位置
+	var good int64
+	err = decoder.Walk(func(object *codec.Object) error {
+		db, ok := stats[object.DB]
+		if !ok {
+			db = &dbStats{types: make(map[byte]int)}
+			stats[object.DB] = db
+		}
+		db.keys++
+		db.types[object.Type]++
+		if object.ExpireAt != 0 {
+			db.expires++
+		}
+		good = decoder.Offset()
+		return nil
+	})
+	printStats(stats)
+	switch {
+	case err == nil:
+		fmt.Printf("%s: OK, %d bytes\n", path, decoder.Offset())
+	case errors.Is(err, codec.ErrChecksumMismatch):
+		fmt.Printf("%s: %v\n", path, err)
+		os.Exit(1)
+	default:
+		fmt.Printf("%s: bad record at offset %d: %v\n", path, good, err)
+		os.Exit(1)
+	}
+}
+
+func printStats(stats map[int]*dbStats) {
+	indexes := make([]int, 0, len(stats))
+	for index := range stats {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		db := stats[index]
+		types := make([]string, 0, len(db.types))
+		for t, count := range db.types {
+			types = append(types, fmt.Sprintf("%s=%d", typeNames[t], count))
+		}
+		sort.Strings(types)
+		fmt.Printf("db%d: keys=%d, expires=%d, %s\n", index, db.keys, db.expires, strings.Join(types, ", "))
+	}
+}
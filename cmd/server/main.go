@@ -5,6 +5,7 @@ import (
 	"redigo/pkg/cluster"
 	"redigo/pkg/config"
 	"redigo/pkg/database"
+	"redigo/pkg/sentinel"
 	"redigo/pkg/tcp"
 	"redigo/pkg/util/log"
 )
@@ -19,14 +20,25 @@ _  _, _//  __/ /_/ / _  / / /_/ / / /_/ /
 v1.0.0`
 
 func main() {
+	config.Setup()
 	fmt.Println(banner)
 	log.Info("Initializing server")
 	config.DisplayConfigs()
+	if config.Properties.SentinelMode {
+		log.Info("starting server in sentinel mode...")
+		server := tcp.NewServer(config.Properties.Address, sentinel.NewSentinel(config.Properties.Address, config.Properties.Sentinel))
+		err := server.Start()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 	db := database.NewMultiDB(config.Properties.Databases, 1024)
 	if config.Properties.EnableClusterMode {
 		log.Info("starting Redigo server in cluster mode...\n")
 		peer := cluster.NewCluster(db, config.Properties.Self, config.Properties.Peers)
 		server := tcp.NewServer(config.Properties.Address, peer)
+		database.ClientBuffers = server.ForEachClient
 		err := server.Start()
 		if err != nil {
 			panic(err)
@@ -34,6 +46,7 @@ func main() {
 	} else {
 		log.Info("starting server in standalone mode...")
 		server := tcp.NewServer(config.Properties.Address, db)
+		database.ClientBuffers = server.ForEachClient
 		err := server.Start()
 		if err != nil {
 			panic(err)
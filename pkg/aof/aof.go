@@ -2,8 +2,11 @@ package aof
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"redigo/pkg/aof/scanner"
 	"redigo/pkg/config"
 	"redigo/pkg/interface/database"
 	"redigo/pkg/redis"
@@ -18,19 +21,35 @@ import (
 type Payload struct {
 	command [][]byte
 	idx     int
+	// conn、reply 不为空时表示等待fsync的回复，always 策略下写命令的回复在AOF落盘后才发送
+	conn  redis.Connection
+	reply *redis.RespCommand
+	// timestamp 写命令执行时的unix毫秒时间，开启 aofTimestampEnabled 时才记录
+	timestamp int64
+	// size 命令参数的总长度，用于统计AOF缓冲区大小
+	size int64
 }
 
 type Handler struct {
 	db             database.DB
 	aofChan        chan Payload // aofChan AOF持久化缓冲，AOF异步写入磁盘
-	aofFileName    string
-	aofFile        *os.File
+	dir            string       // dir 保存aof文件和manifest的目录
+	prefix         string       // prefix aof文件名前缀，即配置的aofFileName
+	manifest       *manifest    // manifest 当前有效的base和incr文件
+	aofFile        *os.File     // aofFile 正在写入的incr文件
 	currentDB      int          // currentDB aof持久化过程中需要记录当前数据库，在切换时aof要插入select命令
 	ticker         *time.Ticker // ticker everysec 策略的计时器
 	closeChan      chan struct{}
 	aofLock        sync.Mutex
 	dbMaker        func() database.DB // dbMaker 在aof重写时用来创建临时的内存数据库
+	rdbLoader      RDBLoader          // rdbLoader 加载aof文件开头的RDB数据
 	RewriteStarted atomic.Value
+	always         bool         // always 是否使用 always 策略
+	dirty          atomic.Bool  // dirty 上一个回复之后是否有新的aof记录
+	pendingReplies int64        // pendingReplies 等待fsync的回复数量
+	lastTimestamp  int64        // lastTimestamp 最后写入的时间戳注释的unix毫秒时间
+	recoverUntil   int64        // recoverUntil 按时间点恢复时只加载该unix毫秒时间之前的记录，0表示加载全部记录
+	bufferSize     atomic.Int64 // bufferSize aofChan中还没有写入文件的命令大小
 }
 
 func NewDummyAofHandler() *Handler {
@@ -39,7 +58,10 @@ func NewDummyAofHandler() *Handler {
 	return handler
 }
 
-func NewAofHandler(db database.DB, dbMaker func() database.DB) (*Handler, error) {
+// RDBLoader 从reader中读取一份完整的RDB数据并加载到db，读取结束后reader停在RDB数据之后
+type RDBLoader func(db database.DB, reader *bufio.Reader) error
+
+func NewAofHandler(db database.DB, dbMaker func() database.DB, rdbLoader RDBLoader) (*Handler, error) {
 	handler := &Handler{db: db}
 	handler.aofChan = make(chan Payload, 1<<20)
 	handler.closeChan = make(chan struct{})
@@ -47,25 +69,50 @@ func NewAofHandler(db database.DB, dbMaker func() database.DB) (*Handler, error)
 	handler.RewriteStarted = atomic.Value{}
 	handler.RewriteStarted.Store(false)
 	handler.dbMaker = dbMaker
+	handler.rdbLoader = rdbLoader
+	handler.always = config.Properties.AppendFsync == config.FsyncAlways
 	// 每秒aof的ticker
 	if config.Properties.AppendFsync == config.FsyncEverySec {
 		handler.ticker = time.NewTicker(1 * time.Second)
 	}
-	handler.aofFileName = config.Properties.AofFileName
-	if file, err := os.OpenFile(handler.aofFileName, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666); err != nil {
+	handler.dir = config.Properties.AppendDirName
+	handler.prefix = filepath.Base(config.Properties.AofFileName)
+	if err := handler.openManifest(); err != nil {
 		return nil, err
-	}else {
-		handler.aofFile = file
+	}
+	if config.RecoverUntil != "" {
+		until, err := parseRecoverPoint(config.RecoverUntil)
+		if err != nil {
+			return nil, err
+		}
+		handler.recoverUntil = until
 	}
 	start := time.Now()
-	if err := handler.loadAof(-1); err != nil {
+	stopped, err := handler.loadFiles(handler.manifest.files(), true)
+	if err != nil {
 		panic(err)
 	}
+	if stopped {
+		if err := handler.restartFromRecoveryPoint(); err != nil {
+			return nil, err
+		}
+	} else if handler.recoverUntil != 0 {
+		log.Info("recover point %s is after the last AOF record, all records loaded", config.RecoverUntil)
+	}
+	if file, err := os.OpenFile(handler.filePath(handler.manifest.lastIncr()), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666); err != nil {
+		return nil, err
+	} else {
+		handler.aofFile = file
+	}
+	// 追加写入的文件最后选择的数据库未知，第一条命令前需要插入select
+	handler.currentDB = -1
 	log.Info("AOF loaded, time used: %d ms", time.Now().Sub(start).Milliseconds())
 	// 处理fsync
 	go func() {
 		if config.Properties.AppendFsync == config.FsyncEverySec {
 			handler.handleEverySec()
+		} else if handler.always {
+			handler.handleAlways()
 		} else {
 			handler.handle()
 		}
@@ -78,9 +125,36 @@ func (h *Handler) AddAof(command [][]byte, index int) {
 		command: command,
 		idx:     index,
 	}
+	for _, part := range command {
+		payload.size += int64(len(part))
+	}
+	h.bufferSize.Add(payload.size)
+	if config.Properties.AofTimestampEnabled {
+		payload.timestamp = time.Now().UnixMilli()
+	}
+	if h.always {
+		h.dirty.Store(true)
+	}
 	h.aofChan <- payload
 }
 
+// BufferSize AOF缓冲区中等待写入文件的命令大小
+func (h *Handler) BufferSize() int64 {
+	return h.bufferSize.Load()
+}
+
+// SendReply 发送命令的回复。
+// always 策略下，如果命令产生了aof记录，或者还有回复在等待fsync，回复会排在aof记录之后，在fsync完成后发送，
+// 这样既保证回复时数据已经落盘，也保证同一个连接的回复顺序不变
+func (h *Handler) SendReply(conn redis.Connection, reply *redis.RespCommand) {
+	if !h.always || (!h.dirty.Swap(false) && atomic.LoadInt64(&h.pendingReplies) == 0) {
+		conn.SendCommand(reply)
+		return
+	}
+	atomic.AddInt64(&h.pendingReplies, 1)
+	h.aofChan <- Payload{conn: conn, reply: reply}
+}
+
 // handle commands every second
 func (h *Handler) handleEverySec() {
 LOOP:
@@ -121,6 +195,53 @@ LOOP:
 	}
 }
 
+// handleAlways always 策略，group commit：
+// 一次取出chan中已有的所有记录写入文件，只做一次fsync，然后发送这一批中等待落盘的回复。
+// fsync期间到达的记录进入下一批，所以并发写入共享fsync，单个回复的等待时间不超过两次fsync
+func (h *Handler) handleAlways() {
+	for {
+		select {
+		case <-h.closeChan:
+			h.aofLock.Lock()
+			replies := h.writeBatch(nil, len(h.aofChan))
+			h.aofLock.Unlock()
+			h.sendReplies(replies)
+			return
+		case payload := <-h.aofChan:
+			h.aofLock.Lock()
+			replies := h.writeBatch([]Payload{payload}, len(h.aofChan))
+			h.aofLock.Unlock()
+			h.sendReplies(replies)
+		}
+	}
+}
+
+// writeBatch 将batch和chan中剩余的remaining个payload写入文件后fsync，返回这一批中等待发送的回复
+func (h *Handler) writeBatch(batch []Payload, remaining int) []Payload {
+	for i := 0; i < remaining; i++ {
+		batch = append(batch, <-h.aofChan)
+	}
+	replies := make([]Payload, 0)
+	for _, payload := range batch {
+		if payload.reply != nil {
+			replies = append(replies, payload)
+		} else {
+			h.handlePayload(payload)
+		}
+	}
+	if err := h.aofFile.Sync(); err != nil {
+		log.Errorf("aof fsync error: %v", err)
+	}
+	return replies
+}
+
+func (h *Handler) sendReplies(replies []Payload) {
+	for _, p := range replies {
+		p.conn.SendCommand(p.reply)
+	}
+	atomic.AddInt64(&h.pendingReplies, -int64(len(replies)))
+}
+
 func (h *Handler) handleRemaining(remaining int) {
 	for i := 0; i < remaining; i++ {
 		payload := <-h.aofChan
@@ -129,6 +250,15 @@ func (h *Handler) handleRemaining(remaining int) {
 }
 
 func (h *Handler) handlePayload(p Payload) {
+	h.bufferSize.Add(-p.size)
+	// 命令执行时间与上一条命令不同，先写入时间戳注释，同一毫秒内的命令共用一个注释
+	if p.timestamp != 0 && p.timestamp != h.lastTimestamp {
+		if _, err := h.aofFile.Write(timestampAnnotation(p.timestamp)); err != nil {
+			log.Errorf("aof write timestamp annotation error: %v", err)
+			return
+		}
+		h.lastTimestamp = p.timestamp
+	}
 	//当前数据库idx和payload不同，需要追加select命令
 	if p.idx != h.currentDB {
 		cmd := []string{"SELECT", strconv.Itoa(p.idx)}
@@ -146,32 +276,117 @@ func (h *Handler) handlePayload(p Payload) {
 	}
 }
 
-func (h *Handler) loadAof(maxBytes int64) error {
-	file, err := os.Open(h.aofFileName)
+// openManifest 读取manifest。第一次使用multi-part AOF时，旧的单文件AOF会作为base文件移入aof目录
+func (h *Handler) openManifest() error {
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+	path := manifestPath(h.dir, h.prefix)
+	m, err := loadManifest(path)
 	if err != nil {
-		log.Errorf("open aof file error: %v", err)
 		return err
 	}
+	if m == nil {
+		m = &manifest{incrs: []*aofInfo{{name: incrFileName(h.prefix, 1), seq: 1, fileType: aofTypeIncr}}}
+		if stat, err := os.Stat(config.Properties.AofFileName); err == nil && !stat.IsDir() {
+			m.base = &aofInfo{name: baseFileName(h.prefix, 1, aofFormat), seq: 1, fileType: aofTypeBase}
+		}
+		if file, err := os.OpenFile(h.filePath(m.lastIncr()), os.O_CREATE|os.O_WRONLY, 0666); err != nil {
+			return err
+		} else {
+			_ = file.Close()
+		}
+		// 先写入manifest再移动旧文件，移动前宕机时下次启动会继续完成移动
+		if err := m.persist(path); err != nil {
+			return err
+		}
+	}
+	h.manifest = m
+	return h.upgradeLegacyFile()
+}
+
+// upgradeLegacyFile 将旧的单文件AOF移动为manifest中的base文件
+func (h *Handler) upgradeLegacyFile() error {
+	legacy := config.Properties.AofFileName
+	if h.manifest.base == nil || h.manifest.base.seq != 1 {
+		return nil
+	}
+	if _, err := os.Stat(h.filePath(h.manifest.base)); err == nil {
+		return nil
+	}
+	if err := os.Rename(legacy, h.filePath(h.manifest.base)); err != nil {
+		return err
+	}
+	syncDir(h.dir)
+	log.Info("AOF file %s moved to %s as base file", legacy, h.filePath(h.manifest.base))
+	return nil
+}
+
+// filePath aof文件在目录中的路径
+func (h *Handler) filePath(info *aofInfo) string {
+	return filepath.Join(h.dir, info.name)
+}
+
+// loadFiles 按顺序加载aof文件。truncate 为true时，最后一个文件结尾不完整的命令按 aofLoadTruncated 配置处理。
+// 设置了 recoverUntil 时，读到晚于该时间的时间戳注释就停止加载，返回true
+func (h *Handler) loadFiles(files []*aofInfo, truncate bool) (bool, error) {
+	for i, info := range files {
+		stopped, err := h.loadFile(h.filePath(info), truncate && i == len(files)-1)
+		if err != nil {
+			return false, err
+		}
+		if stopped {
+			// base文件是重写时的快照，在它的中间停止会丢失恢复时间点之前的数据
+			if info.fileType == aofTypeBase {
+				return false, fmt.Errorf("recover point %s is earlier than the AOF base file %s", config.RecoverUntil, info.name)
+			}
+			log.Info("AOF loaded until recover point %s, stopped in %s", config.RecoverUntil, info.name)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadFile 加载一个aof文件。truncate 为true且开启 aofLoadTruncated 时，文件结尾不完整的命令会被截掉，
+// 其余的错误都返回包含损坏位置的错误，需要使用 check-aof 工具修复
+func (h *Handler) loadFile(path string, truncate bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Errorf("open aof file error: %v", err)
+		return false, err
+	}
 	defer func(file *os.File) {
 		_ = file.Close()
 	}(file)
-	var r io.Reader
-	if maxBytes > 0 {
-		r = io.LimitReader(file, maxBytes)
-	} else {
-		r = file
+	s := scanner.New(file)
+	// 文件以RDB的magic开头时，先加载RDB数据，之后的内容是普通的aof命令
+	if _, err := s.ReadPreamble(func(reader *bufio.Reader) error {
+		return h.rdbLoader(h.db, reader)
+	}); err != nil {
+		return false, fmt.Errorf("load rdb preamble of %s error: %v", path, err)
 	}
-	reader := bufio.NewReader(r)
-	//fake conn 用来记录当前的db index
+	//fake conn 用来记录当前的db index，每个文件都从0号数据库开始
 	fakeConn := tcp.Connection{}
 	for {
-		cmd, err := redis.Decode(reader)
-		if err != nil {
-			if err != io.EOF {
-				return err
+		cmd, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == scanner.ErrTruncated && truncate && config.Properties.AofLoadTruncated {
+			_ = file.Close()
+			if err := os.Truncate(path, s.Offset()); err != nil {
+				return false, fmt.Errorf("truncate aof file %s error: %v", path, err)
 			}
+			log.Errorf("AOF file %s is truncated, incomplete command at offset %d removed", path, s.Offset())
 			break
 		}
+		if err != nil {
+			return false, fmt.Errorf("bad aof file %s at offset %d, use check-aof to repair it: %v", path, s.Offset(), err)
+		}
+		// 时间戳注释在命令之前，注释的时间晚于恢复时间点时，之后的命令都不再加载
+		if h.recoverUntil != 0 && s.Timestamp() > h.recoverUntil {
+			return true, nil
+		}
 		if cmd.Name() == "select" {
 			idx, err := strconv.Atoi(string(cmd.Args()[0]))
 			if err != nil {
@@ -179,11 +394,11 @@ func (h *Handler) loadAof(maxBytes int64) error {
 			}
 			fakeConn.SelectDB(idx)
 		}
-		
+
 		cmd.BindConnection(&fakeConn)
 		h.db.Execute(cmd)
 	}
-	return nil
+	return false, nil
 }
 
 func (h *Handler) Close() {
@@ -0,0 +1,174 @@
+package aof
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"redigo/pkg/config"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/rdb/codec"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/conn"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memDB 测试使用的内存数据库，只支持 SET、DEL 和 PEXPIREAT
+type memDB struct {
+	data []map[string]*database.Entry
+	ttl  []map[string]*time.Time
+}
+
+func newMemDB() *memDB {
+	db := &memDB{}
+	for i := 0; i < config.Properties.Databases; i++ {
+		db.data = append(db.data, make(map[string]*database.Entry))
+		db.ttl = append(db.ttl, make(map[string]*time.Time))
+	}
+	return db
+}
+
+func (db *memDB) SubmitCommand(_ redis.Command) {}
+
+func (db *memDB) Close() {}
+
+func (db *memDB) ExecuteLoop() error { return nil }
+
+func (db *memDB) Execute(command redis.Command) *redis.RespCommand {
+	idx, args := command.Connection().DBIndex(), command.Args()
+	switch command.Name() {
+	case "set":
+		db.data[idx][string(args[0])] = database.NewEntry(string(args[0]), args[1])
+	case "del":
+		delete(db.data[idx], string(args[0]))
+		delete(db.ttl[idx], string(args[0]))
+	case "pexpireat":
+		ms, _ := strconv.ParseInt(string(args[1]), 10, 64)
+		expire := time.UnixMilli(ms)
+		db.ttl[idx][string(args[0])] = &expire
+	}
+	return redis.OKCommand
+}
+
+func (db *memDB) ForEach(dbIdx int, fun func(key string, entry *database.Entry, expire *time.Time) bool) {
+	for key, entry := range db.data[dbIdx] {
+		if !fun(key, entry, db.ttl[dbIdx][key]) {
+			return
+		}
+	}
+}
+
+func (db *memDB) Len(dbIdx int) int {
+	if dbIdx >= len(db.data) {
+		return 0
+	}
+	return len(db.data[dbIdx])
+}
+
+func (db *memDB) OnConnectionClosed(_ redis.Connection) {}
+
+// dump 将数据库内容转换成 "db:key=value" 的有序字符串，用于比较
+func (db *memDB) dump() string {
+	var items []string
+	for i := range db.data {
+		db.ForEach(i, func(key string, entry *database.Entry, expire *time.Time) bool {
+			item := strconv.Itoa(i) + ":" + key + "=" + string(entry.Data.([]byte))
+			if expire != nil {
+				item += "@" + strconv.FormatInt(expire.UnixMilli(), 10)
+			}
+			items = append(items, item)
+			return true
+		})
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// loadRDB 将RDB前缀中的数据加载到memDB
+func loadRDB(db database.DB, reader *bufio.Reader) error {
+	m := db.(*memDB)
+	return codec.NewDecoder(reader).Walk(func(object *codec.Object) error {
+		m.data[object.DB][object.Key] = database.NewEntry(object.Key, object.Value)
+		if object.ExpireAt != 0 {
+			expire := time.UnixMilli(object.ExpireAt)
+			m.ttl[object.DB][object.Key] = &expire
+		}
+		return nil
+	})
+}
+
+// setupAof 在临时目录中保存aof文件，测试结束后恢复配置
+func setupAof(t *testing.T) string {
+	dir := t.TempDir()
+	properties, recoverUntil := *config.Properties, config.RecoverUntil
+	t.Cleanup(func() {
+		*config.Properties, config.RecoverUntil = properties, recoverUntil
+	})
+	config.Properties.AppendDirName = filepath.Join(dir, "appendonlydir")
+	config.Properties.AofFileName = filepath.Join(dir, "appendonly.aof")
+	config.Properties.AppendFsync = config.FsyncNo
+	return dir
+}
+
+// openAof 打开aof并加载到db，测试结束时关闭
+func openAof(t *testing.T, db *memDB) *Handler {
+	h, err := NewAofHandler(db, func() database.DB { return newMemDB() }, loadRDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.Close()
+		_ = h.aofFile.Close()
+	})
+	return h
+}
+
+// write 在当前goroutine中把命令写入aof文件，timestamp 为命令的unix毫秒执行时间
+func write(h *Handler, idx int, timestamp int64, args ...string) {
+	h.aofLock.Lock()
+	defer h.aofLock.Unlock()
+	h.handlePayload(Payload{command: redis.NewStringArrayCommand(args).Parts(), idx: idx, timestamp: timestamp})
+}
+
+// receive 在超时之前从连接收到回复，并检查是否是期望的回复
+func receive(t *testing.T, c *conn.FakeConnection, expected *redis.RespCommand) {
+	select {
+	case reply := <-c.Replies:
+		if reply != expected {
+			t.Fatalf("expect reply %s, got %s", redis.Encode(expected), redis.Encode(reply))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reply %s not received", redis.Encode(expected))
+	}
+}
+
+// TestAlwaysGroupCommit always 策略下，回复在命令的aof记录写入文件并fsync之后发送，同一个连接的回复保持顺序
+func TestAlwaysGroupCommit(t *testing.T) {
+	setupAof(t)
+	config.Properties.AppendFsync = config.FsyncAlways
+	h := openAof(t, newMemDB())
+	c1, c2 := conn.NewFakeConnection(nil), conn.NewFakeConnection(nil)
+	r1, r2, r3 := redis.NewNumberCommand(1), redis.NewNumberCommand(2), redis.NewNumberCommand(3)
+
+	h.AddAof([][]byte{[]byte("set"), []byte("k1"), []byte("v1")}, 0)
+	h.SendReply(c1, r1)
+	h.AddAof([][]byte{[]byte("set"), []byte("k2"), []byte("v2")}, 0)
+	h.SendReply(c2, r2)
+	// 读命令没有aof记录，但是前面还有回复在等待fsync，也要排在它们之后
+	h.SendReply(c1, r3)
+
+	path := h.aofFile.Name()
+	// 回复通过没有缓冲的chan发送，收到回复时aof goroutine还没有继续执行，文件中已经有对应的记录
+	receive(t, c1, r1)
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "k1") {
+		t.Fatalf("reply sent before aof record written: %q", data)
+	}
+	receive(t, c2, r2)
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "k2") {
+		t.Fatalf("reply sent before aof record written: %q", data)
+	}
+	receive(t, c1, r3)
+}
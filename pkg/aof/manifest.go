@@ -0,0 +1,191 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+multi-part AOF：appendonlydir 目录中保存一个base文件、若干编号递增的incr文件和一个manifest文件。
+base文件是某次重写生成的数据快照（开启 aofUseRdbPreamble 时为RDB格式），incr文件按顺序记录之后的写命令，manifest记录当前有效的文件。
+重写时先切换到新的incr文件，快照完成后原子地替换manifest，再删除旧的base和incr文件。
+
+manifest的每一行描述一个文件，格式与Redis 7相同：
+	file appendonly.aof.1.base.aof seq 1 type b
+	file appendonly.aof.1.incr.aof seq 1 type i
+*/
+
+const (
+	aofTypeBase = "b"
+	aofTypeIncr = "i"
+
+	manifestSuffix = ".manifest"
+	baseSuffix     = ".base"
+	incrSuffix     = ".incr"
+	aofFormat      = ".aof"
+	rdbFormat      = ".rdb"
+)
+
+var errInvalidManifest = errors.New("invalid aof manifest")
+
+type aofInfo struct {
+	name     string
+	seq      int
+	fileType string
+}
+
+type manifest struct {
+	base  *aofInfo   // base 为空表示还没有进行过重写
+	incrs []*aofInfo // incrs 按seq从小到大排列，最后一个是正在写入的文件
+}
+
+// manifestPath manifest文件的路径
+func manifestPath(dir, prefix string) string {
+	return filepath.Join(dir, prefix+manifestSuffix)
+}
+
+// baseFileName 第seq个base文件的文件名，format 为 aofFormat 或 rdbFormat
+func baseFileName(prefix string, seq int, format string) string {
+	return prefix + "." + strconv.Itoa(seq) + baseSuffix + format
+}
+
+// incrFileName 第seq个incr文件的文件名
+func incrFileName(prefix string, seq int) string {
+	return prefix + "." + strconv.Itoa(seq) + incrSuffix + aofFormat
+}
+
+// loadManifest 读取manifest，文件不存在时返回nil
+func loadManifest(path string) (*manifest, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		info, err := parseManifestLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch info.fileType {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, errInvalidManifest
+			}
+			m.base = info
+		case aofTypeIncr:
+			if len(m.incrs) > 0 && m.incrs[len(m.incrs)-1].seq >= info.seq {
+				return nil, errInvalidManifest
+			}
+			m.incrs = append(m.incrs, info)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.incrs) == 0 {
+		return nil, errInvalidManifest
+	}
+	return m, nil
+}
+
+// parseManifestLine 解析 "file <name> seq <seq> type <type>"，key-value成对出现，顺序不限
+func parseManifestLine(line string) (*aofInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return nil, errInvalidManifest
+	}
+	info := &aofInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			info.name = fields[i+1]
+		case "seq":
+			seq, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return nil, errInvalidManifest
+			}
+			info.seq = seq
+		case "type":
+			info.fileType = fields[i+1]
+		}
+	}
+	// 文件名不能包含路径，避免读取或删除appendonlydir之外的文件
+	if info.name == "" || info.name != filepath.Base(info.name) {
+		return nil, errInvalidManifest
+	}
+	if info.fileType != aofTypeBase && info.fileType != aofTypeIncr {
+		return nil, errInvalidManifest
+	}
+	return info, nil
+}
+
+// files 需要按顺序加载的所有文件
+func (m *manifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// lastIncr 正在写入的incr文件
+func (m *manifest) lastIncr() *aofInfo {
+	return m.incrs[len(m.incrs)-1]
+}
+
+func (m *manifest) encode() []byte {
+	builder := strings.Builder{}
+	for _, info := range m.files() {
+		builder.WriteString(fmt.Sprintf("file %s seq %d type %s\n", info.name, info.seq, info.fileType))
+	}
+	return []byte(builder.String())
+}
+
+// persist 先写入临时文件并fsync，再rename覆盖旧的manifest，保证manifest的更新是原子的
+func (m *manifest) persist(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(m.encode()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir fsync目录，保证目录中文件的创建和rename已经落盘。部分平台不支持fsync目录，忽略错误
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
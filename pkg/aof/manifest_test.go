@@ -0,0 +1,69 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestPersist(t *testing.T) {
+	path := manifestPath(t.TempDir(), "appendonly.aof")
+	if m, err := loadManifest(path); m != nil || err != nil {
+		t.Fatalf("expect nil manifest before persist, got %v, err: %v", m, err)
+	}
+	m := &manifest{
+		base: &aofInfo{name: baseFileName("appendonly.aof", 2, rdbFormat), seq: 2, fileType: aofTypeBase},
+		incrs: []*aofInfo{
+			{name: incrFileName("appendonly.aof", 3), seq: 3, fileType: aofTypeIncr},
+			{name: incrFileName("appendonly.aof", 4), seq: 4, fileType: aofTypeIncr},
+		},
+	}
+	if err := m.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp manifest not renamed")
+	}
+	loaded, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.encode()) != string(m.encode()) {
+		t.Errorf("expect manifest:\n%s\ngot:\n%s", m.encode(), loaded.encode())
+	}
+	if loaded.lastIncr().name != "appendonly.aof.4.incr.aof" || loaded.base.name != "appendonly.aof.2.base.rdb" {
+		t.Errorf("unexpected file names: %s", loaded.encode())
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	contents := []string{
+		"",
+		"file appendonly.aof.1.base.aof seq 1 type b\n",
+		"file appendonly.aof.1.incr.aof seq 1\n",
+		"file appendonly.aof.1.incr.aof seq x type i\n",
+		"file appendonly.aof.1.incr.aof seq 1 type x\n",
+		"file ../appendonly.aof.1.incr.aof seq 1 type i\n",
+		"file a.base seq 1 type b\nfile b.base seq 2 type b\nfile c.incr seq 1 type i\n",
+		"file a.incr seq 2 type i\nfile b.incr seq 1 type i\n",
+	}
+	dir := t.TempDir()
+	for _, content := range contents {
+		path := filepath.Join(dir, "appendonly.aof.manifest")
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadManifest(path); err != errInvalidManifest {
+			t.Errorf("%q: expect invalid manifest error, got %v", content, err)
+		}
+	}
+	// 注释、空行和字段顺序不影响解析
+	content := "# comment\n\ntype i seq 1 file appendonly.aof.1.incr.aof\n"
+	path := filepath.Join(dir, "appendonly.aof.manifest")
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := loadManifest(path); err != nil || m.lastIncr().name != "appendonly.aof.1.incr.aof" {
+		t.Errorf("parse manifest error: %v", err)
+	}
+}
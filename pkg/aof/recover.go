@@ -0,0 +1,87 @@
+package aof
+
+import (
+	"fmt"
+	"os"
+	"redigo/pkg/aof/scanner"
+	"redigo/pkg/config"
+	"redigo/pkg/util/log"
+	"strconv"
+	"time"
+)
+
+/*
+按时间点恢复：开启 aofTimestampEnabled 后，写命令的执行时间与上一条命令不在同一毫秒时，aof中会先写入一行 #TS:<unix毫秒> 注释。
+启动时设置 recoverUntil，加载到第一条晚于该时间的注释就停止，恢复的精度是1毫秒，
+恢复时间点只要早于误操作命令的执行时间，误操作命令就不会被重放。
+只能恢复到最近一次重写之后的时间点，RDB格式的base文件没有时间戳注释，无法检查恢复时间点是否早于base文件。
+
+停止加载后，内存中的数据写入新的base文件，manifest只保留新的base和一个空的incr文件，
+原来的aof文件不会被删除，确认恢复结果后可以手动删除。
+*/
+
+// timestampAnnotation 时间戳注释
+func timestampAnnotation(timestamp int64) []byte {
+	return []byte(scanner.TimestampPrefix + strconv.FormatInt(timestamp, 10) + "\r\n")
+}
+
+// parseRecoverPoint 解析恢复时间点，支持unix毫秒、RFC3339和本地时区的 "2006-01-02 15:04:05"
+func parseRecoverPoint(value string) (int64, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms > 0 {
+		return ms, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid recover point: %s, use unix milliseconds or RFC3339 time", value)
+}
+
+// restartFromRecoveryPoint 将恢复后的数据写入新的base文件，并使用新的incr文件继续写入
+func (h *Handler) restartFromRecoveryPoint() error {
+	format := aofFormat
+	if config.Properties.AofUseRdbPreamble {
+		format = rdbFormat
+	}
+	baseSeq := 1
+	if h.manifest.base != nil {
+		baseSeq = h.manifest.base.seq + 1
+	}
+	base := &aofInfo{name: baseFileName(h.prefix, baseSeq, format), seq: baseSeq, fileType: aofTypeBase}
+	incrSeq := h.manifest.lastIncr().seq + 1
+	incr := &aofInfo{name: incrFileName(h.prefix, incrSeq), seq: incrSeq, fileType: aofTypeIncr}
+
+	tmpFile, err := os.CreateTemp(h.dir, "temp-recover-*"+format)
+	if err != nil {
+		return err
+	}
+	err = writeBase(tmpFile, h.db, format, h.recoverUntil)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	_ = tmpFile.Close()
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), h.filePath(base))
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return fmt.Errorf("write recovered aof base file error: %v", err)
+	}
+	if file, err := os.OpenFile(h.filePath(incr), os.O_CREATE|os.O_WRONLY, 0666); err != nil {
+		return err
+	} else {
+		_ = file.Close()
+	}
+	old := h.manifest.files()
+	m := &manifest{base: base, incrs: []*aofInfo{incr}}
+	if err := m.persist(manifestPath(h.dir, h.prefix)); err != nil {
+		return err
+	}
+	h.manifest = m
+	for _, info := range old {
+		log.Info("AOF file %s is no longer used after recovery, remove it manually once the recovered data is verified", info.name)
+	}
+	return nil
+}
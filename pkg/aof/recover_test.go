@@ -0,0 +1,101 @@
+package aof
+
+import (
+	"os"
+	"redigo/pkg/aof/scanner"
+	"redigo/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestParseRecoverPoint(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+	}{
+		{"1714530600123", 1714530600123},
+		{"2024-05-01T10:30:00+08:00", time.Date(2024, 5, 1, 2, 30, 0, 0, time.UTC).UnixMilli()},
+		{"2024-05-01 10:30:00", time.Date(2024, 5, 1, 10, 30, 0, 0, time.Local).UnixMilli()},
+	}
+	for _, test := range tests {
+		if ms, err := parseRecoverPoint(test.value); err != nil || ms != test.expected {
+			t.Errorf("%s: expect %d, got %d, err: %v", test.value, test.expected, ms, err)
+		}
+	}
+	for _, value := range []string{"", "0", "-1", "yesterday", "2024-05-01"} {
+		if _, err := parseRecoverPoint(value); err == nil {
+			t.Errorf("%s: expect error", value)
+		}
+	}
+}
+
+// TestTimestampAnnotation 执行时间不在同一毫秒的命令之前都有时间戳注释
+func TestTimestampAnnotation(t *testing.T) {
+	setupAof(t)
+	h := openAof(t, newMemDB())
+	write(h, 0, 1000, "set", "a", "1")
+	write(h, 0, 1000, "set", "b", "2")
+	write(h, 0, 1500, "set", "c", "3")
+	file, err := os.Open(h.aofFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	s := scanner.New(file)
+	var timestamps []int64
+	for {
+		if _, err := s.Next(); err != nil {
+			break
+		}
+		timestamps = append(timestamps, s.Timestamp())
+	}
+	// 第一条是 SELECT 0
+	expected := []int64{1000, 1000, 1000, 1500}
+	if len(timestamps) != len(expected) {
+		t.Fatalf("expect %d commands, got %d", len(expected), len(timestamps))
+	}
+	for i := range expected {
+		if timestamps[i] != expected[i] {
+			t.Errorf("command %d: expect timestamp %d, got %d", i, expected[i], timestamps[i])
+		}
+	}
+}
+
+// TestRecoverUntil 恢复时间点之后的命令不加载，恢复后的数据写入新的base文件，下次启动直接加载恢复后的数据
+func TestRecoverUntil(t *testing.T) {
+	setupAof(t)
+	config.Properties.AofTimestampEnabled = true
+	h := openAof(t, newMemDB())
+	write(h, 0, 1000, "set", "a", "1")
+	write(h, 1, 1000, "set", "b", "2")
+	// 与上一条命令在同一秒内执行的误删除
+	write(h, 1, 1300, "del", "b")
+	write(h, 0, 2000, "set", "c", "3")
+	oldIncr := h.filePath(h.manifest.lastIncr())
+
+	config.RecoverUntil = "1200"
+	db := newMemDB()
+	recovered := openAof(t, db)
+	if dump := db.dump(); dump != "0:a=1,1:b=2" {
+		t.Fatalf("recovered data: %s", dump)
+	}
+	m := recovered.manifest
+	if m.base == nil || m.base.seq != 1 || len(m.incrs) != 1 || m.incrs[0].seq != 2 {
+		t.Fatalf("unexpected manifest after recovery: %s", m.encode())
+	}
+	if _, err := os.Stat(oldIncr); err != nil {
+		t.Errorf("old incr file removed: %v", err)
+	}
+
+	config.RecoverUntil = ""
+	db = newMemDB()
+	reopened := openAof(t, db)
+	if dump := db.dump(); dump != "0:a=1,1:b=2" {
+		t.Errorf("data loaded after recovery: %s", dump)
+	}
+	// 新的base文件以恢复时间点的时间戳注释开头，更早的恢复时间点无法在base文件中间停止
+	reopened.recoverUntil = 1100
+	if _, err := reopened.loadFiles(reopened.manifest.files(), false); err == nil {
+		t.Errorf("expect error when recover point is earlier than the base file")
+	}
+}
@@ -1,10 +1,11 @@
 package aof
 
 import (
-	"io"
+	"bufio"
 	"os"
 	"redigo/pkg/config"
 	"redigo/pkg/interface/database"
+	"redigo/pkg/rdb"
 	"redigo/pkg/redis"
 	"redigo/pkg/util/log"
 	"strconv"
@@ -12,18 +13,20 @@ import (
 )
 
 type rewriteContext struct {
-	tmpFile     *os.File
-	currentDB   int
-	oldFileSize int64
+	tmpFile    *os.File
+	files      []*aofInfo // files 重写开始时manifest中的文件，重写完成后被新的base文件替代
+	incrSeq    int        // incrSeq 重写开始时切换到的incr文件序号，从它开始的incr文件保留在新的manifest中
+	baseSeq    int        // baseSeq 新的base文件序号
+	baseFormat string     // baseFormat 新的base文件格式，aofFormat 或 rdbFormat
+	timestamp  int64      // timestamp 切换incr文件的unix毫秒时间，base文件中的数据都在该时间之前写入
 }
 
 func (h *Handler) makeRewriteHandler() *Handler {
 	handler := &Handler{}
 	// 创建临时数据库来保存rewrie时新增的data
 	handler.db = h.dbMaker()
-	handler.aofFile = h.aofFile
-	handler.aofFileName = h.aofFileName
-	handler.currentDB = h.currentDB
+	handler.dir = h.dir
+	handler.rdbLoader = h.rdbLoader
 	return handler
 }
 
@@ -50,6 +53,8 @@ func (h *Handler) rewrite() error {
 	}
 
 	if err := h.doRewrite(context); err != nil {
+		_ = context.tmpFile.Close()
+		_ = os.Remove(context.tmpFile.Name())
 		return err
 	}
 
@@ -59,28 +64,46 @@ func (h *Handler) rewrite() error {
 func (h *Handler) doRewrite(ctx *rewriteContext) error {
 	tempAof := h.makeRewriteHandler()
 
-	if err := tempAof.loadAof(ctx.oldFileSize); err != nil {
+	if _, err := tempAof.loadFiles(ctx.files, false); err != nil {
 		return err
 	}
+	return writeBase(ctx.tmpFile, tempAof.db, ctx.baseFormat, ctx.timestamp)
+}
+
+// writeBase 将db中的数据写入base文件。开启 aofTimestampEnabled 时，AOF格式的base文件以时间戳注释开头
+func writeBase(file *os.File, db database.DB, format string, timestamp int64) error {
+	if format == rdbFormat {
+		// RDB格式的base文件，体积更小，加载时不需要重新执行命令
+		writer := bufio.NewWriter(file)
+		if err := rdb.Write(db, writer); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+	if config.Properties.AofTimestampEnabled {
+		if _, err := file.Write(timestampAnnotation(timestamp)); err != nil {
+			return err
+		}
+	}
 	for i := 0; i <= config.Properties.Databases; i++ {
 		// 跳过空数据库
-		if tempAof.db.Len(i) == 0 {
+		if db.Len(i) == 0 {
 			continue
 		}
 		// 插入select命令切换数据库
 		selectCmd := redis.NewStringArrayCommand([]string{"SELECT", strconv.Itoa(i)})
-		_, err := ctx.tmpFile.Write(selectCmd.ToBytes())
+		_, err := file.Write(selectCmd.ToBytes())
 		if err != nil {
 			return err
 		}
 		// 保存数据库keys
-		tempAof.db.ForEach(i, func(key string, entry *database.Entry, expire *time.Time) bool {
+		db.ForEach(i, func(key string, entry *database.Entry, expire *time.Time) bool {
 			command := EntryToCommand(key, entry)
 			if command != nil {
-				_, _ = ctx.tmpFile.Write(command.ToBytes())
+				_, _ = file.Write(command.ToBytes())
 				if expire != nil {
 					expireCommand := makeExpireCommand(key, expire)
-					_, _ = ctx.tmpFile.Write(expireCommand.ToBytes())
+					_, _ = file.Write(expireCommand.ToBytes())
 				}
 			}
 			return true
@@ -89,7 +112,8 @@ func (h *Handler) doRewrite(ctx *rewriteContext) error {
 	return nil
 }
 
-// prepareRewrite 初始化重写：创建aof临时文件和重写上下文
+// prepareRewrite 初始化重写：切换到新的incr文件并更新manifest，之后的写命令都写入新的incr文件，
+// 重写只需要读取切换前的文件
 func (h *Handler) prepareRewrite() (*rewriteContext, error) {
 	h.aofLock.Lock()
 	defer h.aofLock.Unlock()
@@ -97,58 +121,76 @@ func (h *Handler) prepareRewrite() (*rewriteContext, error) {
 	if err := h.aofFile.Sync(); err != nil {
 		return nil, err
 	}
-	stat, err := os.Stat(h.aofFileName)
+	files := h.manifest.files()
+	incr := &aofInfo{name: incrFileName(h.prefix, h.manifest.lastIncr().seq+1), seq: h.manifest.lastIncr().seq + 1, fileType: aofTypeIncr}
+	aofFile, err := os.OpenFile(h.filePath(incr), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return nil, err
 	}
-	size := stat.Size()
-	file, err := os.CreateTemp("./", "*.aof")
+	h.manifest.incrs = append(h.manifest.incrs, incr)
+	if err := h.manifest.persist(manifestPath(h.dir, h.prefix)); err != nil {
+		h.manifest.incrs = h.manifest.incrs[:len(h.manifest.incrs)-1]
+		_ = aofFile.Close()
+		_ = os.Remove(aofFile.Name())
+		return nil, err
+	}
+	_ = h.aofFile.Close()
+	h.aofFile = aofFile
+	// 新文件从0号数据库开始加载，第一条命令前需要插入select，并且以时间戳注释开头
+	h.currentDB = -1
+	h.lastTimestamp = 0
+	timestamp := time.Now().UnixMilli()
+
+	baseFormat := aofFormat
+	if config.Properties.AofUseRdbPreamble {
+		baseFormat = rdbFormat
+	}
+	file, err := os.CreateTemp(h.dir, "temp-rewrite-*"+baseFormat)
 	if err != nil {
 		return nil, err
 	}
+	baseSeq := 1
+	if h.manifest.base != nil {
+		baseSeq = h.manifest.base.seq + 1
+	}
 	return &rewriteContext{
-		tmpFile:     file,
-		currentDB:   h.currentDB,
-		oldFileSize: size,
+		tmpFile:    file,
+		files:      files,
+		incrSeq:    incr.seq,
+		baseSeq:    baseSeq,
+		baseFormat: baseFormat,
+		timestamp:  timestamp,
 	}, nil
 }
 
-// finishRewrite 重写结束，将旧aof文件中的新追加数据添加到新文件，然后将旧aof文件删除
+// finishRewrite 重写结束，临时文件成为新的base文件，原子地更新manifest后删除旧的base和incr文件
 func (h *Handler) finishRewrite(ctx *rewriteContext) error {
-	h.aofLock.Lock()
-	defer h.aofLock.Unlock()
-
-	src, err := os.Open(h.aofFileName)
-	defer func() {
-		_ = src.Close()
-	}()
-	if err != nil {
-		log.Errorf("Open old aof file failed: %v", err)
+	if err := ctx.tmpFile.Sync(); err != nil {
 		return err
 	}
-	// seek到旧文件的末尾
-	if _, err := src.Seek(ctx.oldFileSize, 0); err != nil {
-		log.Errorf("Seek offset in old aof file failed %v", err)
+	_ = ctx.tmpFile.Close()
+	base := &aofInfo{name: baseFileName(h.prefix, ctx.baseSeq, ctx.baseFormat), seq: ctx.baseSeq, fileType: aofTypeBase}
+	if err := os.Rename(ctx.tmpFile.Name(), h.filePath(base)); err != nil {
 		return err
 	}
-	// 切换到服务器正在使用的dbIdx，插入一条select
-	selectDbCommand := redis.NewStringArrayCommand([]string{"SELECT", strconv.Itoa(ctx.currentDB)})
-	_, _ = ctx.tmpFile.Write(selectDbCommand.ToBytes())
-	// 将旧文件的数据转移到新文件末尾
-	_, err = io.Copy(ctx.tmpFile, src)
-
-	_ = src.Close()
-	_ = h.aofFile.Close()
-	_ = ctx.tmpFile.Close()
-	_ = os.Rename(ctx.tmpFile.Name(), h.aofFileName)
 
-	// 重新设置服务器的aof文件
-	aofFile, err := os.OpenFile(h.aofFileName, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
-	if err != nil {
+	h.aofLock.Lock()
+	defer h.aofLock.Unlock()
+	m := &manifest{base: base}
+	for _, incr := range h.manifest.incrs {
+		if incr.seq >= ctx.incrSeq {
+			m.incrs = append(m.incrs, incr)
+		}
+	}
+	if err := m.persist(manifestPath(h.dir, h.prefix)); err != nil {
+		_ = os.Remove(h.filePath(base))
 		return err
 	}
-	h.aofFile = aofFile
-	selectDbCommand = redis.NewStringArrayCommand([]string{"SELECT", strconv.Itoa(h.currentDB)})
-	_, _ = ctx.tmpFile.Write(selectDbCommand.ToBytes())
+	h.manifest = m
+	for _, info := range ctx.files {
+		if err := os.Remove(h.filePath(info)); err != nil {
+			log.Errorf("remove old aof file %s failed: %v", info.name, err)
+		}
+	}
 	return nil
 }
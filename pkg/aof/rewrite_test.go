@@ -0,0 +1,154 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"redigo/pkg/config"
+	"redigo/pkg/rdb/codec"
+	"redigo/pkg/redis"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestRewrite 重写开始时切换到新的incr文件，之后的命令写入新文件；重写结束后manifest只保留新的base和切换后的incr文件，旧文件被删除
+func TestRewrite(t *testing.T) {
+	setupAof(t)
+	h := openAof(t, newMemDB())
+	write(h, 0, 0, "set", "a", "1")
+	write(h, 1, 0, "set", "b", "2")
+	oldFiles := h.manifest.files()
+
+	ctx, err := h.prepareRewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted, err := loadManifest(manifestPath(h.dir, h.prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted.incrs) != 2 || persisted.lastIncr().seq != 2 || h.aofFile.Name() != h.filePath(persisted.lastIncr()) {
+		t.Fatalf("incr file not switched before rewrite, manifest: %s", persisted.encode())
+	}
+	// 重写期间的命令写入新的incr文件，从0号数据库开始需要重新select
+	write(h, 1, 0, "set", "c", "3")
+	write(h, 0, 0, "del", "a")
+	if err := h.doRewrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.finishRewrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	persisted, err = loadManifest(manifestPath(h.dir, h.prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted.base == nil || persisted.base.seq != 1 || len(persisted.incrs) != 1 || persisted.lastIncr().seq != 2 {
+		t.Fatalf("unexpected manifest after rewrite: %s", persisted.encode())
+	}
+	for _, info := range oldFiles {
+		if _, err := os.Stat(h.filePath(info)); !os.IsNotExist(err) {
+			t.Errorf("old aof file %s not removed", info.name)
+		}
+	}
+	entries, _ := os.ReadDir(h.dir)
+	if len(entries) != 3 {
+		t.Errorf("expect manifest, base and incr file in aof dir, got %d files", len(entries))
+	}
+
+	db := newMemDB()
+	openAof(t, db)
+	if dump := db.dump(); dump != "1:b=2,1:c=3" {
+		t.Errorf("data loaded after rewrite: %s", dump)
+	}
+}
+
+// TestRewriteInterrupted 重写在替换manifest之前中断时，manifest依然指向旧文件，数据完整
+func TestRewriteInterrupted(t *testing.T) {
+	setupAof(t)
+	h := openAof(t, newMemDB())
+	write(h, 0, 0, "set", "a", "1")
+	ctx, err := h.prepareRewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(h, 0, 0, "set", "b", "2")
+	// 模拟宕机：临时base文件已经写入，但还没有替换manifest
+	if err := h.doRewrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = ctx.tmpFile.Close()
+
+	db := newMemDB()
+	reopened := openAof(t, db)
+	if dump := db.dump(); dump != "0:a=1,0:b=2" {
+		t.Errorf("data loaded after interrupted rewrite: %s", dump)
+	}
+	if reopened.manifest.base != nil || len(reopened.manifest.incrs) != 2 {
+		t.Errorf("unexpected manifest: %s", reopened.manifest.encode())
+	}
+}
+
+// TestUpgradeLegacyFile 旧的单文件AOF作为base文件移入aof目录
+func TestUpgradeLegacyFile(t *testing.T) {
+	setupAof(t)
+	legacy := redis.NewStringArrayCommand([]string{"set", "a", "1"}).ToBytes()
+	legacy = append(legacy, redis.NewStringArrayCommand([]string{"select", "2"}).ToBytes()...)
+	legacy = append(legacy, redis.NewStringArrayCommand([]string{"set", "b", "2"}).ToBytes()...)
+	if err := os.WriteFile(config.Properties.AofFileName, legacy, 0666); err != nil {
+		t.Fatal(err)
+	}
+	db := newMemDB()
+	h := openAof(t, db)
+	if dump := db.dump(); dump != "0:a=1,2:b=2" {
+		t.Errorf("data loaded from legacy aof: %s", dump)
+	}
+	if h.manifest.base == nil || h.manifest.base.name != "appendonly.aof.1.base.aof" {
+		t.Fatalf("legacy aof not used as base file: %s", h.manifest.encode())
+	}
+	if _, err := os.Stat(config.Properties.AofFileName); !os.IsNotExist(err) {
+		t.Errorf("legacy aof file not moved")
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, h.manifest.base.name)); err != nil {
+		t.Errorf("base file not found: %v", err)
+	}
+
+	// manifest已经写入但还没有移动旧文件时宕机，下次启动继续完成移动
+	_ = os.Rename(filepath.Join(h.dir, h.manifest.base.name), config.Properties.AofFileName)
+	db = newMemDB()
+	openAof(t, db)
+	if dump := db.dump(); dump != "0:a=1,2:b=2" {
+		t.Errorf("data loaded after interrupted upgrade: %s", dump)
+	}
+}
+
+// TestRewriteRdbPreamble RDB格式的base文件和之后的incr文件加载后与重写前的数据相同
+func TestRewriteRdbPreamble(t *testing.T) {
+	setupAof(t)
+	config.Properties.AofUseRdbPreamble = true
+	h := openAof(t, newMemDB())
+	expire := time.Now().Add(time.Hour).UnixMilli()
+	write(h, 0, 0, "set", "a", "1")
+	write(h, 0, 0, "pexpireat", "a", strconv.FormatInt(expire, 10))
+	write(h, 3, 0, "set", "b", "2")
+	if err := h.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	base := h.filePath(h.manifest.base)
+	if filepath.Ext(base) != rdbFormat {
+		t.Fatalf("expect rdb base file, got %s", base)
+	}
+	if data, err := os.ReadFile(base); err != nil || !codec.IsRDB(data) {
+		t.Fatalf("base file does not start with rdb header, err: %v", err)
+	}
+	write(h, 3, 0, "set", "c", "3")
+	write(h, 3, 0, "del", "b")
+
+	db := newMemDB()
+	openAof(t, db)
+	expected := "0:a=1@" + strconv.FormatInt(expire, 10) + ",3:c=3"
+	if dump := db.dump(); dump != expected {
+		t.Errorf("expect %s, got %s", expected, dump)
+	}
+}
@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"redigo/pkg/rdb/codec"
+	"redigo/pkg/redis"
+	"strconv"
+)
+
+// ErrTruncated 文件在一条命令的中间结束，通常是写入aof时宕机导致的
+var ErrTruncated = errors.New("unexpected end of aof file")
+
+// TimestampPrefix 时间戳注释的前缀，注释格式为 #TS:<unix毫秒>\r\n，表示之后的命令在该时间之后写入
+const TimestampPrefix = "#TS:"
+
+/*
+Scanner 按顺序读取aof文件中的命令，并记录已经完整读取的数据长度。
+读取失败时 Offset 就是第一条不完整或损坏的记录的位置，截断到该位置即可去掉损坏的部分。
+*/
+type Scanner struct {
+	file      *os.File
+	reader    *bufio.Reader
+	offset    int64 // offset 最后一条完整记录结束的位置
+	timestamp int64 // timestamp 最后读取的时间戳注释，0表示还没有读到
+}
+
+func New(file *os.File) *Scanner {
+	return &Scanner{file: file, reader: bufio.NewReader(file)}
+}
+
+// ReadPreamble 文件以redigo或Redis的RDB文件头开头时，调用load读取RDB数据，返回文件是否有RDB前缀
+func (s *Scanner) ReadPreamble(load func(reader *bufio.Reader) error) (bool, error) {
+	magic, err := s.reader.Peek(len(codec.MagicNum))
+	if err != nil || !codec.IsRDB(magic) {
+		return false, nil
+	}
+	if err := load(s.reader); err != nil {
+		return true, err
+	}
+	s.offset = s.position()
+	return true, nil
+}
+
+// Next 读取下一条命令，跳过 # 开头的注释行。文件正常结束时返回 io.EOF，文件在命令中间结束时返回 ErrTruncated
+func (s *Scanner) Next() (*redis.RespCommand, error) {
+	if err := s.skipAnnotations(); err != nil {
+		return nil, err
+	}
+	// 使用阻塞读取，避免命令跨越bufio缓冲区边界时被当作文件结束
+	cmd, err := redis.Decode(redis.NewBlockingReader(s.reader))
+	if err == io.EOF {
+		if s.position() > s.offset {
+			return nil, ErrTruncated
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.offset = s.position()
+	return cmd, nil
+}
+
+// skipAnnotations 读取命令之前的注释行，记录其中的时间戳
+func (s *Scanner) skipAnnotations() error {
+	for {
+		if b, err := s.reader.Peek(1); err != nil || b[0] != '#' {
+			return nil
+		}
+		line, err := s.reader.ReadBytes('\n')
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if bytes.HasPrefix(line, []byte(TimestampPrefix)) {
+			if ts, err := strconv.ParseInt(string(line[len(TimestampPrefix):]), 10, 64); err == nil {
+				s.timestamp = ts
+			}
+		}
+		s.offset = s.position()
+	}
+}
+
+// Timestamp 最后读取的时间戳注释，unix毫秒，0表示还没有读到时间戳注释
+func (s *Scanner) Timestamp() int64 {
+	return s.timestamp
+}
+
+// Offset 已经完整读取的数据长度
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// position 已经从reader中读出的数据长度，即文件的读取位置减去缓冲区中剩余的数据
+func (s *Scanner) position() int64 {
+	pos, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.offset
+	}
+	return pos - int64(s.reader.Buffered())
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"redigo/pkg/redis"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, data []byte) *os.File {
+	path := filepath.Join(t.TempDir(), "test.aof")
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = file.Close()
+	})
+	return file
+}
+
+func TestScanner_Next(t *testing.T) {
+	set := redis.NewStringArrayCommand([]string{"set", "key", "value"}).ToBytes()
+	del := redis.NewStringArrayCommand([]string{"del", "key"}).ToBytes()
+	s := New(writeTempFile(t, append(append([]byte{}, set...), del...)))
+	for i := 0; i < 2; i++ {
+		if _, err := s.Next(); err != nil {
+			t.Fatalf("read command %d error: %v", i, err)
+		}
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("expect io.EOF, got: %v", err)
+	}
+	if s.Offset() != int64(len(set)+len(del)) {
+		t.Errorf("expect offset: %d, got: %d", len(set)+len(del), s.Offset())
+	}
+}
+
+func TestScanner_Truncated(t *testing.T) {
+	set := redis.NewStringArrayCommand([]string{"set", "key", "value"}).ToBytes()
+	// 第二条命令在bulk string中间结束
+	data := append(append([]byte{}, set...), set[:len(set)-4]...)
+	s := New(writeTempFile(t, data))
+	if _, err := s.Next(); err != nil {
+		t.Fatalf("read command error: %v", err)
+	}
+	if _, err := s.Next(); err != ErrTruncated {
+		t.Errorf("expect ErrTruncated, got: %v", err)
+	}
+	if s.Offset() != int64(len(set)) {
+		t.Errorf("expect offset: %d, got: %d", len(set), s.Offset())
+	}
+}
+
+func TestScanner_Corrupted(t *testing.T) {
+	set := redis.NewStringArrayCommand([]string{"set", "key", "value"}).ToBytes()
+	data := append(append(append([]byte{}, set...), []byte("garbage\r\n")...), set...)
+	s := New(writeTempFile(t, data))
+	if _, err := s.Next(); err != nil {
+		t.Fatalf("read command error: %v", err)
+	}
+	if _, err := s.Next(); err == nil || err == io.EOF || err == ErrTruncated {
+		t.Errorf("expect protocol error, got: %v", err)
+	}
+	if s.Offset() != int64(len(set)) {
+		t.Errorf("expect offset: %d, got: %d", len(set), s.Offset())
+	}
+}
+
+func TestScanner_Timestamp(t *testing.T) {
+	set := redis.NewStringArrayCommand([]string{"set", "key", "value"}).ToBytes()
+	data := append([]byte("#TS:1700000000000\r\n"), set...)
+	data = append(append(data, []byte("#TS:1700000001000\r\n#other annotation\r\n")...), set...)
+	s := New(writeTempFile(t, data))
+	for _, expect := range []int64{1700000000000, 1700000001000} {
+		if _, err := s.Next(); err != nil {
+			t.Fatalf("read command error: %v", err)
+		}
+		if s.Timestamp() != expect {
+			t.Errorf("expect timestamp: %d, got: %d", expect, s.Timestamp())
+		}
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("expect io.EOF, got: %v", err)
+	}
+	// 注释行没有结束时按不完整的记录处理
+	s = New(writeTempFile(t, append(append([]byte{}, set...), []byte("#TS:17")...)))
+	if _, err := s.Next(); err != nil {
+		t.Fatalf("read command error: %v", err)
+	}
+	if _, err := s.Next(); err != ErrTruncated || s.Offset() != int64(len(set)) {
+		t.Errorf("expect ErrTruncated at %d, got: %v at %d", len(set), err, s.Offset())
+	}
+}
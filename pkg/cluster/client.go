@@ -2,83 +2,254 @@ package cluster
 
 import (
 	"bufio"
-	"context"
 	"net"
 	"redigo/pkg/redis"
 	"redigo/pkg/util/log"
-	"redigo/pkg/util/pool"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	dialTimeout  = time.Second
+	relayTimeout = 5 * time.Second
+
+	peerConnections     = 2                      // peerConnections 与每个节点建立的长连接数量
+	maxPendingRequests  = 1024                   // maxPendingRequests 每个连接上最多等待回复的请求数量
+	healthCheckInterval = time.Second            // healthCheckInterval 连接健康检查的间隔
+	minReconnectBackoff = 100 * time.Millisecond // minReconnectBackoff 断线重连的初始等待时间
+	maxReconnectBackoff = 5 * time.Second        // maxReconnectBackoff 断线重连的最大等待时间
+)
+
+// PeerClient 与一个节点之间的连接，请求在少量长连接上以pipeline的方式发送
 type PeerClient struct {
-	connPool       *pool.Pool
-	maxConnections int
-	peerAddr       string
+	conns    []*PeerConn
+	next     uint32
+	peerAddr string
 }
 
+// PeerConn 节点之间的一条长连接。
+// 请求按照发送的顺序进入pending队列，对方按顺序回复，读取回复的goroutine按FIFO顺序将回复交给请求。
+// 连接断开后自动以指数退避的方式重连，并定期发送PING检查连接是否可用
 type PeerConn struct {
-	Conn    net.Conn
-	pending chan *redis.RespCommand
+	addr    string
+	conn    net.Conn
+	pending chan *peerRequest
 	sync.Mutex
+	done      chan struct{}
+	firstDial chan struct{} // firstDial 第一次建立连接的尝试结束后关闭
+}
+
+// peerRequest 等待回复的请求
+type peerRequest struct {
+	reply chan *redis.RespCommand
 }
 
-func NewPeerClient(peerAddr string, maxConns int) *PeerClient {
+func NewPeerClient(peerAddr string, connections int) *PeerClient {
 	pc := &PeerClient{
-		peerAddr:       peerAddr,
-		maxConnections: maxConns,
+		peerAddr: peerAddr,
+		conns:    make([]*PeerConn, connections),
+	}
+	for i := range pc.conns {
+		pc.conns[i] = &PeerConn{addr: peerAddr, done: make(chan struct{}), firstDial: make(chan struct{})}
+		go pc.conns[i].maintain()
 	}
-	pc.connPool = pool.Empty(maxConns, func() interface{} {
-		conn := connect(peerAddr)
-		return conn
-	})
 	return pc
 }
 
-// connect 与peer建立新的连接
-func connect(addr string) *PeerConn {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		log.Errorf("connect to peer server failed: %v", err)
-		return nil
+// maintain 建立连接并持续检查连接状态，连接断开后重连，直到连接被关闭
+func (c *PeerConn) maintain() {
+	backoff := minReconnectBackoff
+	var firstDial sync.Once
+	for {
+		conn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+		if err != nil {
+			firstDial.Do(func() { close(c.firstDial) })
+			// 只在第一次失败时记录日志，避免节点下线期间的重连产生大量日志
+			if backoff == minReconnectBackoff {
+				log.Errorf("connect to peer server failed: %v", err)
+			}
+			select {
+			case <-time.After(backoff):
+			case <-c.done:
+				return
+			}
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		backoff = minReconnectBackoff
+		pending := make(chan *peerRequest, maxPendingRequests)
+		c.Lock()
+		c.conn, c.pending = conn, pending
+		c.Unlock()
+		// 连接可用之后再通知，保证等待第一次连接的请求可以使用这个连接
+		firstDial.Do(func() { close(c.firstDial) })
+		readDone := make(chan struct{})
+		go func() {
+			c.readLoop(conn, pending)
+			close(readDone)
+		}()
+		c.healthCheck(readDone)
+		// 不再接受新的请求，等待读取结束后通知所有未收到回复的请求
+		c.Lock()
+		c.conn, c.pending = nil, nil
+		c.Unlock()
+		_ = conn.Close()
+		<-readDone
+		for len(pending) > 0 {
+			request := <-pending
+			request.reply <- redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+			log.Errorf("connection to peer %s lost, reconnecting", c.addr)
+		}
+	}
+}
+
+// readLoop 读取回复，按照FIFO的顺序交给等待的请求
+func (c *PeerConn) readLoop(conn net.Conn, pending chan *peerRequest) {
+	reader := redis.NewBlockingReader(bufio.NewReader(conn))
+	for {
+		reply, err := redis.Decode(reader)
+		if err != nil {
+			return
+		}
+		select {
+		case request := <-pending:
+			request.reply <- reply
+		default:
+			// 没有请求却收到了回复，连接的状态已经无法确定
+			log.Errorf("unexpected reply from peer %s", c.addr)
+			return
+		}
+	}
+}
+
+// healthCheck 定期发送PING，连接断开、PING失败或连接关闭时返回
+func (c *PeerConn) healthCheck(readDone chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-readDone:
+			return
+		case <-c.done:
+			return
+		case <-ticker.C:
+			ping := redis.NewStringArrayCommand([]string{"ping"})
+			ping.SetFromCluster(true)
+			if reply := c.send(ping, relayTimeout); reply.Type() == redis.CommandTypeError {
+				log.Errorf("health check of peer %s failed: %s", c.addr, errorMessage(reply))
+				return
+			}
+		}
 	}
-	return &PeerConn{Conn: conn}
 }
 
-func (c *PeerConn) sendCommand(ctx context.Context, command redis.Command) *redis.RespCommand {
+// send 发送请求并等待回复，多个请求可以同时在一个连接上等待回复
+func (c *PeerConn) send(command redis.Command, timeout time.Duration) *redis.RespCommand {
 	// 将command转换为RESP字节流
 	payload := command.ToBytes()
-	// 设置网络发送和接收的deadline
-	if deadline, ok := ctx.Deadline(); ok {
-		_ = c.Conn.SetWriteDeadline(deadline)
-		_ = c.Conn.SetReadDeadline(deadline)
-	}
-	// 发送给peer
-	_, err := c.Conn.Write(payload)
-	if err != nil {
+	request := &peerRequest{reply: make(chan *redis.RespCommand, 1)}
+	c.Lock()
+	if c.conn == nil {
+		c.Unlock()
 		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
 	}
-	// 等待、读取、解析回复
-	reader := bufio.NewReader(c.Conn)
-	parsed, err := redis.Decode(reader)
-	// 网络接收超时或解析发生错误
+	// 入队和写入在同一个锁中完成，保证pending的顺序与发送顺序相同
+	select {
+	case c.pending <- request:
+	default:
+		c.Unlock()
+		return redis.NewErrorCommand(redis.ClusterPeerBusyError)
+	}
+	conn := c.conn
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := conn.Write(payload)
+	c.Unlock()
 	if err != nil {
-		log.Errorf("parse peer reply error: %v", err)
+		// 关闭连接，由maintain通知等待中的请求并重连
+		log.Errorf("relay command to peer %s error: %v", c.addr, err)
+		_ = conn.Close()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-request.reply:
+		return reply
+	case <-timer.C:
+		// 超时的请求仍然在pending中，迟到的回复会被丢弃，不影响后续请求的匹配
 		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
 	}
-	return parsed
+}
+
+// connected 连接是否可用
+func (c *PeerConn) connected() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.conn != nil
 }
 
 // RelayCommand 转发消息到目标peer，并等待结果
 func (pc *PeerClient) RelayCommand(command redis.Command) *redis.RespCommand {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelFunc()
-	// 获取一个连接，等待超时或连接失败都会返回nil
-	c := pc.connPool.Load(ctx)
-	conn := c.(*PeerConn)
+	return pc.relay(command, relayTimeout)
+}
+
+func (pc *PeerClient) relay(command redis.Command, timeout time.Duration) *redis.RespCommand {
+	deadline := time.Now().Add(timeout)
+	conn := pc.pickConn()
 	if conn == nil {
-		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+		// 刚加入的节点还在建立第一个连接，例如 MEET 之后立即发送的请求，在超时时间内等待第一次连接的结果。
+		// 已经断开的节点不等待，由后台重连，请求立即失败
+		if conn = pc.waitFirstDial(timeout); conn == nil {
+			return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+		}
+	}
+	// 在副本上标记为集群节点发送的命令，目标节点直接在本地执行，不再路由。
+	// 不能修改调用者的命令，转发失败后调用者可能还要在本地执行或者转发到其他节点
+	relayed := copyCommand(command, command.Parts())
+	relayed.SetFromCluster(true)
+	return conn.send(relayed, time.Until(deadline))
+}
+
+// pickConn 轮流使用可用的连接，没有可用的连接时返回nil
+func (pc *PeerClient) pickConn() *PeerConn {
+	start := atomic.AddUint32(&pc.next, 1)
+	for i := 0; i < len(pc.conns); i++ {
+		if c := pc.conns[(int(start)+i)%len(pc.conns)]; c.connected() {
+			return c
+		}
+	}
+	return nil
+}
+
+// waitFirstDial 依次等待各个连接完成第一次连接尝试，返回建立成功的连接，超时或者全部失败时返回nil
+func (pc *PeerClient) waitFirstDial(timeout time.Duration) *PeerConn {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, c := range pc.conns {
+		select {
+		case <-c.firstDial:
+		case <-c.done:
+			return nil
+		case <-timer.C:
+			return nil
+		}
+		if c.connected() {
+			return c
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有连接，停止重连和健康检查
+func (pc *PeerClient) Close() {
+	for _, c := range pc.conns {
+		close(c.done)
 	}
-	defer pc.connPool.Put(c)
-	return conn.sendCommand(ctx, command)
 }
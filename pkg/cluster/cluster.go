@@ -6,31 +6,70 @@ import (
 	"redigo/pkg/redis"
 	"redigo/pkg/tcp"
 	"redigo/pkg/util/log"
+	"sync"
 	"time"
 )
 
 type Cluster struct {
 	multiDB  database.DB
 	peers    map[string]*PeerClient
-	selector peer.Selector
+	selector *peer.SlotSelector
 	address  string
-	server   tcp.Server // 集群模式的节点server，不对客户端开放，只在集群内部使用
+	server   tcp.Server // 集群模式的节点server，用于节点之间转发命令和gossip通信
+
+	nodes        map[string]*clusterNode   // nodes 节点表，包括当前节点，通过gossip在运行时更新
+	currentEpoch int64                     // currentEpoch 集群中已知的最大纪元
+	forgotten    map[string]time.Time      // forgotten 被 CLUSTER FORGET 删除的节点和删除时间
+	migrating    map[int]string            // migrating 正在迁出的slot，以及迁移的目标节点
+	importing    map[int]string            // importing 正在迁入的slot，以及迁移的源节点
+	asking       map[redis.Connection]bool // asking 发送了 ASKING 的连接，只对下一条命令有效
+	mutex        sync.RWMutex
+	done         chan struct{}
+	closeOnce    sync.Once
+
+	transactions map[string]*transaction // transactions 本节点参与的分布式事务
+	lockedKeys   map[string]string       // lockedKeys 被分布式事务锁定的key，以及锁定的事务ID
+	txSeq        uint64                  // txSeq 本节点作为协调者时的事务序号
+	txMutex      sync.Mutex
+}
+
+// busRouter 来自集群节点、由集群自身处理而不交给本地数据库的命令
+var busRouter = map[string]CommandHandler{
+	"cluster": execCluster,
+	"gossip":  execGossip,
 }
 
 func NewCluster(db database.DB, address string, peers []string) *Cluster {
 	c := &Cluster{
 		multiDB:  db,
 		peers:    make(map[string]*PeerClient),
-		selector: peer.NewConsistentHashSelector(),
+		selector: peer.NewSlotSelector(),
 		address:  address,
-		server:   tcp.NewServer(address, db),
+
+		nodes:     make(map[string]*clusterNode),
+		forgotten: make(map[string]time.Time),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+		asking:    make(map[redis.Connection]bool),
+		done:      make(chan struct{}),
+
+		transactions: make(map[string]*transaction),
+		lockedKeys:   make(map[string]string),
+	}
+	// 集群内部server同样可以处理客户端命令，使用slot路由的客户端可以直接连接集群地址
+	c.server = tcp.NewServer(address, &busHandler{Cluster: c})
+	// 配置文件中的节点组成初始集群，纪元为1，大于单独启动的节点，单独启动的节点加入集群后会让出slot
+	if len(peers) > 0 {
+		c.currentEpoch = 1
 	}
 	for _, peer := range peers {
 		c.selector.AddPeer(peer)
-		// TODO 设置最大连接数量
-		c.peers[peer] = NewPeerClient(peer, 10)
+		c.addNode(peer, peer).configEpoch = c.currentEpoch
 	}
 	c.selector.AddPeer(address)
+	myself := newClusterNode(address, announceAddress(address))
+	myself.configEpoch = c.currentEpoch
+	c.nodes[address] = myself
 	return c
 }
 
@@ -42,12 +81,21 @@ func (c *Cluster) SubmitCommand(command redis.Command) {
 	}
 }
 
+// Close 停止gossip、关闭节点连接和本地数据库，对外server和集群内部server关闭时都会调用
 func (c *Cluster) Close() {
-	// TODO Close Embed Database and Peer Clients
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.server.Close()
+		for _, client := range c.Peers() {
+			client.Close()
+		}
+		c.multiDB.Close()
+	})
 }
 
 func (c *Cluster) ExecuteLoop() error {
 	log.Info("redigo cluster server started, listening: %s", c.address)
+	go c.gossipLoop()
 	// 集群内部服务器启动，同时触发multiDB的启动
 	return c.server.Start()
 }
@@ -55,14 +103,39 @@ func (c *Cluster) ExecuteLoop() error {
 func (c *Cluster) Execute(command redis.Command) *redis.RespCommand {
 	// 命令来自集群节点，调用本地数据库执行
 	if command.IsFromCluster() {
-		c.multiDB.SubmitCommand(command)
-		return nil
+		if handler, ok := busRouter[command.Name()]; ok {
+			return handler(c, command)
+		}
+		if reply, ok := c.forwardMigrated(command); ok {
+			return reply
+		}
+		if err := c.checkLocked(command); err != nil {
+			return redis.NewErrorCommand(err)
+		}
+		// 节点之间的连接按FIFO顺序匹配回复，本地执行也要同步等待结果，保证回复顺序与请求顺序相同
+		return c.executeLocally(command, command.Connection())
 	}
-	if handler, ok := router[command.Name()]; ok {
-		return handler(c, command)
-	} else {
+	handler, ok := router[command.Name()]
+	if !ok {
 		return redis.NewErrorCommand(redis.CreateUnknownCommandError(command.Name()))
 	}
+	reply := handler(c, command)
+	// ASKING 只对下一条命令有效
+	if command.Name() != "asking" {
+		c.mutex.Lock()
+		delete(c.asking, command.Connection())
+		c.mutex.Unlock()
+	}
+	return reply
+}
+
+// busHandler 集群内部server的命令处理器，与对外server共享同一个本地数据库的命令执行循环
+type busHandler struct {
+	*Cluster
+}
+
+func (b *busHandler) ExecuteLoop() error {
+	return b.multiDB.ExecuteLoop()
 }
 
 func (c *Cluster) ForEach(dbIdx int, fun func(key string, entry *database.Entry, expire *time.Time) bool) {
@@ -74,10 +147,15 @@ func (c *Cluster) Len(dbIdx int) int {
 }
 
 func (c *Cluster) OnConnectionClosed(conn redis.Connection) {
+	c.mutex.Lock()
+	delete(c.asking, conn)
+	c.mutex.Unlock()
 	c.multiDB.OnConnectionClosed(conn)
 }
 
 func (c *Cluster) Peers() []*PeerClient {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	clients := make([]*PeerClient, 0, len(c.peers))
 	for _, client := range c.peers {
 		clients = append(clients, client)
@@ -85,9 +163,17 @@ func (c *Cluster) Peers() []*PeerClient {
 	return clients
 }
 
+// peerClient 获取节点的连接，节点可能在运行时被加入或删除
+func (c *Cluster) peerClient(addr string) (*PeerClient, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	client, ok := c.peers[addr]
+	return client, ok
+}
+
 func (c *Cluster) LookForKey(key string) *PeerClient {
-	addr := c.selector.SelectPeer(key)
-	return c.peers[addr]
+	client, _ := c.peerClient(c.selector.SelectPeer(key))
+	return client
 }
 
 func (c *Cluster) GetEntry(key string, dbIndex ...int) (*database.Entry, bool) {
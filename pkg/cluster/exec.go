@@ -2,36 +2,130 @@ package cluster
 
 import (
 	"redigo/pkg/redis"
-	"redigo/pkg/util/conn"
-	"redigo/pkg/util/log"
+	"strconv"
+	"sync"
 )
 
-// execKeys 集群模式下执行keys命令
-// 1. 开启多个goroutine从集群节点获取keys结果
-// 2. 开启一个goroutine获取本地结果
+// scanNodeBits 集群SCAN游标中节点序号占用的低位数量
+const scanNodeBits = 10
+
+// broadcast 在所有节点上并行执行命令，回复的顺序与 selector.Peers() 的节点顺序相同
+func (c *Cluster) broadcast(command redis.Command) []*redis.RespCommand {
+	nodes := c.selector.Peers()
+	replies := make([]*redis.RespCommand, len(nodes))
+	wg := sync.WaitGroup{}
+	wg.Add(len(nodes))
+	for i, node := range nodes {
+		// 每个节点使用单独的命令，转发时会修改命令的状态
+		go func(i int, node string, command *redis.RespCommand) {
+			defer wg.Done()
+			replies[i] = c.executeOn(node, command, command.Connection())
+		}(i, node, copyCommand(command, command.Parts()))
+	}
+	wg.Wait()
+	return replies
+}
+
+// copyCommand 使用新的参数创建命令，并绑定原命令的连接
+func copyCommand(command redis.Command, parts [][]byte) *redis.RespCommand {
+	cmd := redis.NewCommand(parts)
+	cmd.BindConnection(command.Connection())
+	return cmd
+}
+
+// execKeys 集群模式下执行keys命令，合并所有节点的结果。
+// slot迁移过程中同一个key可能同时存在于两个节点，合并时去掉重复的key
 func execKeys(cluster *Cluster, command redis.Command) *redis.RespCommand {
-	realConn := command.Connection()
-	// fakeConn 用于接收本地数据库的结果
-	fakeConn := conn.NewFakeConnection(realConn)
-	command.BindConnection(fakeConn)
-	peers := cluster.Peers()
-	replies := make(chan []byte, len(peers)+1)
-	go func() {
-		cluster.multiDB.SubmitCommand(command)
-		replies <- (<-command.Connection().(*conn.FakeConnection).Replies).ToBytes()
-	}()
-	for _, peer := range peers {
-		go func(peer *PeerClient) {
-			reply := peer.RelayCommand(command)
-			replies <- reply.ToBytes()
-			log.Debug("received keys reply from peer: %s", peer.peerAddr)
-		}(peer)
-	}
-	// 等待每个节点的结果
-	result := make([][]byte, len(peers)+1)
-	for i := 0; i < len(peers)+1; i++ {
-		result[i] = <-replies
-	}
-	command.BindConnection(realConn)
-	return redis.NewNestedArrayCommand(result)
+	if len(command.Args()) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("keys"))
+	}
+	replies := cluster.broadcast(command)
+	if err := firstError(replies); err != nil {
+		return err
+	}
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, reply := range replies {
+		for _, key := range reply.Parts() {
+			if !seen[string(key)] {
+				seen[string(key)] = true
+				keys = append(keys, string(key))
+			}
+		}
+	}
+	return redis.NewStringArrayCommand(keys)
+}
+
+// execDBSize DBSIZE，所有节点key数量的总和
+func execDBSize(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("dbsize"))
+	}
+	return mergeSum(0, nil, cluster.broadcast(command))
+}
+
+// execFlushAll FLUSHALL [ASYNC]，清空所有节点
+func execFlushAll(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) > 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("flushall"))
+	}
+	return mergeOK(0, nil, cluster.broadcast(command))
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count]，依次遍历每个节点。
+// 游标的低 scanNodeBits 位是节点在按地址排序的节点列表中的序号，其余的位是该节点的游标；
+// 一个节点遍历结束后，从下一个节点的0号游标开始
+func execScan(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) < 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("scan"))
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return redis.NewErrorCommand(redis.InvalidCursorError)
+	}
+	nodes := cluster.selector.Peers()
+	index := int(cursor & (1<<scanNodeBits - 1))
+	if index >= len(nodes) {
+		return scanReply(0, nil)
+	}
+	parts := make([][]byte, len(command.Parts()))
+	copy(parts, command.Parts())
+	parts[1] = []byte(strconv.FormatUint(cursor>>scanNodeBits, 10))
+	reply := cluster.executeOn(nodes[index], copyCommand(command, parts), command.Connection())
+	if reply.Type() == redis.CommandTypeError {
+		return reply
+	}
+	// 节点的回复是嵌套数组：[cursor, [key ...]]
+	if len(reply.Parts()) != 2 {
+		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+	}
+	nodeCursor, err := decodeRESP(reply.Parts()[0])
+	if err != nil || len(nodeCursor.Parts()) != 1 {
+		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+	}
+	keys, err := decodeRESP(reply.Parts()[1])
+	if err != nil {
+		return redis.NewErrorCommand(redis.ClusterPeerUnreachableError)
+	}
+	next, err := strconv.ParseUint(string(nodeCursor.Parts()[0]), 10, 64)
+	if err != nil {
+		return redis.NewErrorCommand(redis.InvalidCursorError)
+	}
+	if next != 0 {
+		next = next<<scanNodeBits | uint64(index)
+	} else if index+1 < len(nodes) {
+		next = uint64(index + 1)
+	}
+	return scanReply(next, keys.Parts())
+}
+
+func scanReply(cursor uint64, keys [][]byte) *redis.RespCommand {
+	if keys == nil {
+		keys = [][]byte{}
+	}
+	return redis.NewNestedArrayCommand([][]byte{
+		redis.Encode(redis.NewBulkStringCommand([]byte(strconv.FormatUint(cursor, 10)))),
+		redis.Encode(redis.NewArrayCommand(keys)),
+	})
 }
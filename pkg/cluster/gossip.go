@@ -0,0 +1,459 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"redigo/pkg/cluster/peer"
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 集群总线的gossip消息类型
+const (
+	gossipPing = "ping"
+	gossipPong = "pong"
+	gossipMeet = "meet" // gossipMeet 邀请对方加入集群，对方会把发送者加入自己的节点表
+	gossipFail = "fail" // gossipFail 通知其他节点某个节点已经下线
+)
+
+const (
+	gossipInterval = time.Second
+	// forgetTTL 被 CLUSTER FORGET 删除的节点在这段时间内不会通过gossip重新加入
+	forgetTTL = 60 * time.Second
+	// busPortOffset CLUSTER MEET 没有指定集群总线端口时，使用客户端端口加上该偏移量
+	busPortOffset = 10000
+)
+
+// clusterNode 节点表中的节点，包括当前节点自己
+type clusterNode struct {
+	addr         string // addr 集群总线地址，作为节点的唯一标识
+	clientAddr   string // clientAddr 对客户端开放的地址
+	configEpoch  int64  // configEpoch 节点slot配置的纪元，同一个slot由纪元更大的节点负责
+	handshake    bool   // handshake 节点还没有回复过消息
+	created      time.Time
+	pingSent     time.Time // pingSent 最近一次发送ping的时间
+	pongReceived time.Time // pongReceived 最近一次收到该节点消息的时间
+	pfail        bool      // pfail 疑似下线，超过 nodeTimeout 没有收到该节点的消息
+	fail         bool      // fail 多数节点认为该节点疑似下线后判定为下线
+	failReports  map[string]time.Time
+}
+
+func newClusterNode(addr string, clientAddr string) *clusterNode {
+	now := time.Now()
+	return &clusterNode{
+		addr:         addr,
+		clientAddr:   clientAddr,
+		created:      now,
+		pongReceived: now,
+		failReports:  make(map[string]time.Time),
+	}
+}
+
+func (n *clusterNode) flags() string {
+	switch {
+	case n.fail:
+		return "fail"
+	case n.pfail:
+		return "pfail"
+	case n.handshake:
+		return "handshake"
+	}
+	return "ok"
+}
+
+// info CLUSTER NODES 中节点的信息，不包括slot
+func (n *clusterNode) info(myself bool) string {
+	flags := "master"
+	if myself {
+		flags = "myself,master"
+	}
+	switch {
+	case n.fail:
+		flags += ",fail"
+	case n.pfail:
+		flags += ",fail?"
+	case n.handshake:
+		flags += ",handshake"
+	}
+	linkState := "connected"
+	if n.pfail || n.fail || n.handshake {
+		linkState = "disconnected"
+	}
+	var pingSent, pongReceived int64
+	if !myself {
+		pingSent, pongReceived = n.pingSent.UnixMilli(), n.pongReceived.UnixMilli()
+		if n.pingSent.IsZero() {
+			pingSent = 0
+		}
+	}
+	_, busPort := splitAddr(n.addr)
+	return fmt.Sprintf("%s %s@%d %s - %d %d %d %s", nodeID(n.addr), n.clientAddr, busPort, flags,
+		pingSent, pongReceived, n.configEpoch, linkState)
+}
+
+func nodeTimeout() time.Duration {
+	return time.Duration(config.Properties.ClusterNodeTimeout) * time.Millisecond
+}
+
+// gossipLoop 定时向所有节点发送ping，检查节点的健康状态
+func (c *Cluster) gossipLoop() {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.checkNodes()
+			c.expireTransactions()
+			for _, addr := range c.selector.Peers() {
+				if addr != c.address {
+					go c.sendGossip(addr, gossipPing)
+				}
+			}
+		}
+	}
+}
+
+// sendGossip 发送ping或meet，握手中的节点总是发送meet，并处理对方回复的pong
+func (c *Cluster) sendGossip(addr string, kind string) {
+	client, ok := c.peerClient(addr)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	if node, ok := c.nodes[addr]; ok {
+		node.pingSent = time.Now()
+		// 握手中的节点可能还不认识当前节点，会忽略ping，只有meet能让对方接受当前节点
+		if node.handshake {
+			kind = gossipMeet
+		}
+	}
+	message := c.gossipMessage(kind)
+	c.mutex.Unlock()
+	reply := client.relay(message, nodeTimeout()/2)
+	if reply.Type() == redis.CommandTypeError {
+		return
+	}
+	c.processGossip(reply.Parts())
+}
+
+// gossipMessage 生成gossip消息，格式：
+// gossip <type> <sender-addr> <sender-client-addr> <config-epoch> <current-epoch> <slots> [<addr> <client-addr> <flags>]...
+func (c *Cluster) gossipMessage(kind string) *redis.RespCommand {
+	myself := c.nodes[c.address]
+	args := []string{"gossip", kind, c.address, myself.clientAddr,
+		strconv.FormatInt(myself.configEpoch, 10), strconv.FormatInt(c.currentEpoch, 10), c.slotsOf(c.address)}
+	for addr, node := range c.nodes {
+		if addr == c.address {
+			continue
+		}
+		args = append(args, addr, node.clientAddr, node.flags())
+	}
+	return redis.NewStringArrayCommand(args)
+}
+
+// slotsOf 节点负责的slot，格式：0-5460,6000，没有slot时为 -
+func (c *Cluster) slotsOf(addr string) string {
+	var slots []string
+	for _, r := range c.selector.SlotRanges() {
+		if r.Node != addr {
+			continue
+		}
+		if r.Start == r.End {
+			slots = append(slots, strconv.Itoa(r.Start))
+		} else {
+			slots = append(slots, fmt.Sprintf("%d-%d", r.Start, r.End))
+		}
+	}
+	if len(slots) == 0 {
+		return "-"
+	}
+	return strings.Join(slots, ",")
+}
+
+// execGossip 处理其他节点发送的gossip消息，ping和meet回复pong
+func execGossip(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) < 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("gossip"))
+	}
+	if string(args[0]) == gossipFail {
+		cluster.processFail(string(args[1]))
+		return redis.OKCommand
+	}
+	cluster.processGossip(command.Parts())
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	return cluster.gossipMessage(gossipPong)
+}
+
+// processGossip 处理ping、meet、pong消息：更新发送者的状态和slot，合并发送者知道的其他节点
+func (c *Cluster) processGossip(parts [][]byte) {
+	if len(parts) < 7 || (len(parts)-7)%3 != 0 {
+		return
+	}
+	kind, sender, clientAddr := string(parts[1]), string(parts[2]), string(parts[3])
+	configEpoch, _ := strconv.ParseInt(string(parts[4]), 10, 64)
+	currentEpoch, _ := strconv.ParseInt(string(parts[5]), 10, 64)
+	if sender == c.address {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	node, known := c.nodes[sender]
+	if !known {
+		// 只有 meet 消息可以让未知的节点加入集群
+		if kind != gossipMeet || c.isForgotten(sender) {
+			return
+		}
+		node = c.addNode(sender, clientAddr)
+		log.Info("cluster node %s joined by meet", sender)
+	}
+	if node.handshake || node.pfail || node.fail {
+		log.Info("cluster node %s is reachable", sender)
+	}
+	node.clientAddr = clientAddr
+	node.configEpoch = configEpoch
+	node.handshake, node.pfail, node.fail = false, false, false
+	node.pongReceived = time.Now()
+	if currentEpoch > c.currentEpoch {
+		c.currentEpoch = currentEpoch
+	}
+	c.updateSlots(sender, configEpoch, string(parts[6]))
+	for i := 7; i+2 < len(parts); i += 3 {
+		addr, flags := string(parts[i]), string(parts[i+2])
+		if addr == c.address {
+			continue
+		}
+		other, ok := c.nodes[addr]
+		if !ok {
+			if c.isForgotten(addr) {
+				continue
+			}
+			other = c.addNode(addr, string(parts[i+1]))
+			other.handshake = true
+			log.Info("cluster node %s discovered from %s", addr, sender)
+		}
+		// 记录发送者对该节点的下线报告
+		if flags == "pfail" || flags == "fail" {
+			other.failReports[sender] = time.Now()
+			c.markFailIfNeeded(other)
+		} else {
+			delete(other.failReports, sender)
+		}
+	}
+}
+
+// updateSlots 发送者声明的slot，配置纪元比当前负责节点更大或者slot未分配时，将slot分配给发送者。调用者需要持有写锁
+func (c *Cluster) updateSlots(sender string, configEpoch int64, slots string) {
+	if slots == "-" {
+		return
+	}
+	for _, r := range strings.Split(slots, ",") {
+		startStr, endStr, isRange := strings.Cut(r, "-")
+		start, err := strconv.Atoi(startStr)
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(endStr)
+		}
+		if err != nil || start < 0 || end >= peer.SlotCount {
+			continue
+		}
+		for slot := start; slot <= end; slot++ {
+			owner := c.selector.SlotNode(slot)
+			if owner == sender {
+				continue
+			}
+			// 纪元相同时地址较小的节点负责slot，保证所有节点得到相同的结果
+			if ownerNode, ok := c.nodes[owner]; owner != "" && ok && (ownerNode.configEpoch > configEpoch ||
+				(ownerNode.configEpoch == configEpoch && owner < sender)) {
+				continue
+			}
+			c.selector.SetSlot(slot, sender)
+			if owner == c.address {
+				// slot已经迁移到发送者
+				delete(c.migrating, slot)
+				log.Info("cluster slot %d moved to %s", slot, sender)
+			}
+		}
+	}
+}
+
+// checkServed slot没有分配给任何节点，或者负责slot的节点已经下线时，返回 CLUSTERDOWN 错误
+func (c *Cluster) checkServed(node string) error {
+	if node == "" {
+		return redis.SlotNotServedError
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if n, ok := c.nodes[node]; ok && n.fail {
+		return redis.ClusterDownError
+	}
+	return nil
+}
+
+// checkNodes 检查节点是否疑似下线，删除握手超时的节点
+func (c *Cluster) checkNodes() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timeout := nodeTimeout()
+	for addr, node := range c.nodes {
+		if addr == c.address {
+			continue
+		}
+		if node.handshake && time.Since(node.created) > timeout {
+			log.Info("cluster node %s handshake timeout, removed", addr)
+			c.removeNode(addr)
+			continue
+		}
+		if !node.pfail && time.Since(node.pongReceived) > timeout {
+			node.pfail = true
+			log.Info("cluster node %s is possibly failing", addr)
+		}
+		if node.pfail {
+			c.markFailIfNeeded(node)
+		}
+	}
+}
+
+// markFailIfNeeded 当前节点认为该节点疑似下线，并且在 2*nodeTimeout 内报告下线的节点达到多数时，判定节点下线并广播
+func (c *Cluster) markFailIfNeeded(node *clusterNode) {
+	if !node.pfail || node.fail {
+		return
+	}
+	reports := 1
+	for reporter, reportTime := range node.failReports {
+		if _, ok := c.nodes[reporter]; ok && time.Since(reportTime) <= 2*nodeTimeout() {
+			reports++
+		} else {
+			delete(node.failReports, reporter)
+		}
+	}
+	// 握手中的节点还没有加入集群，不参与计数
+	known := 0
+	for _, n := range c.nodes {
+		if !n.handshake {
+			known++
+		}
+	}
+	if reports < known/2+1 {
+		return
+	}
+	node.fail = true
+	log.Info("cluster node %s marked as failing, reports: %d", node.addr, reports)
+	for addr := range c.nodes {
+		if addr != c.address && addr != node.addr {
+			go c.broadcastFail(addr, node.addr)
+		}
+	}
+}
+
+func (c *Cluster) broadcastFail(addr string, failed string) {
+	if client, ok := c.peerClient(addr); ok {
+		client.relay(redis.NewStringArrayCommand([]string{"gossip", gossipFail, failed}), nodeTimeout()/2)
+	}
+}
+
+// processFail 收到其他节点的下线通知
+func (c *Cluster) processFail(addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if node, ok := c.nodes[addr]; ok && addr != c.address && !node.fail {
+		node.fail = true
+		log.Info("cluster node %s marked as failing by broadcast", addr)
+	}
+}
+
+// addNode 将节点加入节点表，新节点不负责任何slot。调用者需要持有写锁
+func (c *Cluster) addNode(addr string, clientAddr string) *clusterNode {
+	node := newClusterNode(addr, clientAddr)
+	c.nodes[addr] = node
+	c.peers[addr] = NewPeerClient(addr, peerConnections)
+	c.selector.AddNode(addr)
+	return node
+}
+
+// removeNode 从节点表中删除节点，节点负责的slot变为未分配状态。调用者需要持有写锁
+func (c *Cluster) removeNode(addr string) {
+	delete(c.nodes, addr)
+	if client, ok := c.peers[addr]; ok {
+		client.Close()
+		delete(c.peers, addr)
+	}
+	c.selector.RemovePeer(addr)
+	for slot, node := range c.migrating {
+		if node == addr {
+			delete(c.migrating, slot)
+		}
+	}
+	for slot, node := range c.importing {
+		if node == addr {
+			delete(c.importing, slot)
+		}
+	}
+	for _, node := range c.nodes {
+		delete(node.failReports, addr)
+	}
+}
+
+func (c *Cluster) isForgotten(addr string) bool {
+	forgetTime, ok := c.forgotten[addr]
+	if ok && time.Since(forgetTime) > forgetTTL {
+		delete(c.forgotten, addr)
+		return false
+	}
+	return ok
+}
+
+// execClusterMeet CLUSTER MEET ip port [cluster-bus-port]，集群总线端口默认为 port+10000
+func execClusterMeet(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 2 && len(args) != 3 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|meet"))
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || net.ParseIP(host) == nil {
+		return redis.NewErrorCommand(redis.CreateInvalidNodeAddressError(host + ":" + string(args[1])))
+	}
+	busPort := port + busPortOffset
+	if len(args) == 3 {
+		if busPort, err = strconv.Atoi(string(args[2])); err != nil {
+			return redis.NewErrorCommand(redis.CreateInvalidNodeAddressError(host + ":" + string(args[2])))
+		}
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(busPort))
+	cluster.mutex.Lock()
+	if _, ok := cluster.nodes[addr]; !ok && addr != cluster.address {
+		node := cluster.addNode(addr, net.JoinHostPort(host, strconv.Itoa(port)))
+		node.handshake = true
+		delete(cluster.forgotten, addr)
+	}
+	cluster.mutex.Unlock()
+	go cluster.sendGossip(addr, gossipMeet)
+	return redis.OKCommand
+}
+
+// execClusterForget CLUSTER FORGET node-id，从节点表中删除节点，一段时间内不会通过gossip重新加入
+func execClusterForget(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|forget"))
+	}
+	addr, ok := cluster.nodeByID(string(args[0]))
+	if !ok {
+		return redis.NewErrorCommand(redis.CreateUnknownNodeError(string(args[0])))
+	}
+	if addr == cluster.address {
+		return redis.NewErrorCommand(redis.ForgetMyselfError)
+	}
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	cluster.removeNode(addr)
+	cluster.forgotten[addr] = time.Now()
+	log.Info("cluster node %s forgotten", addr)
+	return redis.OKCommand
+}
@@ -0,0 +1,228 @@
+package cluster
+
+import (
+	"fmt"
+	"redigo/pkg/cluster/peer"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/conn"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	clusterSubCommands["setslot"] = execClusterSetSlot
+	clusterSubCommands["getkeysinslot"] = execClusterGetKeysInSlot
+	clusterSubCommands["countkeysinslot"] = execClusterCountKeysInSlot
+}
+
+// execMigrate MIGRATE host port key|"" destination-db timeout ... [KEYS key [key ...]]，
+// 由key所在的节点执行，使用 KEYS 时以第一个key为准
+func execMigrate(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) < 5 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("migrate"))
+	}
+	key := string(args[2])
+	for i := 5; i < len(args)-1 && key == ""; i++ {
+		if strings.ToLower(string(args[i])) == "keys" {
+			key = string(args[i+1])
+		}
+	}
+	if key == "" {
+		return redis.NewSingleLineCommand([]byte("NOKEY"))
+	}
+	return routeCommand(cluster, command, key)
+}
+
+// execRestoreAsking RESTORE-ASKING，相当于 ASKING 之后执行 RESTORE
+func execRestoreAsking(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	cluster.mutex.Lock()
+	cluster.asking[command.Connection()] = true
+	cluster.mutex.Unlock()
+	return normalCommandHandler(cluster, command)
+}
+
+// forwardMigrated 其他节点转发来的命令，key所在的slot正在迁出并且key已经迁移时，继续转发给迁移的目标节点
+func (c *Cluster) forwardMigrated(command redis.Command) (*redis.RespCommand, bool) {
+	spec, multiKey := multiKeyCommands[command.Name()]
+	if !keyCommands[command.Name()] && !multiKey || len(command.Args()) == 0 {
+		return nil, false
+	}
+	c.mutex.RLock()
+	migrating := len(c.migrating) > 0
+	c.mutex.RUnlock()
+	if !migrating {
+		return nil, false
+	}
+	// 多key命令只将已经迁出的key转发给迁移的目标节点
+	if multiKey {
+		if len(command.Args())%spec.step != 0 {
+			return nil, false
+		}
+		groups, _ := c.groupKeys(command, spec.step, true)
+		if len(groups) == 1 && groups[0].node == c.address {
+			return nil, false
+		}
+		return c.scatter(command, spec, groups), true
+	}
+	target, _, ask := c.route(command, string(command.Args()[0]))
+	if !ask {
+		return nil, false
+	}
+	client, ok := c.peerClient(target)
+	if !ok {
+		return redis.NewErrorCommand(redis.ClusterPeerNotFoundError), true
+	}
+	return client.RelayCommand(command), true
+}
+
+// execClusterSetSlot CLUSTER SETSLOT <slot> IMPORTING <node-id> | MIGRATING <node-id> | STABLE | NODE <node-id>
+func execClusterSetSlot(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) < 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|setslot"))
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+		cluster.mutex.Lock()
+		delete(cluster.migrating, slot)
+		delete(cluster.importing, slot)
+		cluster.mutex.Unlock()
+		return redis.OKCommand
+	}
+	if len(args) != 3 {
+		return redis.NewErrorCommand(redis.SyntaxError)
+	}
+	node, ok := cluster.nodeByID(string(args[2]))
+	if !ok {
+		return redis.NewErrorCommand(redis.CreateUnknownNodeError(string(args[2])))
+	}
+	owner := cluster.selector.SlotNode(slot)
+	switch action {
+	case "migrating":
+		if owner != cluster.address {
+			return redis.NewErrorCommand(redis.CreateNotSlotOwnerError(slot))
+		}
+		if node == cluster.address {
+			return redis.NewErrorCommand(fmt.Errorf("ERR I can't migrate to myself"))
+		}
+		cluster.mutex.Lock()
+		cluster.migrating[slot] = node
+		cluster.mutex.Unlock()
+	case "importing":
+		if owner == cluster.address {
+			return redis.NewErrorCommand(redis.CreateAlreadySlotOwnerError(slot))
+		}
+		if node == cluster.address {
+			return redis.NewErrorCommand(fmt.Errorf("ERR I can't import from myself"))
+		}
+		cluster.mutex.Lock()
+		cluster.importing[slot] = node
+		cluster.mutex.Unlock()
+	case "node":
+		// 迁出的slot必须在key全部迁移之后才能分配给其他节点
+		if owner == cluster.address && node != cluster.address {
+			if cluster.countKeysInSlot(slot) > 0 {
+				return redis.NewErrorCommand(redis.CreateSlotNotEmptyError(slot))
+			}
+		}
+		cluster.selector.SetSlot(slot, node)
+		cluster.mutex.Lock()
+		delete(cluster.migrating, slot)
+		// 迁入完成，成为slot的新节点，增加配置纪元使其他节点通过gossip接受新的slot配置
+		if node == cluster.address {
+			delete(cluster.importing, slot)
+			cluster.currentEpoch++
+			cluster.nodes[cluster.address].configEpoch = cluster.currentEpoch
+		}
+		cluster.mutex.Unlock()
+	default:
+		return redis.NewErrorCommand(redis.SyntaxError)
+	}
+	return redis.OKCommand
+}
+
+// execClusterGetKeysInSlot CLUSTER GETKEYSINSLOT <slot> <count>，返回本节点slot中最多count个key
+func execClusterGetKeysInSlot(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|getkeysinslot"))
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil || count < 0 {
+		return redis.NewErrorCommand(fmt.Errorf("ERR Invalid number of keys"))
+	}
+	keys := make([]string, 0, count)
+	for _, key := range cluster.localKeys() {
+		if len(keys) >= count {
+			break
+		}
+		if peer.KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	return redis.NewStringArrayCommand(keys)
+}
+
+// execClusterCountKeysInSlot CLUSTER COUNTKEYSINSLOT <slot>
+func execClusterCountKeysInSlot(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|countkeysinslot"))
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	return redis.NewNumberCommand(cluster.countKeysInSlot(slot))
+}
+
+func (c *Cluster) countKeysInSlot(slot int) int {
+	count := 0
+	for _, key := range c.localKeys() {
+		if peer.KeySlot(key) == slot {
+			count++
+		}
+	}
+	return count
+}
+
+// localKeys 通过本地数据库的executor获取所有的key
+func (c *Cluster) localKeys() []string {
+	fakeConn := conn.NewFakeConnection(nil)
+	keys := redis.NewStringArrayCommand([]string{"keys", "*"})
+	keys.BindConnection(fakeConn)
+	c.multiDB.SubmitCommand(keys)
+	reply := <-fakeConn.Replies
+	result := make([]string, 0, len(reply.Parts()))
+	for _, part := range reply.Parts() {
+		result = append(result, string(part))
+	}
+	return result
+}
+
+// nodeByID 根据节点ID找到节点的集群地址
+func (c *Cluster) nodeByID(id string) (string, bool) {
+	for _, addr := range c.selector.Peers() {
+		if nodeID(addr) == id {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+func parseSlot(arg []byte) (int, error) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= peer.SlotCount {
+		return 0, redis.InvalidSlotError
+	}
+	return slot, nil
+}
@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"redigo/pkg/cluster/peer"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/conn"
+	"sync"
+)
+
+// multiKeyCommand 多key命令的拆分和合并方式
+type multiKeyCommand struct {
+	// step 每个key占用的参数个数，MSET 的key和value成对出现，为2
+	step int
+	// merge 按key在原命令中的顺序合并各个节点的回复
+	merge func(keyCount int, groups []*keyGroup, replies []*redis.RespCommand) *redis.RespCommand
+}
+
+// keyGroup 由同一个节点执行的key
+type keyGroup struct {
+	node string
+	// keys 每个key在原命令key列表中的位置
+	keys []int
+}
+
+var multiKeyCommands = map[string]*multiKeyCommand{
+	"mget":   {step: 1, merge: mergeArray},
+	"mset":   {step: 2, merge: mergeOK},
+	"del":    {step: 1, merge: mergeSum},
+	"exists": {step: 1, merge: mergeSum},
+}
+
+// multiKeyHandler 多key命令处理器。
+// 重定向模式下key必须属于同一个slot；转发模式下按节点拆分成多个子命令并行执行，再按参数顺序合并结果。
+// 拆分后的命令在多个节点上不是原子的，MSET 部分节点失败时已经写入的key不会回滚
+func multiKeyHandler(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	spec := multiKeyCommands[command.Name()]
+	args := command.Args()
+	if len(args) == 0 || len(args)%spec.step != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError(command.Name()))
+	}
+	conn := command.Connection()
+	if conn.IsMulti() {
+		conn.EnqueueCommand(command.(*redis.RespCommand))
+		return redis.NewSingleLineCommand([]byte("QUEUED"))
+	}
+	if isRedirectMode() {
+		if crossSlot(args, spec.step) {
+			return redis.NewErrorCommand(redis.CrossSlotError)
+		}
+		return routeCommand(cluster, command, string(args[0]))
+	}
+	groups, err := cluster.groupKeys(command, spec.step, false)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	if len(groups) == 1 {
+		return routeCommand(cluster, command, string(args[0]))
+	}
+	return cluster.scatter(command, spec, groups)
+}
+
+// crossSlot 命令中的key是否属于不同的slot
+func crossSlot(args [][]byte, step int) bool {
+	slot := peer.KeySlot(string(args[0]))
+	for i := step; i < len(args); i += step {
+		if peer.KeySlot(string(args[i])) != slot {
+			return true
+		}
+	}
+	return false
+}
+
+// groupKeys 按照执行节点对key分组，分组的顺序为节点第一次出现的顺序。
+// forwarded 表示命令由其他节点转发而来，此时只有已经迁出的key交给迁移目标节点，其余的key都在本地执行
+func (c *Cluster) groupKeys(command redis.Command, step int, forwarded bool) ([]*keyGroup, error) {
+	args := command.Args()
+	groups := make([]*keyGroup, 0)
+	nodeGroups := make(map[string]*keyGroup)
+	for i := 0; i < len(args); i += step {
+		node, _, ask := c.route(command, string(args[i]))
+		if forwarded && !ask {
+			node = c.address
+		}
+		if !forwarded {
+			if err := c.checkServed(node); err != nil {
+				return nil, err
+			}
+		}
+		group, ok := nodeGroups[node]
+		if !ok {
+			group = &keyGroup{node: node}
+			nodeGroups[node] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, i/step)
+	}
+	return groups, nil
+}
+
+// scatter 为每个分组生成子命令，本地的子命令提交到multiDB，其他节点的子命令通过 PeerClient 转发，
+// 所有子命令并行执行，等待全部回复后合并
+func (c *Cluster) scatter(command redis.Command, spec *multiKeyCommand, groups []*keyGroup) *redis.RespCommand {
+	replies := make([]*redis.RespCommand, len(groups))
+	wg := sync.WaitGroup{}
+	wg.Add(len(groups))
+	for i, group := range groups {
+		go func(i int, node string, sub *redis.RespCommand) {
+			defer wg.Done()
+			replies[i] = c.executeOn(node, sub, command.Connection())
+		}(i, group.node, subCommand(command, spec, group))
+	}
+	wg.Wait()
+	keyCount := len(command.Args()) / spec.step
+	return spec.merge(keyCount, groups, replies)
+}
+
+// subCommand 生成分组在节点上执行的子命令
+func subCommand(command redis.Command, spec *multiKeyCommand, group *keyGroup) *redis.RespCommand {
+	args := command.Args()
+	parts := make([][]byte, 0, len(group.keys)*spec.step+1)
+	parts = append(parts, command.Parts()[0])
+	for _, key := range group.keys {
+		parts = append(parts, args[key*spec.step:(key+1)*spec.step]...)
+	}
+	return redis.NewCommand(parts)
+}
+
+// executeOn 在指定节点上执行命令并等待回复
+func (c *Cluster) executeOn(node string, command *redis.RespCommand, realConn redis.Connection) *redis.RespCommand {
+	if node == c.address {
+		if err := c.checkLocked(command); err != nil {
+			return redis.NewErrorCommand(err)
+		}
+		return c.executeLocally(command, realConn)
+	}
+	client, ok := c.peerClient(node)
+	if !ok {
+		return redis.NewErrorCommand(redis.ClusterPeerNotFoundError)
+	}
+	return client.RelayCommand(command)
+}
+
+// executeLocally 提交命令到本地数据库的executor并等待回复，realConn为nil时使用0号数据库
+func (c *Cluster) executeLocally(command redis.Command, realConn redis.Connection) *redis.RespCommand {
+	// fakeConn 用于接收本地数据库的结果
+	fakeConn := conn.NewFakeConnection(realConn)
+	command.BindConnection(fakeConn)
+	c.multiDB.SubmitCommand(command)
+	reply := <-fakeConn.Replies
+	command.BindConnection(realConn)
+	return reply
+}
+
+// firstError 返回第一个错误回复
+func firstError(replies []*redis.RespCommand) *redis.RespCommand {
+	for _, reply := range replies {
+		if reply.Type() == redis.CommandTypeError {
+			return reply
+		}
+	}
+	return nil
+}
+
+// mergeArray 将各节点返回的数组按key的顺序合并，用于 MGET
+func mergeArray(keyCount int, groups []*keyGroup, replies []*redis.RespCommand) *redis.RespCommand {
+	if err := firstError(replies); err != nil {
+		return err
+	}
+	result := make([][]byte, keyCount)
+	for i, group := range groups {
+		parts := replies[i].Parts()
+		for j, key := range group.keys {
+			if j < len(parts) {
+				result[key] = parts[j]
+			}
+		}
+	}
+	return redis.NewArrayCommand(result)
+}
+
+// mergeSum 累加各节点返回的整数，用于 DEL、EXISTS
+func mergeSum(_ int, _ []*keyGroup, replies []*redis.RespCommand) *redis.RespCommand {
+	if err := firstError(replies); err != nil {
+		return err
+	}
+	sum := 0
+	for _, reply := range replies {
+		sum += reply.Number()
+	}
+	return redis.NewNumberCommand(sum)
+}
+
+// mergeOK 所有节点都执行成功时返回OK，用于 MSET
+func mergeOK(_ int, _ []*keyGroup, replies []*redis.RespCommand) *redis.RespCommand {
+	if err := firstError(replies); err != nil {
+		return err
+	}
+	return redis.OKCommand
+}
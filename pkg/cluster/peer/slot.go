@@ -0,0 +1,162 @@
+package peer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SlotCount Redis Cluster 的hash slot数量
+const SlotCount = 16384
+
+// SlotRange 分配给同一个节点的连续slot区间，包括 Start 和 End
+type SlotRange struct {
+	Start int
+	End   int
+	Node  string
+}
+
+/*
+SlotSelector 兼容 Redis Cluster 的hash slot节点选择器。
+key 通过 CRC16(key) mod 16384 映射到slot，key中包含 {hashtag} 时只计算hashtag部分，
+slot表记录每个slot所属的节点地址。
+*/
+type SlotSelector struct {
+	slots [SlotCount]string
+	peers []string
+	mutex sync.RWMutex
+}
+
+func NewSlotSelector() *SlotSelector {
+	return &SlotSelector{}
+}
+
+// AddPeer 添加节点后按照节点地址排序，将slot平均分配给所有节点，保证每个节点计算出相同的slot表
+func (s *SlotSelector) AddPeer(peer string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, p := range s.peers {
+		if p == peer {
+			return
+		}
+	}
+	s.peers = append(s.peers, peer)
+	sort.Strings(s.peers)
+	for i, p := range s.peers {
+		start, end := i*SlotCount/len(s.peers), (i+1)*SlotCount/len(s.peers)
+		for slot := start; slot < end; slot++ {
+			s.slots[slot] = p
+		}
+	}
+}
+
+// AddNode 添加一个不负责任何slot的节点，新节点的slot需要通过迁移获得
+func (s *SlotSelector) AddNode(node string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, p := range s.peers {
+		if p == node {
+			return
+		}
+	}
+	s.peers = append(s.peers, node)
+	sort.Strings(s.peers)
+}
+
+// RemovePeer 删除节点，节点负责的slot变为未分配状态
+func (s *SlotSelector) RemovePeer(node string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, p := range s.peers {
+		if p == node {
+			s.peers = append(s.peers[:i], s.peers[i+1:]...)
+			break
+		}
+	}
+	for slot := range s.slots {
+		if s.slots[slot] == node {
+			s.slots[slot] = ""
+		}
+	}
+}
+
+// SetSlot 将slot分配给node，用于slot迁移完成后修改slot的归属
+func (s *SlotSelector) SetSlot(slot int, node string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.slots[slot] = node
+}
+
+func (s *SlotSelector) SelectPeer(key string) string {
+	return s.SlotNode(KeySlot(key))
+}
+
+// SlotNode 返回slot所属的节点地址
+func (s *SlotSelector) SlotNode(slot int) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.slots[slot]
+}
+
+// Peers 按地址排序的所有节点
+func (s *SlotSelector) Peers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	peers := make([]string, len(s.peers))
+	copy(peers, s.peers)
+	return peers
+}
+
+// SlotRanges 将slot表合并成连续的区间
+func (s *SlotSelector) SlotRanges() []SlotRange {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var ranges []SlotRange
+	for slot := 0; slot < SlotCount; slot++ {
+		node := s.slots[slot]
+		if node == "" {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].Node == node && ranges[n-1].End == slot-1 {
+			ranges[n-1].End = slot
+		} else {
+			ranges = append(ranges, SlotRange{Start: slot, End: slot, Node: node})
+		}
+	}
+	return ranges
+}
+
+// KeySlot 计算key所属的slot，key中第一个 { 和之后第一个 } 之间的内容非空时，只使用这部分计算
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+// crc16 CRC16-CCITT (XMODEM)，与 Redis Cluster 使用的算法相同
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^key[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
@@ -0,0 +1,71 @@
+package peer
+
+import "testing"
+
+func TestKeySlot(t *testing.T) {
+	cases := map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": KeySlot("user1000"),
+		"{user1000}.followers": KeySlot("user1000"),
+		"foo{{bar}}zap":        KeySlot("{bar"),
+		"foo{bar}{zap}":        KeySlot("bar"),
+	}
+	for key, expect := range cases {
+		if slot := KeySlot(key); slot != expect {
+			t.Errorf("key: %s, expect slot: %d, got: %d", key, expect, slot)
+		}
+	}
+}
+
+func TestSlotSelector_SlotRanges(t *testing.T) {
+	selector := NewSlotSelector()
+	selector.AddPeer("127.0.0.1:16382")
+	selector.AddPeer("127.0.0.1:16381")
+	selector.AddPeer("127.0.0.1:16383")
+	ranges := selector.SlotRanges()
+	if len(ranges) != 3 {
+		t.Errorf("expect 3 slot ranges, got: %d", len(ranges))
+		t.FailNow()
+	}
+	if ranges[0].Start != 0 || ranges[0].Node != "127.0.0.1:16381" || ranges[2].End != SlotCount-1 {
+		t.Errorf("unexpected slot ranges: %v", ranges)
+	}
+	if node := selector.SelectPeer("foo"); node != "127.0.0.1:16383" {
+		t.Errorf("expect node: 127.0.0.1:16383, got: %s", node)
+	}
+}
+
+func TestSlotSelector_SetSlot(t *testing.T) {
+	selector := NewSlotSelector()
+	selector.AddPeer("127.0.0.1:16381")
+	selector.AddPeer("127.0.0.1:16382")
+	selector.SetSlot(0, "127.0.0.1:16382")
+	if node := selector.SlotNode(0); node != "127.0.0.1:16382" {
+		t.Errorf("expect node: 127.0.0.1:16382, got: %s", node)
+	}
+	ranges := selector.SlotRanges()
+	if len(ranges) != 3 || ranges[0].End != 0 || ranges[1].Start != 1 || ranges[1].Node != "127.0.0.1:16381" {
+		t.Errorf("unexpected slot ranges: %v", ranges)
+	}
+}
+
+func TestSlotSelector_AddNodeAndRemovePeer(t *testing.T) {
+	selector := NewSlotSelector()
+	selector.AddPeer("127.0.0.1:16381")
+	selector.AddPeer("127.0.0.1:16382")
+	selector.AddNode("127.0.0.1:16383")
+	if peers := selector.Peers(); len(peers) != 3 {
+		t.Errorf("expect 3 peers, got: %v", peers)
+	}
+	if ranges := selector.SlotRanges(); len(ranges) != 2 {
+		t.Errorf("new node should not own any slot, got: %v", ranges)
+	}
+	selector.RemovePeer("127.0.0.1:16381")
+	if node := selector.SlotNode(0); node != "" {
+		t.Errorf("expect slot 0 unassigned, got: %s", node)
+	}
+	if ranges := selector.SlotRanges(); len(ranges) != 1 || ranges[0].Start != SlotCount/2 {
+		t.Errorf("unexpected slot ranges: %v", ranges)
+	}
+}
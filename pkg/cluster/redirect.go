@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"net"
+	"redigo/pkg/cluster/peer"
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/conn"
+	"strings"
+)
+
+// route 计算key应该由哪个节点处理。
+// slot正在迁出并且key已经不在本地时返回目标节点和ask=true；
+// slot正在迁入时，只有发送过 ASKING 的连接可以在本地执行
+func (c *Cluster) route(command redis.Command, key string) (node string, slot int, ask bool) {
+	slot = peer.KeySlot(key)
+	node = c.selector.SlotNode(slot)
+	c.mutex.RLock()
+	target, migrating := c.migrating[slot]
+	_, importing := c.importing[slot]
+	asking := c.asking[command.Connection()]
+	c.mutex.RUnlock()
+	if node == c.address {
+		if migrating && !c.existsLocally(command, key) {
+			return target, slot, true
+		}
+		return node, slot, false
+	}
+	if importing && asking {
+		return c.address, slot, false
+	}
+	return node, slot, false
+}
+
+// existsLocally 通过本地数据库的executor判断key是否存在
+func (c *Cluster) existsLocally(command redis.Command, key string) bool {
+	fakeConn := conn.NewFakeConnection(command.Connection())
+	exists := redis.NewStringArrayCommand([]string{"exists", key})
+	exists.BindConnection(fakeConn)
+	c.multiDB.SubmitCommand(exists)
+	reply := <-fakeConn.Replies
+	return reply.Type() == redis.CommandTypeNumber && reply.Number() == 1
+}
+
+// redirectError 生成 -MOVED 或 -ASK 错误，地址为目标节点对客户端开放的地址
+func (c *Cluster) redirectError(slot int, node string, ask bool) *redis.RespCommand {
+	if ask {
+		return redis.NewErrorCommand(redis.CreateAskError(slot, c.clientAddr(node)))
+	}
+	return redis.NewErrorCommand(redis.CreateMovedError(slot, c.clientAddr(node)))
+}
+
+// isRedirectMode 是否使用重定向代替转发
+func isRedirectMode() bool {
+	return strings.ToLower(config.Properties.ClusterRouting) == config.ClusterRoutingRedirect
+}
+
+// execAsking ASKING，允许连接的下一条命令访问本节点正在迁入的slot
+func execAsking(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	cluster.asking[command.Connection()] = true
+	return redis.OKCommand
+}
+
+// clientAddr 返回节点对客户端开放的地址，还没有通过gossip获取到时返回节点的集群地址
+func (c *Cluster) clientAddr(node string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if n, ok := c.nodes[node]; ok && n.clientAddr != "" {
+		return n.clientAddr
+	}
+	return node
+}
+
+// announceAddress 本节点对客户端开放的地址，address 监听所有网卡时使用集群地址的host
+func announceAddress(self string) string {
+	if config.Properties.ClusterAnnounceAddress != "" {
+		return config.Properties.ClusterAnnounceAddress
+	}
+	host, port, err := net.SplitHostPort(config.Properties.Address)
+	if err != nil {
+		return self
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, _, _ = net.SplitHostPort(self)
+	}
+	return net.JoinHostPort(host, port)
+}
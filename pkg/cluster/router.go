@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"redigo/pkg/config"
 	"redigo/pkg/redis"
 )
 
@@ -9,71 +10,98 @@ type CommandRouter map[string]CommandHandler
 
 var router CommandRouter = make(map[string]CommandHandler)
 
+// keyCommands 第一个参数是key、由 normalCommandHandler 处理的命令
+var keyCommands = make(map[string]bool)
+
+func registerKeyCommand(name string) {
+	router[name] = normalCommandHandler
+	keyCommands[name] = true
+}
+
 func init() {
 
 	router["keys"] = execKeys
+	router["cluster"] = execCluster
+	router["asking"] = execAsking
+	router["migrate"] = execMigrate
+	router["select"] = execSelect
+	router["replicaof"] = execReplicaOf
+	router["slaveof"] = execReplicaOf
+	router["restore-asking"] = execRestoreAsking
+
+	// 多key命令按节点拆分执行
+	for name := range multiKeyCommands {
+		router[name] = multiKeyHandler
+	}
 
-	router["del"] = normalCommandHandler
-	router["ttl"] = normalCommandHandler
-	router["pttl"] = normalCommandHandler
-	router["expire"] = normalCommandHandler
-	router["persist"] = normalCommandHandler
-	router["pexpireat"] = normalCommandHandler
-	router["type"] = normalCommandHandler
-
-	router["set"] = normalCommandHandler
-	router["get"] = normalCommandHandler
-	router["setnx"] = normalCommandHandler
-	router["incr"] = normalCommandHandler
-	router["decr"] = normalCommandHandler
-	router["incrby"] = normalCommandHandler
-	router["decrby"] = normalCommandHandler
-	router["strlen"] = normalCommandHandler
-	router["setbit"] = normalCommandHandler
-	router["getbit"] = normalCommandHandler
-	router["bitcount"] = normalCommandHandler
-
-	router["lpush"] = normalCommandHandler
-	router["lpop"] = normalCommandHandler
-	router["rpush"] = normalCommandHandler
-	router["rpop"] = normalCommandHandler
-	router["lrange"] = normalCommandHandler
-	router["lindex"] = normalCommandHandler
-	router["llen"] = normalCommandHandler
-
-	router["hset"] = normalCommandHandler
-	router["hget"] = normalCommandHandler
-	router["hdel"] = normalCommandHandler
-	router["hexists"] = normalCommandHandler
-	router["hgetall"] = normalCommandHandler
-	router["hkeys"] = normalCommandHandler
-	router["hlen"] = normalCommandHandler
-	router["hmget"] = normalCommandHandler
-	router["hsetnx"] = normalCommandHandler
-	router["hincrby"] = normalCommandHandler
-	router["hstrlen"] = normalCommandHandler
-	router["hvals"] = normalCommandHandler
-
-	router["sadd"] = normalCommandHandler
-	router["sismember"] = normalCommandHandler
-	router["smembers"] = normalCommandHandler
-	router["srandmember"] = normalCommandHandler
-	router["srem"] = normalCommandHandler
-	router["spop"] = normalCommandHandler
-	router["scard"] = normalCommandHandler
-
-	router["zadd"] = normalCommandHandler
-	router["zscore"] = normalCommandHandler
-	router["zrem"] = normalCommandHandler
-	router["zrank"] = normalCommandHandler
-	router["zpopmin"] = normalCommandHandler
-	router["zpopmax"] = normalCommandHandler
-	router["zcard"] = normalCommandHandler
-	router["zrange"] = normalCommandHandler
-	router["zrangebyscore"] = normalCommandHandler
-	router["scard"] = normalCommandHandler
-	// 目前DBSize 只获取当前集群节点的key-value数量
-	router["dbsize"] = executeLocal
+	registerKeyCommand("ttl")
+	registerKeyCommand("pttl")
+	registerKeyCommand("expire")
+	registerKeyCommand("persist")
+	registerKeyCommand("pexpireat")
+	registerKeyCommand("type")
+	registerKeyCommand("restore")
+	registerKeyCommand("dump")
+	router["object"] = execObject
+	router["memory"] = execObject
+
+	registerKeyCommand("set")
+	registerKeyCommand("get")
+	registerKeyCommand("setnx")
+	registerKeyCommand("incr")
+	registerKeyCommand("decr")
+	registerKeyCommand("incrby")
+	registerKeyCommand("decrby")
+	registerKeyCommand("strlen")
+	registerKeyCommand("setbit")
+	registerKeyCommand("getbit")
+	registerKeyCommand("bitcount")
+
+	registerKeyCommand("lpush")
+	registerKeyCommand("lpop")
+	registerKeyCommand("rpush")
+	registerKeyCommand("rpop")
+	registerKeyCommand("lrange")
+	registerKeyCommand("lindex")
+	registerKeyCommand("llen")
+
+	registerKeyCommand("hset")
+	registerKeyCommand("hget")
+	registerKeyCommand("hdel")
+	registerKeyCommand("hexists")
+	registerKeyCommand("hgetall")
+	registerKeyCommand("hscan")
+	registerKeyCommand("hkeys")
+	registerKeyCommand("hlen")
+	registerKeyCommand("hmget")
+	registerKeyCommand("hsetnx")
+	registerKeyCommand("hincrby")
+	registerKeyCommand("hstrlen")
+	registerKeyCommand("hvals")
+
+	registerKeyCommand("sadd")
+	registerKeyCommand("sismember")
+	registerKeyCommand("smembers")
+	registerKeyCommand("sscan")
+	registerKeyCommand("srandmember")
+	registerKeyCommand("srem")
+	registerKeyCommand("spop")
+	registerKeyCommand("scard")
+
+	registerKeyCommand("zadd")
+	registerKeyCommand("zscore")
+	registerKeyCommand("zrem")
+	registerKeyCommand("zrank")
+	registerKeyCommand("zpopmin")
+	registerKeyCommand("zpopmax")
+	registerKeyCommand("zcard")
+	registerKeyCommand("zrange")
+	registerKeyCommand("zscan")
+	registerKeyCommand("zrangebyscore")
+	registerKeyCommand("scard")
+	router["dbsize"] = execDBSize
+	router["flushall"] = execFlushAll
+	router["scan"] = execScan
 
 	router["multi"] = multiHandler
 	router["exec"] = multiHandler
@@ -97,6 +125,10 @@ func multiHandler(cluster *Cluster, command redis.Command) *redis.RespCommand {
 		}
 		commands := conn.GetQueuedCommands()
 		conn.SetMulti(false)
+		// 开启分布式事务时，命令分布在多个节点上也可以原子地执行
+		if config.Properties.ClusterTransaction {
+			return execTransaction(cluster, commands)
+		}
 		reply := handleQueuedCommands(cluster, commands)
 		return reply
 	}
@@ -114,15 +146,29 @@ func normalCommandHandler(cluster *Cluster, command redis.Command) *redis.RespCo
 		conn.EnqueueCommand(command.(*redis.RespCommand))
 		return redis.NewSingleLineCommand([]byte("QUEUED"))
 	}
-	key := string(command.Args()[0])
-	// 通过选择器找到key所在的节点
-	peer := cluster.selector.SelectPeer(key)
+	return routeCommand(cluster, command, string(command.Args()[0]))
+}
+
+// routeCommand 将命令交给key所在的节点执行
+func routeCommand(cluster *Cluster, command redis.Command, key string) *redis.RespCommand {
+	// 通过slot找到key所在的节点
+	peer, slot, ask := cluster.route(command, key)
+	if err := cluster.checkServed(peer); err != nil {
+		return redis.NewErrorCommand(err)
+	}
 	if peer == cluster.address {
-		// 目标节点就是当前服务器，提交命令到当前节点的multiDB
-		cluster.multiDB.SubmitCommand(command)
-		return nil
+		if err := cluster.checkLocked(command); err != nil {
+			return redis.NewErrorCommand(err)
+		}
+		// 目标节点就是当前服务器，提交命令到当前节点的multiDB。
+		// 同步等待结果，使本地执行和转发的命令按照请求的顺序回复，客户端才能使用pipeline
+		return cluster.executeLocally(command, command.Connection())
+	}
+	// 重定向模式，由客户端重新发送到目标节点
+	if isRedirectMode() {
+		return cluster.redirectError(slot, peer, ask)
 	}
-	if client, ok := cluster.peers[peer]; ok {
+	if client, ok := cluster.peerClient(peer); ok {
 		// 转发命令并等待回复
 		response := client.RelayCommand(command)
 		return response
@@ -130,10 +176,30 @@ func normalCommandHandler(cluster *Cluster, command redis.Command) *redis.RespCo
 	return redis.NewErrorCommand(redis.ClusterPeerNotFoundError)
 }
 
-// execLocal 本地执行命令
+// execObject OBJECT subcommand key 和 MEMORY USAGE key，key是第二个参数；OBJECT HELP、MEMORY STATS 等没有key的命令在本地执行
+func execObject(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	if args := command.Args(); len(args) >= 2 {
+		return routeCommand(cluster, command, string(args[1]))
+	}
+	return executeLocal(cluster, command)
+}
+
+// executeLocal 本地执行命令，与其他命令一样提交到multiDB的executor，不在连接的goroutine中直接访问数据库
 func executeLocal(cluster *Cluster, command redis.Command) *redis.RespCommand {
-	reply := cluster.multiDB.Execute(command)
-	return reply
+	return cluster.executeLocally(command, command.Connection())
+}
+
+// execSelect 集群模式只能使用0号数据库
+func execSelect(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	if args := command.Args(); len(args) != 1 || string(args[0]) != "0" {
+		return redis.NewErrorCommand(redis.SelectInClusterModeError)
+	}
+	return executeLocal(cluster, command)
+}
+
+// execReplicaOf 集群模式的节点由集群管理，不能通过 REPLICAOF 修改主从关系
+func execReplicaOf(_ *Cluster, _ redis.Command) *redis.RespCommand {
+	return redis.NewErrorCommand(redis.ReplicaOfInClusterModeError)
 }
 
 // handleQueuedCommands 集群模式下处理队列中的命令
@@ -142,11 +208,11 @@ func handleQueuedCommands(cluster *Cluster, commands []*redis.RespCommand) *redi
 	for i, command := range commands {
 		key := string(command.Args()[0])
 		// 只执行集群模式允许且key在本地的命令
-		if peer := cluster.selector.SelectPeer(key); peer == cluster.address && router[command.Name()] != nil {
-			reply := cluster.multiDB.Execute(command)
+		if peer, slot, ask := cluster.route(command, key); peer == cluster.address && router[command.Name()] != nil {
+			reply := cluster.executeLocally(command, command.Connection())
 			replies[i] = redis.Encode(reply)
 		} else {
-			replies[i] = redis.Encode(redis.NewErrorCommand(redis.CreateMovedError(peer)))
+			replies[i] = redis.Encode(cluster.redirectError(slot, peer, ask))
 		}
 	}
 	return redis.NewNestedArrayCommand(replies)
@@ -156,8 +222,8 @@ func handleWatchOrUnwatch(cluster *Cluster, command redis.Command) *redis.RespCo
 	if len(command.Args()) != 1 {
 		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("watch"))
 	}
-	if addr := cluster.selector.SelectPeer(string(command.Args()[0])); addr != cluster.address {
-		return redis.NewErrorCommand(redis.CreateMovedError(addr))
+	if addr, slot, ask := cluster.route(command, string(command.Args()[0])); addr != cluster.address {
+		return cluster.redirectError(slot, addr, ask)
 	}
 	return executeLocal(cluster, command)
 }
@@ -0,0 +1,218 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"redigo/pkg/cluster/peer"
+	"redigo/pkg/redis"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type clusterSubCommand func(cluster *Cluster, args [][]byte) *redis.RespCommand
+
+var clusterSubCommands = make(map[string]clusterSubCommand)
+
+func init() {
+	clusterSubCommands["slots"] = execClusterSlots
+	clusterSubCommands["shards"] = execClusterShards
+	clusterSubCommands["keyslot"] = execClusterKeySlot
+	clusterSubCommands["nodes"] = execClusterNodes
+	clusterSubCommands["myid"] = execClusterMyID
+	clusterSubCommands["meet"] = execClusterMeet
+	clusterSubCommands["forget"] = execClusterForget
+	clusterSubCommands["info"] = execClusterInfo
+}
+
+// execCluster CLUSTER <subcommand> [arguments...]
+func execCluster(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) == 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster"))
+	}
+	sub, ok := clusterSubCommands[strings.ToLower(string(args[0]))]
+	if !ok {
+		return redis.NewErrorCommand(fmt.Errorf("ERR Unknown subcommand or wrong number of arguments for '%s'", string(args[0])))
+	}
+	return sub(cluster, args[1:])
+}
+
+// nodeID 节点ID，由节点地址计算得到，所有节点计算出的ID相同
+func nodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+func splitAddr(addr string) (string, int) {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// execClusterSlots CLUSTER SLOTS，每个slot区间返回：start end [ip port id]
+func execClusterSlots(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|slots"))
+	}
+	ranges := cluster.selector.SlotRanges()
+	parts := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		host, port := splitAddr(cluster.clientAddr(r.Node))
+		parts[i] = redis.Encode(redis.NewNestedArrayCommand([][]byte{
+			redis.Encode(redis.NewNumberCommand(r.Start)),
+			redis.Encode(redis.NewNumberCommand(r.End)),
+			redis.Encode(redis.NewNestedArrayCommand([][]byte{
+				redis.Encode(redis.NewBulkStringCommand([]byte(host))),
+				redis.Encode(redis.NewNumberCommand(port)),
+				redis.Encode(redis.NewBulkStringCommand([]byte(nodeID(r.Node)))),
+			})),
+		}))
+	}
+	return redis.NewNestedArrayCommand(parts)
+}
+
+// execClusterShards CLUSTER SHARDS，每个节点是一个只有master的分片
+func execClusterShards(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|shards"))
+	}
+	nodeSlots := make(map[string][][]byte)
+	for _, r := range cluster.selector.SlotRanges() {
+		nodeSlots[r.Node] = append(nodeSlots[r.Node],
+			redis.Encode(redis.NewNumberCommand(r.Start)), redis.Encode(redis.NewNumberCommand(r.End)))
+	}
+	peers := cluster.selector.Peers()
+	shards := make([][]byte, len(peers))
+	for i, addr := range peers {
+		host, port := splitAddr(cluster.clientAddr(addr))
+		node := redis.NewNestedArrayCommand([][]byte{
+			redis.Encode(redis.NewBulkStringCommand([]byte("id"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte(nodeID(addr)))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("port"))),
+			redis.Encode(redis.NewNumberCommand(port)),
+			redis.Encode(redis.NewBulkStringCommand([]byte("ip"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte(host))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("endpoint"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte(host))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("role"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("master"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("replication-offset"))),
+			redis.Encode(redis.NewNumberCommand(0)),
+			redis.Encode(redis.NewBulkStringCommand([]byte("health"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte("online"))),
+		})
+		shards[i] = redis.Encode(redis.NewNestedArrayCommand([][]byte{
+			redis.Encode(redis.NewBulkStringCommand([]byte("slots"))),
+			redis.Encode(redis.NewNestedArrayCommand(nodeSlots[addr])),
+			redis.Encode(redis.NewBulkStringCommand([]byte("nodes"))),
+			redis.Encode(redis.NewNestedArrayCommand([][]byte{redis.Encode(node)})),
+		}))
+	}
+	return redis.NewNestedArrayCommand(shards)
+}
+
+func execClusterKeySlot(_ *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|keyslot"))
+	}
+	return redis.NewNumberCommand(peer.KeySlot(string(args[0])))
+}
+
+// execClusterNodes CLUSTER NODES，格式：<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func execClusterNodes(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|nodes"))
+	}
+	nodeSlots := make(map[string][]string)
+	for _, r := range cluster.selector.SlotRanges() {
+		if r.Start == r.End {
+			nodeSlots[r.Node] = append(nodeSlots[r.Node], strconv.Itoa(r.Start))
+		} else {
+			nodeSlots[r.Node] = append(nodeSlots[r.Node], fmt.Sprintf("%d-%d", r.Start, r.End))
+		}
+	}
+	builder := strings.Builder{}
+	migrationStates := cluster.migrationStates()
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	for _, addr := range cluster.selector.Peers() {
+		node, ok := cluster.nodes[addr]
+		if !ok {
+			continue
+		}
+		builder.WriteString(node.info(addr == cluster.address))
+		for _, slots := range nodeSlots[addr] {
+			builder.WriteString(" " + slots)
+		}
+		if addr == cluster.address {
+			builder.WriteString(migrationStates)
+		}
+		builder.WriteString("\n")
+	}
+	return redis.NewBulkStringCommand([]byte(builder.String()))
+}
+
+// migrationStates 本节点正在迁移的slot，格式：[slot->-target-id] [slot-<-source-id]
+func (c *Cluster) migrationStates() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	states := make([]string, 0, len(c.migrating)+len(c.importing))
+	for slot, node := range c.migrating {
+		states = append(states, fmt.Sprintf(" [%d->-%s]", slot, nodeID(node)))
+	}
+	for slot, node := range c.importing {
+		states = append(states, fmt.Sprintf(" [%d-<-%s]", slot, nodeID(node)))
+	}
+	sort.Strings(states)
+	return strings.Join(states, "")
+}
+
+// execClusterInfo CLUSTER INFO，所有slot都有可用的节点负责时集群状态为ok
+func execClusterInfo(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|info"))
+	}
+	ranges := cluster.selector.SlotRanges()
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	assigned, failed := 0, 0
+	owners := make(map[string]bool)
+	for _, r := range ranges {
+		assigned += r.End - r.Start + 1
+		owners[r.Node] = true
+		if node, ok := cluster.nodes[r.Node]; ok && node.fail {
+			failed += r.End - r.Start + 1
+		}
+	}
+	pfail := 0
+	for _, node := range cluster.nodes {
+		if node.pfail && !node.fail && owners[node.addr] {
+			pfail += 1
+		}
+	}
+	state := "ok"
+	if assigned < peer.SlotCount || failed > 0 {
+		state = "fail"
+	}
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("cluster_state:%s\r\n", state))
+	builder.WriteString(fmt.Sprintf("cluster_slots_assigned:%d\r\n", assigned))
+	builder.WriteString(fmt.Sprintf("cluster_slots_ok:%d\r\n", assigned-failed))
+	builder.WriteString(fmt.Sprintf("cluster_slots_pfail:%d\r\n", pfail))
+	builder.WriteString(fmt.Sprintf("cluster_slots_fail:%d\r\n", failed))
+	builder.WriteString(fmt.Sprintf("cluster_known_nodes:%d\r\n", len(cluster.nodes)))
+	builder.WriteString(fmt.Sprintf("cluster_size:%d\r\n", len(owners)))
+	builder.WriteString(fmt.Sprintf("cluster_current_epoch:%d\r\n", cluster.currentEpoch))
+	builder.WriteString(fmt.Sprintf("cluster_my_epoch:%d\r\n", cluster.nodes[cluster.address].configEpoch))
+	return redis.NewBulkStringCommand([]byte(builder.String()))
+}
+
+func execClusterMyID(cluster *Cluster, args [][]byte) *redis.RespCommand {
+	if len(args) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("cluster|myid"))
+	}
+	return redis.NewBulkStringCommand([]byte(nodeID(cluster.address)))
+}
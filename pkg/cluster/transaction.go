@@ -0,0 +1,425 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transactionTimeout 参与者保留事务的最长时间，超时后释放锁，已提交的事务丢弃undo log
+const transactionTimeout = 30 * time.Second
+
+// transaction 参与者节点上的分布式事务
+type transaction struct {
+	id        string
+	commands  []*redis.RespCommand // commands 本节点需要执行的命令
+	keys      []string             // keys 事务锁定的key
+	undoLogs  []*redis.RespCommand // undoLogs 恢复key在prepare时状态的命令
+	replies   [][]byte             // replies 提交后每条命令的回复
+	committed bool
+	created   time.Time
+}
+
+// txStep 一条排队的命令在各个节点上的子命令
+type txStep struct {
+	spec   *multiKeyCommand // spec 多key命令的合并方式，单key命令为nil
+	groups []*keyGroup
+	parts  []txPart
+}
+
+// txPart 子命令所在的节点，以及在该节点命令列表中的位置
+type txPart struct {
+	node  string
+	index int
+}
+
+// txPlan 事务在各个节点上的执行计划
+type txPlan struct {
+	nodes    []string
+	commands map[string][]*redis.RespCommand
+	steps    []*txStep
+}
+
+func init() {
+	busRouter["txprepare"] = execTxPrepare
+	busRouter["txcommit"] = execTxCommit
+	busRouter["txrollback"] = execTxRollback
+	busRouter["txrelease"] = execTxRelease
+}
+
+// execTransaction 使用两阶段提交执行分布在多个节点上的事务：
+// 1. prepare：每个节点锁定事务涉及的key，并记录恢复key的undo log
+// 2. commit：每个节点执行自己的命令，key保持锁定
+// 3. release：所有节点都提交成功后，通知每个节点释放锁并删除事务
+// 任意节点prepare失败时只通知prepare成功的节点rollback，commit失败时通知所有节点rollback，已经提交的节点通过undo log恢复key。
+// prepare的回复丢失时，节点上的事务在超时后释放。
+// 回滚之前key一直处于锁定状态，undo log不会覆盖其他客户端在提交之后的写入。
+// 和单机事务一样，命令自身的执行错误作为回复返回，不会触发回滚
+func execTransaction(cluster *Cluster, commands []*redis.RespCommand) *redis.RespCommand {
+	plan, err := cluster.planTransaction(commands)
+	if err != nil {
+		return redis.NewErrorCommand(redis.CreateTxAbortError(err.Error()))
+	}
+	txID := fmt.Sprintf("%s-%d", cluster.address, atomic.AddUint64(&cluster.txSeq, 1))
+	prepared := cluster.broadcastTx(plan.nodes, func(node string) *redis.RespCommand {
+		parts := [][]byte{[]byte("txprepare"), []byte(txID)}
+		for _, command := range plan.commands[node] {
+			parts = append(parts, redis.Encode(command))
+		}
+		return redis.NewCommand(parts)
+	})
+	if reply := firstError(prepared); reply != nil {
+		cluster.rollbackTx(txID, succeededNodes(plan.nodes, prepared))
+		return redis.NewErrorCommand(redis.CreateTxAbortError(errorMessage(reply)))
+	}
+	committed := cluster.broadcastTx(plan.nodes, func(node string) *redis.RespCommand {
+		return redis.NewCommand([][]byte{[]byte("txcommit"), []byte(txID)})
+	})
+	if reply := firstError(committed); reply != nil {
+		cluster.rollbackTx(txID, plan.nodes)
+		return redis.NewErrorCommand(redis.CreateTxAbortError(errorMessage(reply)))
+	}
+	cluster.releaseTxOnNodes(txID, plan.nodes)
+	// 解码每个节点的回复，再按照命令的顺序合并
+	nodeReplies := make(map[string][]*redis.RespCommand)
+	for i, node := range plan.nodes {
+		replies := make([]*redis.RespCommand, 0, len(committed[i].Parts()))
+		for _, part := range committed[i].Parts() {
+			reply, err := decodeRESP(part)
+			if err != nil {
+				reply = redis.NewErrorCommand(err)
+			}
+			replies = append(replies, reply)
+		}
+		nodeReplies[node] = replies
+	}
+	result := make([][]byte, len(plan.steps))
+	for i, step := range plan.steps {
+		replies := make([]*redis.RespCommand, len(step.parts))
+		for j, part := range step.parts {
+			replies[j] = nodeReplies[part.node][part.index]
+		}
+		if step.spec == nil {
+			result[i] = redis.Encode(replies[0])
+		} else {
+			keyCount := len(commands[i].Args()) / step.spec.step
+			result[i] = redis.Encode(step.spec.merge(keyCount, step.groups, replies))
+		}
+	}
+	return redis.NewNestedArrayCommand(result)
+}
+
+// planTransaction 按照key所在的节点拆分排队的命令，多key命令拆分为每个节点上的子命令
+func (c *Cluster) planTransaction(commands []*redis.RespCommand) (*txPlan, error) {
+	plan := &txPlan{commands: make(map[string][]*redis.RespCommand)}
+	add := func(step *txStep, node string, command *redis.RespCommand) {
+		if _, ok := plan.commands[node]; !ok {
+			plan.nodes = append(plan.nodes, node)
+		}
+		step.parts = append(step.parts, txPart{node: node, index: len(plan.commands[node])})
+		plan.commands[node] = append(plan.commands[node], command)
+	}
+	for _, command := range commands {
+		step := &txStep{spec: multiKeyCommands[command.Name()]}
+		if step.spec != nil {
+			groups, err := c.groupKeys(command, step.spec.step, false)
+			if err != nil {
+				return nil, err
+			}
+			step.groups = groups
+			for _, group := range groups {
+				add(step, group.node, subCommand(command, step.spec, group))
+			}
+		} else {
+			node, _, _ := c.route(command, string(command.Args()[0]))
+			if err := c.checkServed(node); err != nil {
+				return nil, err
+			}
+			add(step, node, command)
+		}
+		plan.steps = append(plan.steps, step)
+	}
+	return plan, nil
+}
+
+// broadcastTx 并行地向参与事务的节点发送命令，回复的顺序与nodes相同
+func (c *Cluster) broadcastTx(nodes []string, build func(node string) *redis.RespCommand) []*redis.RespCommand {
+	replies := make([]*redis.RespCommand, len(nodes))
+	wg := sync.WaitGroup{}
+	wg.Add(len(nodes))
+	for i, node := range nodes {
+		go func(i int, node string) {
+			defer wg.Done()
+			command := build(node)
+			// 本节点直接调用事务命令的处理器
+			if node == c.address {
+				replies[i] = busRouter[command.Name()](c, command)
+				return
+			}
+			client, ok := c.peerClient(node)
+			if !ok {
+				replies[i] = redis.NewErrorCommand(redis.ClusterPeerNotFoundError)
+				return
+			}
+			replies[i] = client.RelayCommand(command)
+		}(i, node)
+	}
+	wg.Wait()
+	return replies
+}
+
+// succeededNodes 返回回复不是错误的节点，replies的顺序与nodes相同
+func succeededNodes(nodes []string, replies []*redis.RespCommand) []string {
+	result := make([]string, 0, len(nodes))
+	for i, reply := range replies {
+		if reply.Type() != redis.CommandTypeError {
+			result = append(result, nodes[i])
+		}
+	}
+	return result
+}
+
+// rollbackTx 通知参与者回滚事务，没有收到prepare的节点会忽略
+func (c *Cluster) rollbackTx(txID string, nodes []string) {
+	if len(nodes) == 0 {
+		return
+	}
+	replies := c.broadcastTx(nodes, func(node string) *redis.RespCommand {
+		return redis.NewCommand([][]byte{[]byte("txrollback"), []byte(txID)})
+	})
+	for i, reply := range replies {
+		if reply.Type() == redis.CommandTypeError {
+			log.Errorf("rollback transaction %s on %s error: %s", txID, nodes[i], errorMessage(reply))
+		}
+	}
+}
+
+// releaseTxOnNodes 所有节点提交成功后通知参与者释放锁，失败的节点在事务超时后释放
+func (c *Cluster) releaseTxOnNodes(txID string, nodes []string) {
+	replies := c.broadcastTx(nodes, func(node string) *redis.RespCommand {
+		return redis.NewCommand([][]byte{[]byte("txrelease"), []byte(txID)})
+	})
+	for i, reply := range replies {
+		if reply.Type() == redis.CommandTypeError {
+			log.Errorf("release transaction %s on %s error: %s", txID, nodes[i], errorMessage(reply))
+		}
+	}
+}
+
+// execTxPrepare TXPREPARE txid command [command ...]，命令为RESP编码的数组。
+// 检查key是否属于本节点，锁定key并记录undo log
+func execTxPrepare(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) < 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("txprepare"))
+	}
+	tx := &transaction{id: string(args[0]), created: time.Now()}
+	locking := make(map[string]bool)
+	for _, payload := range args[1:] {
+		cmd, err := decodeRESP(payload)
+		if err != nil || cmd.Type() != redis.CommandTypeArray || len(cmd.Parts()) == 0 {
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+		if _, ok := router[cmd.Name()]; !ok {
+			return redis.NewErrorCommand(redis.CreateUnknownCommandError(cmd.Name()))
+		}
+		for _, key := range commandKeys(cmd) {
+			if node, slot, ask := cluster.route(cmd, key); node != cluster.address {
+				return cluster.redirectError(slot, node, ask)
+			}
+			if !locking[key] {
+				locking[key] = true
+				tx.keys = append(tx.keys, key)
+			}
+		}
+		tx.commands = append(tx.commands, cmd)
+	}
+	cluster.txMutex.Lock()
+	for _, key := range tx.keys {
+		if owner, ok := cluster.lockedKeys[key]; ok && owner != tx.id {
+			cluster.txMutex.Unlock()
+			return redis.NewErrorCommand(redis.KeyLockedError)
+		}
+	}
+	for _, key := range tx.keys {
+		cluster.lockedKeys[key] = tx.id
+	}
+	cluster.transactions[tx.id] = tx
+	cluster.txMutex.Unlock()
+	// key已经锁定，undo log记录的就是事务执行前的状态
+	if len(tx.keys) > 0 {
+		parts := [][]byte{[]byte("undo-log")}
+		for _, key := range tx.keys {
+			parts = append(parts, []byte(key))
+		}
+		reply := cluster.executeLocally(redis.NewCommand(parts), nil)
+		if reply.Type() == redis.CommandTypeError {
+			cluster.releaseTx(tx)
+			return reply
+		}
+		for _, part := range reply.Parts() {
+			undoLog, err := decodeRESP(part)
+			if err != nil {
+				cluster.releaseTx(tx)
+				return redis.NewErrorCommand(err)
+			}
+			tx.undoLogs = append(tx.undoLogs, undoLog)
+		}
+	}
+	return redis.OKCommand
+}
+
+// execTxCommit TXCOMMIT txid，执行事务的命令，返回每条命令RESP编码的回复。
+// 提交后key仍然锁定，undo log保留到 TXRELEASE、TXROLLBACK 或者超时，用于其他节点提交失败时回滚
+func execTxCommit(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("txcommit"))
+	}
+	cluster.txMutex.Lock()
+	tx, ok := cluster.transactions[string(args[0])]
+	cluster.txMutex.Unlock()
+	if !ok {
+		return redis.NewErrorCommand(redis.TxNotFoundError)
+	}
+	if !tx.committed {
+		tx.replies = make([][]byte, len(tx.commands))
+		for i, cmd := range tx.commands {
+			tx.replies[i] = redis.Encode(cluster.executeLocally(cmd, nil))
+		}
+		cluster.txMutex.Lock()
+		tx.committed = true
+		cluster.txMutex.Unlock()
+	}
+	return redis.NewArrayCommand(tx.replies)
+}
+
+// execTxRollback TXROLLBACK txid，已经提交的事务执行undo log恢复key，然后释放锁
+func execTxRollback(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("txrollback"))
+	}
+	cluster.txMutex.Lock()
+	tx, ok := cluster.transactions[string(args[0])]
+	committed := ok && tx.committed
+	cluster.txMutex.Unlock()
+	if !ok {
+		return redis.NewErrorCommand(redis.TxNotFoundError)
+	}
+	if committed {
+		for _, undoLog := range tx.undoLogs {
+			if reply := cluster.executeLocally(undoLog, nil); reply.Type() == redis.CommandTypeError {
+				log.Errorf("transaction %s undo error: %s", tx.id, errorMessage(reply))
+			}
+		}
+	}
+	cluster.releaseTx(tx)
+	return redis.OKCommand
+}
+
+// execTxRelease TXRELEASE txid，所有节点都已提交，释放锁并丢弃undo log
+func execTxRelease(cluster *Cluster, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("txrelease"))
+	}
+	cluster.txMutex.Lock()
+	tx, ok := cluster.transactions[string(args[0])]
+	cluster.txMutex.Unlock()
+	if !ok {
+		return redis.NewErrorCommand(redis.TxNotFoundError)
+	}
+	cluster.releaseTx(tx)
+	return redis.OKCommand
+}
+
+// unlockKeys 释放事务锁定的key
+func (c *Cluster) unlockKeys(tx *transaction) {
+	c.txMutex.Lock()
+	defer c.txMutex.Unlock()
+	for _, key := range tx.keys {
+		if c.lockedKeys[key] == tx.id {
+			delete(c.lockedKeys, key)
+		}
+	}
+}
+
+// releaseTx 释放锁并删除事务
+func (c *Cluster) releaseTx(tx *transaction) {
+	c.unlockKeys(tx)
+	c.txMutex.Lock()
+	delete(c.transactions, tx.id)
+	c.txMutex.Unlock()
+}
+
+// expireTransactions 删除超时的事务，协调者宕机时防止key一直被锁定
+func (c *Cluster) expireTransactions() {
+	c.txMutex.Lock()
+	expired := make([]*transaction, 0)
+	for _, tx := range c.transactions {
+		if time.Since(tx.created) > transactionTimeout {
+			expired = append(expired, tx)
+			if !tx.committed {
+				log.Errorf("transaction %s timeout before commit, released", tx.id)
+			} else {
+				log.Errorf("transaction %s timeout before release, released", tx.id)
+			}
+		}
+	}
+	c.txMutex.Unlock()
+	for _, tx := range expired {
+		c.releaseTx(tx)
+	}
+}
+
+// checkLocked 命令的key被分布式事务锁定时返回错误
+func (c *Cluster) checkLocked(command redis.Command) error {
+	c.txMutex.Lock()
+	defer c.txMutex.Unlock()
+	if len(c.lockedKeys) == 0 {
+		return nil
+	}
+	for _, key := range commandKeys(command) {
+		if _, ok := c.lockedKeys[key]; ok {
+			return redis.KeyLockedError
+		}
+	}
+	return nil
+}
+
+// commandKeys 命令涉及的key，只支持集群模式下可以路由的命令
+func commandKeys(command redis.Command) []string {
+	args := command.Args()
+	if spec, ok := multiKeyCommands[command.Name()]; ok {
+		keys := make([]string, 0, len(args)/spec.step)
+		for i := 0; i < len(args); i += spec.step {
+			keys = append(keys, string(args[i]))
+		}
+		return keys
+	}
+	if keyCommands[command.Name()] && len(args) > 0 {
+		return []string{string(args[0])}
+	}
+	return nil
+}
+
+// decodeRESP 解码一段完整的RESP数据
+func decodeRESP(payload []byte) (*redis.RespCommand, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("ERR empty payload")
+	}
+	return redis.Decode(redis.NewBlockingReader(bufio.NewReader(bytes.NewReader(payload))))
+}
+
+// errorMessage 错误回复的内容
+func errorMessage(reply *redis.RespCommand) string {
+	return strings.TrimSpace(string(redis.Encode(reply))[1:])
+}
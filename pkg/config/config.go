@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"github.com/ghodss/yaml"
 	"io/ioutil"
 	"os"
@@ -12,55 +13,143 @@ import (
 type ServerProperties struct {
 	Databases         int      `yaml:"databases"`
 	AppendOnly        bool     `yaml:"appendOnly"`
-	UseScheduleExpire bool     `yaml:"useScheduleExpire"`
+	UseScheduleExpire bool     `yaml:"useScheduleExpire"` // UseScheduleExpire 开启定期删除，每秒 hz 次抽样删除过期的key
 	AppendFsync       string   `yaml:"appendFsync"`
 	AofFileName       string   `yaml:"aofFileName"`
-	MaxMemory         int64    `yaml:"maxMemory"`
+	AppendDirName     string   `yaml:"appendDirName"`     // AppendDirName multi-part AOF的目录，保存base、incr文件和manifest
+	AofUseRdbPreamble bool     `yaml:"aofUseRdbPreamble"` // AofUseRdbPreamble AOF重写时以RDB格式保存数据快照
+	AofLoadTruncated  bool     `yaml:"aofLoadTruncated"`  // AofLoadTruncated 加载时截掉AOF结尾不完整的命令，而不是拒绝启动
+	MaxMemory         int64    `yaml:"maxMemory"`         // MaxMemory 数据集的内存上限，单位字节，小于等于0时不限制
 	DBFileName        string   `yaml:"dbFileName"`
 	Address           string   `yaml:"address"`
 	EnableClusterMode bool     `yaml:"enableClusterMode"`
 	Peers             []string `yaml:"peers"`
 	Self              string   `yaml:"self"`
 	DebugMode         bool     `yaml:"debugMode"`
-	RdbThreshold      int      `yaml:"rdbThreshold"`
-	RdbTime           int      `yaml:"rdbTime"`
+	RdbChecksum       bool     `yaml:"rdbChecksum"`     // RdbChecksum RDB文件结尾写入CRC64校验和，加载时校验
+	RdbCompatible     bool     `yaml:"rdbCompatible"`   // RdbCompatible 按Redis的RDB格式写入，生成的文件可以被Redis加载
+	ReplicaOf         string   `yaml:"replicaOf"`       // ReplicaOf master地址，格式为 "host port"
+	ReplicaReadOnly   bool     `yaml:"replicaReadOnly"` // ReplicaReadOnly 从节点是否拒绝客户端的写命令
+	ReplBacklogSize   int      `yaml:"replBacklogSize"` // ReplBacklogSize 复制积压缓冲区大小，单位字节
+
+	Hz int `yaml:"hz"` // Hz 每秒执行定期删除等后台任务的次数
+
+	DictType string `yaml:"dictType"` // DictType 数据库保存key使用的哈希表实现，simple 或 incremental
+
+	MaxMemoryPolicy  string `yaml:"maxMemoryPolicy"`  // MaxMemoryPolicy 内存超过 maxMemory 后的淘汰策略
+	MaxMemorySamples int    `yaml:"maxMemorySamples"` // MaxMemorySamples 每次淘汰时每个数据库抽样的key数量
+
+	AofTimestampEnabled bool `yaml:"aofTimestampEnabled"` // AofTimestampEnabled AOF中每秒写入一次 #TS:<unix毫秒> 时间戳注释，用于按时间点恢复
+
+	Save                    string `yaml:"save"`                    // Save 自动bgsave的条件，"900 1 300 10" 表示900秒内至少1次修改或300秒内至少10次修改，为空时关闭
+	StopWritesOnBgsaveError bool   `yaml:"stopWritesOnBgsaveError"` // StopWritesOnBgsaveError 上次bgsave失败时拒绝写命令
+
+	RdbThreshold int `yaml:"rdbThreshold"` // Deprecated: 使用 save。没有配置 save 时转换成 "<rdbTime> <rdbThreshold>"
+	RdbTime      int `yaml:"rdbTime"`      // Deprecated: 使用 save。与 rdbThreshold 一起转换成 save，单位秒，不大于0时为1秒
+
+	SentinelMode bool               `yaml:"sentinelMode"` // SentinelMode 以哨兵模式启动
+	Sentinel     SentinelProperties `yaml:"sentinel"`
+
+	ClusterRouting         string `yaml:"clusterRouting"`         // ClusterRouting 集群模式下key不在本节点时的处理方式，proxy 或 redirect
+	ClusterAnnounceAddress string `yaml:"clusterAnnounceAddress"` // ClusterAnnounceAddress 重定向时告诉客户端的本节点地址，为空时使用 address
+	ClusterNodeTimeout     int    `yaml:"clusterNodeTimeout"`     // ClusterNodeTimeout 节点超过该时间没有回复判定为疑似下线，单位毫秒
+	ClusterTransaction     bool   `yaml:"clusterTransaction"`     // ClusterTransaction MULTI/EXEC 的命令分布在多个节点时使用两阶段提交
+}
+
+// SentinelProperties 哨兵模式的配置
+type SentinelProperties struct {
+	Masters      []SentinelMasterProperties `yaml:"masters"`      // Masters 需要监控的master
+	AnnounceIP   string                     `yaml:"announceIp"`   // AnnounceIP hello消息中宣告的ip，为空时使用连接被监控实例的本地ip
+	AnnouncePort int                        `yaml:"announcePort"` // AnnouncePort hello消息中宣告的端口，为0时使用 address 的端口
+}
+
+type SentinelMasterProperties struct {
+	Name                  string `yaml:"name"`
+	Address               string `yaml:"address"`               // Address master地址，格式为 host:port
+	Quorum                int    `yaml:"quorum"`                // Quorum 判定客观下线需要的哨兵数量
+	DownAfterMilliseconds int    `yaml:"downAfterMilliseconds"` // DownAfterMilliseconds 实例无响应多久后判定为主观下线
+	FailoverTimeout       int    `yaml:"failoverTimeout"`       // FailoverTimeout 故障转移的超时时间，单位毫秒
 }
 
 var Properties *ServerProperties
 
+// RecoverUntil 命令行参数 -recover-until，启动时只加载该时间点之前的AOF记录。
+// 恢复后新的写入都在该时间点之后，所以只能在本次启动时使用，不能写在配置文件中
+var RecoverUntil string
+
 const (
+	FsyncAlways   = "always"
 	FsyncEverySec = "everysec"
 	FsyncNo       = "no"
 
-	AppendOnlyOn     = "on"
-	AppendOnlyOff    = "off"
-	EvictAllLRU      = "all-lru"
-	EvictVolatileLRU = "volatile-lru"
+	AppendOnlyOn  = "on"
+	AppendOnlyOff = "off"
+
+	EvictNoEviction     = "noeviction"      // EvictNoEviction 不淘汰key，内存不足时拒绝写命令
+	EvictAllKeysLRU     = "allkeys-lru"     // EvictAllKeysLRU 在所有key中淘汰最久没有访问的key
+	EvictVolatileLRU    = "volatile-lru"    // EvictVolatileLRU 在设置了过期时间的key中淘汰最久没有访问的key
+	EvictAllKeysLFU     = "allkeys-lfu"     // EvictAllKeysLFU 在所有key中淘汰访问频率最低的key
+	EvictVolatileLFU    = "volatile-lfu"    // EvictVolatileLFU 在设置了过期时间的key中淘汰访问频率最低的key
+	EvictAllKeysRandom  = "allkeys-random"  // EvictAllKeysRandom 在所有key中随机淘汰
+	EvictVolatileRandom = "volatile-random" // EvictVolatileRandom 在设置了过期时间的key中随机淘汰
+	EvictVolatileTTL    = "volatile-ttl"    // EvictVolatileTTL 淘汰最快过期的key
+
+	DictSimple      = "simple"      // DictSimple 使用Go的map，扩容时一次性迁移所有key
+	DictIncremental = "incremental" // DictIncremental 与Redis相同的渐进式rehash哈希表，支持稳定的SCAN游标
+
+	ClusterRoutingProxy    = "proxy"    // ClusterRoutingProxy 节点代替客户端转发命令
+	ClusterRoutingRedirect = "redirect" // ClusterRoutingRedirect 返回 -MOVED 或 -ASK，由客户端重新发送到目标节点
 )
 
 func init() {
 	Properties = &ServerProperties{
 		Databases:         16,
 		AppendOnly:        false,
-		UseScheduleExpire: false,
+		UseScheduleExpire: true,
 		AppendFsync:       FsyncNo,
 		AofFileName:       "appendonly.aof",
+		AppendDirName:     "appendonlydir",
+		AofLoadTruncated:  true,
 		DBFileName:        "dump.rdb",
 		MaxMemory:         -1,
 		EnableClusterMode: false,
 		Peers:             []string{},
 		Self:              "127.0.0.1:16380",
 		DebugMode:         true,
+		ReplicaReadOnly:   true,
+		ReplBacklogSize:   1 << 20,
+		RdbChecksum:       true,
+		ClusterRouting:    ClusterRoutingProxy,
+
+		Hz: 10,
+
+		DictType: DictIncremental,
+
+		MaxMemoryPolicy:  EvictNoEviction,
+		MaxMemorySamples: 5,
+
+		Save:                    "3600 1 300 100 60 10000",
+		StopWritesOnBgsaveError: true,
+
+		ClusterNodeTimeout: 15000,
 	}
+}
+
+// Setup 解析命令行参数并加载配置文件，由 cmd/server 启动时调用。测试等其他程序不调用时使用 init 中的默认配置
+func Setup() {
 	var appendOnly string
 	flag.IntVar(&Properties.Databases, "databases", 16, "count of databases")
 	flag.StringVar(&appendOnly, "appendonly", "off", "enable aof")
 	flag.StringVar(&Properties.AofFileName, "appendFilename", "appendonly.aof", "aof filename")
 	flag.StringVar(&Properties.DBFileName, "dbFileName", "dump.rdb", "RDB filename")
 	flag.Int64Var(&Properties.MaxMemory, "maxMemory", -1, "max memory option")
+	flag.StringVar(&Properties.MaxMemoryPolicy, "maxMemoryPolicy", EvictNoEviction, "eviction policy when used memory exceeds maxMemory")
 	flag.BoolVar(&Properties.EnableClusterMode, "clusterMode", false, "enable cluster")
 	flag.BoolVar(&Properties.DebugMode, "debugMode", false, "enable debug mode")
 	flag.StringVar(&Properties.Address, "address", "0.0.0.0:6381", "redigo server address")
+	flag.StringVar(&Properties.ReplicaOf, "replicaof", "", "master address, format: \"host port\"")
+	flag.StringVar(&RecoverUntil, "recover-until", "", "replay aof only up to this time, unix milliseconds or RFC3339")
+	flag.BoolVar(&Properties.SentinelMode, "sentinel", false, "run in sentinel mode")
 	configFileName := flag.String("config", "./redis.yaml", "custom config filename")
 	flag.Parse()
 	Properties.AppendOnly = strings.ToLower(appendOnly) == AppendOnlyOn
@@ -82,9 +171,45 @@ func parseYAML(file *os.File) *ServerProperties {
 	if err != nil {
 		panic(err)
 	}
+	keys := make(map[string]interface{})
+	if err = yaml.Unmarshal(bytes, &keys); err != nil {
+		panic(err)
+	}
+	applyLegacySave(configs, keys)
 	return configs
 }
 
+// applyLegacySave 兼容旧的 rdbThreshold 和 rdbTime 配置：配置文件中没有 save 时转换成等价的 save 条件，否则忽略旧配置
+func applyLegacySave(configs *ServerProperties, keys map[string]interface{}) {
+	if !hasKey(keys, "rdbThreshold") && !hasKey(keys, "rdbTime") {
+		return
+	}
+	if hasKey(keys, "save") {
+		log.Warn("rdbThreshold and rdbTime are deprecated and ignored when save is set, save: %q", configs.Save)
+		return
+	}
+	if configs.RdbThreshold <= 0 {
+		log.Warn("rdbThreshold and rdbTime are deprecated, use save instead, rdbThreshold %d ignored", configs.RdbThreshold)
+		return
+	}
+	seconds := configs.RdbTime
+	if seconds <= 0 {
+		seconds = 1
+	}
+	configs.Save = fmt.Sprintf("%d %d", seconds, configs.RdbThreshold)
+	log.Warn("rdbThreshold and rdbTime are deprecated, use save instead, converted to save: %q", configs.Save)
+}
+
+// hasKey 配置文件中是否出现了 key，与yaml解析到结构体时一样不区分大小写
+func hasKey(keys map[string]interface{}, key string) bool {
+	for k := range keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
 func loadConfigs(configFilePath string) {
 	file, err := os.Open(configFilePath)
 	if err != nil {
@@ -95,11 +220,24 @@ func loadConfigs(configFilePath string) {
 }
 
 func DisplayConfigs() {
+	if Properties.SentinelMode {
+		for _, master := range Properties.Sentinel.Masters {
+			log.Info("sentinel monitor master: %s, address: %s, quorum: %d", master.Name, master.Address, master.Quorum)
+		}
+		log.Info("server address: %s", Properties.Address)
+		return
+	}
 	if Properties.AppendOnly {
-		log.Info("append-only enabled, fsync: %s, aof file: %s", Properties.AppendFsync, Properties.AofFileName)
+		log.Info("append-only enabled, fsync: %s, aof dir: %s, aof file: %s", Properties.AppendFsync, Properties.AppendDirName, Properties.AofFileName)
 	} else {
 		log.Info("append-only off")
 	}
 	log.Info("RDB file name: %s", Properties.DBFileName)
 	log.Info("server address: %s", Properties.Address)
+	if Properties.ReplicaOf != "" {
+		log.Info("replica of master: %s", Properties.ReplicaOf)
+	}
+	if Properties.EnableClusterMode {
+		log.Info("cluster address: %s, routing: %s", Properties.Self, Properties.ClusterRouting)
+	}
 }
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"redigo/pkg/datastruct/bitmap"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/rdb"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"sync"
+	"time"
+)
+
+/*
+bgsave 不fork进程，而是在executor中截取所有数据库的快照，再由单独的goroutine把快照写入RDB。
+
+截取快照时只记录每个key的entry和value的引用，不复制数据。之后executor继续执行命令：
+字符串等类型的写命令会替换 entry.Data，不影响快照中的引用；原地修改value的写命令都会先通过 GetEntry 获取entry，
+如果这个key还没有被写入RDB，GetEntry 会先把value复制一份放进快照（copy-on-write），保证快照是bgsave开始时的状态。
+DEL、EXPIRE、RENAME等 unlinkCommands 只删除或重命名key，不修改value，GetEntry 不复制。
+快照用entry的指针索引，所以key被RENAME或MOVE之后依然能找到。
+*/
+
+// unlinkCommands 只删除、重命名key或者修改ttl，不修改value的写命令，bgsave期间不需要复制它们获取的value
+var unlinkCommands = map[string]bool{
+	"del": true, "persist": true, "expire": true, "pexpireat": true, "rename": true, "renamenx": true, "migrate": true,
+}
+
+// snapshotEntry 快照中的一个key
+type snapshotEntry struct {
+	key    string
+	data   interface{}
+	expire *time.Time
+	saved  bool // saved 已经写入RDB，之后的修改不需要再复制
+	copied bool // copied data 已经是复制出来的value
+}
+
+type snapshot struct {
+	mu      sync.Mutex // mu 保护正在写入的key不被executor修改
+	dbs     [][]*snapshotEntry
+	entries map[*database.Entry]*snapshotEntry
+}
+
+func (s *snapshot) Len(dbIdx int) int {
+	return len(s.dbs[dbIdx])
+}
+
+// ForEach 遍历快照，每个key写入期间持有锁，executor要修改这个key时需要等待写入完成
+func (s *snapshot) ForEach(dbIdx int, fun func(key string, entry *database.Entry, expire *time.Time) bool) {
+	for _, e := range s.dbs[dbIdx] {
+		s.mu.Lock()
+		next := fun(e.key, &database.Entry{Key: e.key, Data: e.data}, e.expire)
+		e.saved = true
+		s.mu.Unlock()
+		if !next {
+			return
+		}
+	}
+}
+
+// preserve executor修改entry之前调用，如果entry还没有写入RDB，复制它的value
+func (s *snapshot) preserve(entry *database.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[entry]; ok && !e.saved && !e.copied {
+		e.data = cloneData(e.data)
+		e.copied = true
+	}
+}
+
+// cloneData 复制可以被原地修改的value
+func cloneData(data interface{}) interface{} {
+	switch value := data.(type) {
+	case []byte:
+		return append([]byte(nil), value...)
+	case *bitmap.BitMap:
+		bm := bitmap.BitMap(append([]byte(nil), *value...))
+		return &bm
+	case *list.LinkedList:
+		l := list.NewLinkedList()
+		value.ForEach(func(idx int, v []byte) bool {
+			l.AddRight(v)
+			return true
+		})
+		return l
+	case *set.Set:
+		s := set.NewSet()
+		value.ForEach(func(member string) bool {
+			s.Add(member)
+			return true
+		})
+		return s
+	case dict.Dict:
+		d := dict.New()
+		value.ForEach(func(key string, v interface{}) bool {
+			d.Put(key, v)
+			return true
+		})
+		return d
+	case *zset.SortedSet:
+		zs := zset.NewSortedSet()
+		value.ForEach(func(score float64, member string) bool {
+			zs.Add(member, score)
+			return true
+		})
+		return zs
+	default:
+		return data
+	}
+}
+
+// takeSnapshot 在executor中截取所有数据库的快照，跳过已经过期的key
+func (m *MultiDB) takeSnapshot() *snapshot {
+	s := &snapshot{
+		dbs:     make([][]*snapshotEntry, len(m.dbSet)),
+		entries: make(map[*database.Entry]*snapshotEntry),
+	}
+	now := time.Now()
+	for i, sdb := range m.dbSet {
+		entries := make([]*snapshotEntry, 0, sdb.Len(i))
+		sdb.ForEach(i, func(key string, entry *database.Entry, expire *time.Time) bool {
+			e := &snapshotEntry{key: key, data: entry.Data}
+			if expire != nil {
+				if expire.Before(now) {
+					return true
+				}
+				expireAt := *expire
+				e.expire = &expireAt
+			}
+			entries = append(entries, e)
+			s.entries[entry] = e
+			return true
+		})
+		s.dbs[i] = entries
+	}
+	for _, sdb := range m.dbSet {
+		sdb.(*SingleDB).snapshot.Store(s)
+	}
+	return s
+}
+
+// BGSave 截取快照后在goroutine中保存RDB，executor继续处理命令
+func BGSave(db *MultiDB, command redis.Command) *redis.RespCommand {
+	// 避免同时存在多个后台保存或AOF重写
+	if !db.aofHandler.RewriteStarted.CompareAndSwap(false, true) {
+		return redis.NewErrorCommand(redis.BackgroundSaveInProgressError)
+	}
+	db.saving.bgsaveStarted()
+	go db.bgsave(db.takeSnapshot())
+	return redis.NewSingleLineCommand([]byte("Background saving started"))
+}
+
+func (m *MultiDB) bgsave(s *snapshot) {
+	startTime := time.Now()
+	err := rdb.BGSave(s)
+	// 保存结束后不再需要copy-on-write
+	for _, sdb := range m.dbSet {
+		sdb.(*SingleDB).snapshot.Store(nil)
+	}
+	if err != nil {
+		log.Errorf("BGSave RDB error: %v", err)
+	} else {
+		log.Info("BGSave RDB finished: %d ms", time.Now().Sub(startTime).Milliseconds())
+	}
+	m.saving.bgsaveFinished(err == nil)
+	m.aofHandler.RewriteStarted.Store(false)
+}
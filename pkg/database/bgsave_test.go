@@ -0,0 +1,198 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"redigo/pkg/config"
+	"redigo/pkg/datastruct/bitmap"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/rdb"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/conn"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB 创建不加载RDB和AOF、不启动定时任务的数据库，命令直接在当前goroutine执行
+func newTestDB() *MultiDB {
+	db := NewTempDB(config.Properties.Databases)
+	db.loading = false
+	db.evictionPool = newEvictionPool()
+	for _, sdb := range db.dbSet {
+		sdb.(*SingleDB).freeMemory = db.freeMemoryIfNeeded
+	}
+	return db
+}
+
+// execute 以0号数据库的客户端执行命令
+func execute(db *MultiDB, args ...string) *redis.RespCommand {
+	command := redis.NewStringArrayCommand(args)
+	command.BindConnection(conn.NewFakeConnection(nil))
+	return db.Execute(command)
+}
+
+// describe 将value转换成与遍历顺序无关的字符串，用于比较两个数据库的内容
+func describe(data interface{}) string {
+	var items []string
+	switch value := data.(type) {
+	case []byte:
+		return string(value)
+	case *bitmap.BitMap:
+		return string(*value)
+	case *list.LinkedList:
+		value.ForEach(func(_ int, v []byte) bool {
+			items = append(items, string(v))
+			return true
+		})
+		return strings.Join(items, ",")
+	case *set.Set:
+		items = value.Members()
+	case dict.Dict:
+		value.ForEach(func(field string, v interface{}) bool {
+			items = append(items, field+"="+string(v.([]byte)))
+			return true
+		})
+	case *zset.SortedSet:
+		value.ForEach(func(score float64, member string) bool {
+			items = append(items, fmt.Sprintf("%s=%v", member, score))
+			return true
+		})
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprintf("unknown %T", data)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func dumpDB(db *MultiDB) map[string]string {
+	result := make(map[string]string)
+	db.ForEach(0, func(key string, entry *database.Entry, _ *time.Time) bool {
+		result[key] = describe(entry.Data)
+		return true
+	})
+	return result
+}
+
+// TestBGSaveSnapshot bgsave期间原地修改每种类型的value，RDB中保存的依然是开始bgsave时的数据。
+// 新增原地修改value的写命令时，除了加入 writeCommands，也需要加入这里的 mutations
+func TestBGSaveSnapshot(t *testing.T) {
+	db := newTestDB()
+	setup := [][]string{
+		{"set", "str", "hello"},
+		{"set", "num", "10"},
+		{"setbit", "bits", "7", "1"},
+		{"rpush", "list", "a", "b", "c"},
+		{"hset", "hash", "f1", "v1", "f2", "v2", "cnt", "1"},
+		{"sadd", "set", "a", "b", "c"},
+		{"zadd", "zset", "1", "a", "2", "b", "3", "c"},
+		{"geoadd", "geo", "13.361389", "38.115556", "palermo"},
+		{"set", "renamed", "v"},
+		{"set", "deleted", "v"},
+	}
+	for _, args := range setup {
+		if reply := execute(db, args...); reply.Type() == redis.CommandTypeError {
+			t.Fatalf("%v: %s", args, redis.Encode(reply))
+		}
+	}
+	expected := dumpDB(db)
+
+	s := db.takeSnapshot()
+	mutations := [][]string{
+		{"append", "str", " world"},
+		{"incr", "num"},
+		{"incrby", "num", "5"},
+		{"decr", "num"},
+		{"decrby", "num", "2"},
+		{"setbit", "bits", "100", "1"},
+		{"lpush", "list", "x"},
+		{"rpush", "list", "y"},
+		{"lpop", "list"},
+		{"rpop", "list"},
+		{"rpoplpush", "list", "list2"},
+		{"hset", "hash", "f1", "changed"},
+		{"hdel", "hash", "f2"},
+		{"hsetnx", "hash", "f3", "v3"},
+		{"hincrby", "hash", "cnt", "10"},
+		{"sadd", "set", "d"},
+		{"srem", "set", "a"},
+		{"spop", "set", "1"},
+		{"zadd", "zset", "10", "a"},
+		{"zrem", "zset", "b"},
+		{"zpopmin", "zset", "1"},
+		{"zpopmax", "zset", "1"},
+		{"geoadd", "geo", "15.087269", "37.502669", "catania"},
+		{"rename", "renamed", "renamed2"},
+		{"del", "deleted"},
+	}
+	for _, args := range mutations {
+		if reply := execute(db, args...); reply.Type() == redis.CommandTypeError {
+			t.Fatalf("%v: %s", args, redis.Encode(reply))
+		}
+	}
+	buffer := &bytes.Buffer{}
+	if err := rdb.Write(s, buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, sdb := range db.dbSet {
+		sdb.(*SingleDB).snapshot.Store(nil)
+	}
+
+	current := dumpDB(db)
+	for key, value := range expected {
+		if current[key] == value {
+			t.Errorf("key %s not modified by mutations", key)
+		}
+	}
+	loaded := newTestDB()
+	if err := decodeRDB(loaded, bufio.NewReader(buffer)); err != nil {
+		t.Fatal(err)
+	}
+	saved := dumpDB(loaded)
+	if len(saved) != len(expected) {
+		t.Errorf("expect %d keys in rdb, got %d", len(expected), len(saved))
+	}
+	for key, value := range expected {
+		if saved[key] != value {
+			t.Errorf("key %s in rdb: expect %q, got %q", key, value, saved[key])
+		}
+	}
+}
+
+// TestBGSaveSnapshotUnlink bgsave期间只删除、重命名key或修改ttl的写命令不复制value
+func TestBGSaveSnapshotUnlink(t *testing.T) {
+	db := newTestDB()
+	for _, key := range []string{"l1", "l2", "l3", "l4"} {
+		execute(db, "rpush", key, "a", "b", "c")
+	}
+	s := db.takeSnapshot()
+	for _, args := range [][]string{
+		{"expire", "l1", "100"},
+		{"persist", "l1"},
+		{"rename", "l2", "l5"},
+		{"renamenx", "l3", "l6"},
+		{"del", "l4"},
+	} {
+		if reply := execute(db, args...); reply.Type() == redis.CommandTypeError {
+			t.Fatalf("%v: %s", args, redis.Encode(reply))
+		}
+	}
+	for _, e := range s.entries {
+		if e.copied {
+			t.Errorf("value of %s copied", e.key)
+		}
+	}
+	execute(db, "rpush", "l1", "d")
+	for _, e := range s.dbs[0] {
+		if e.key == "l1" && !e.copied {
+			t.Errorf("value of l1 not copied before rpush")
+		}
+	}
+}
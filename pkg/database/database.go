@@ -1,14 +1,18 @@
 package database
 
 import (
+	"bufio"
+	"fmt"
 	"redigo/pkg/aof"
 	"redigo/pkg/config"
 	"redigo/pkg/interface/database"
 	"redigo/pkg/pubsub"
 	"redigo/pkg/rdb"
 	"redigo/pkg/redis"
+	"redigo/pkg/replication"
 	"redigo/pkg/util/log"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,12 +21,22 @@ MultiDB multiDB 是DB接口的多数据库实现。
 提供了Redis中的切换数据库、移动key、持久化等跨数据库命令。
 */
 type MultiDB struct {
-	dbSet       []database.DB                                     // dbSet 数据库集合，默认是16个单独的数据库
-	cmdChan     chan redis.Command                                // cmdChan 并发模式下的命令缓冲channel
-	executors   map[string]func(redis.Command) *redis.RespCommand // executors 命令执行器map，记录命令与executor的映射
-	aofHandler  *aof.Handler                                      // aofHandler AOF持久化功能组件
-	hub         *pubsub.Hub                                       // hub 发布订阅功能组件
-	modifyCount int
+	dbSet      []database.DB                                     // dbSet 数据库集合，默认是16个单独的数据库
+	cmdChan    chan redis.Command                                // cmdChan 并发模式下的命令缓冲channel
+	executors  map[string]func(redis.Command) *redis.RespCommand // executors 命令执行器map，记录命令与executor的映射
+	aofHandler *aof.Handler                                      // aofHandler AOF持久化功能组件
+	hub        *pubsub.Hub                                       // hub 发布订阅功能组件
+	master     *replication.Master                               // master 主从复制的master状态，写命令通过它传播给从节点
+	replica    *replication.Replica                              // replica 当前节点作为从节点时与master的复制连接
+	saving     *savingState                                      // saving RDB自动保存的条件和状态
+
+	evictionPool *evictionPool // evictionPool maxMemory淘汰的候选key
+	nextEvictDB  int           // nextEvictDB 随机淘汰时下一次开始抽样的数据库
+	evictedKeys  int64         // evictedKeys 因为maxMemory被淘汰的key数量
+
+	expire expireState // expire 定期删除的进度和统计
+
+	loading bool // loading 正在重放AOF，重放的命令不触发maxMemory淘汰和OOM拒绝
 }
 
 // NewTempDB 创建临时数据库，临时数据库只用在AOF重写上
@@ -31,6 +45,9 @@ func NewTempDB(dbSize int) *MultiDB {
 		dbSet:     make([]database.DB, dbSize),
 		cmdChan:   make(chan redis.Command, 0),
 		executors: make(map[string]func(redis.Command) *redis.RespCommand),
+		saving:    newSavingState(nil),
+		// 临时数据库只用来重放AOF，内存不足时也不能淘汰或拒绝命令，否则重写的AOF会丢失数据
+		loading: true,
 	}
 	db.initCommandExecutors()
 	// initialize single databases in db set
@@ -41,44 +58,80 @@ func NewTempDB(dbSize int) *MultiDB {
 }
 
 func NewMultiDB(dbSize, cmdChanSize int) *MultiDB {
+	saveParams, err := parseSaveParams(config.Properties.Save)
+	if err != nil {
+		panic(err)
+	}
+	if !isEvictionPolicy(config.Properties.MaxMemoryPolicy) {
+		panic(fmt.Errorf("invalid maxMemoryPolicy: %s", config.Properties.MaxMemoryPolicy))
+	}
+	if config.Properties.Hz <= 0 {
+		panic(fmt.Errorf("invalid hz: %d", config.Properties.Hz))
+	}
+	if dictType := config.Properties.DictType; dictType != config.DictSimple && dictType != config.DictIncremental {
+		panic(fmt.Errorf("invalid dictType: %s", dictType))
+	}
 	db := &MultiDB{
 		dbSet:     make([]database.DB, dbSize),
 		cmdChan:   make(chan redis.Command, cmdChanSize),
 		executors: make(map[string]func(redis.Command) *redis.RespCommand),
 		hub:       pubsub.MakeHub(),
+		master:    replication.NewMaster(config.Properties.ReplBacklogSize),
+		saving:    newSavingState(saveParams),
+
+		evictionPool: newEvictionPool(),
 	}
 	db.initCommandExecutors()
+	db.initReplicationExecutors()
 	for i := 0; i < dbSize; i++ {
 		db.dbSet[i] = NewSingleDB(i)
 	}
 	// 初始化aof
 	if config.Properties.AppendOnly {
+		db.loading = true
 		aofHandler, err := aof.NewAofHandler(db, func() database.DB {
 			return NewTempDB(config.Properties.Databases)
+		}, func(db database.DB, reader *bufio.Reader) error {
+			return decodeRDB(db.(*MultiDB), reader)
 		})
 		if err != nil {
 			panic(err)
 		}
-		// 设置每个数据库的aofHandler
-		for _, sdb := range db.dbSet {
-			singleDB := sdb.(*SingleDB)
-			singleDB.addAof = func(command [][]byte) {
-				aofHandler.AddAof(command, singleDB.idx)
-			}
-		}
 		db.aofHandler = aofHandler
+		db.loading = false
 	} else {
 		// dummyHandler 是没有开启aof时的空handler
 		db.aofHandler = aof.NewDummyAofHandler()
 	}
-	rdbStart := time.Now()
-	err := loadRDB(db)
-	if err != nil {
-		log.Errorf("load rdb error: %v", err)
-	} else {
-		log.Info("RDB Loaded time used: %d ms", time.Now().Sub(rdbStart).Milliseconds())
+	// 设置每个数据库的写命令传播函数，写命令同时写入AOF和主从复制的积压缓冲区
+	for _, sdb := range db.dbSet {
+		singleDB := sdb.(*SingleDB)
+		singleDB.addAof = func(command [][]byte) {
+			if config.Properties.AppendOnly {
+				db.aofHandler.AddAof(command, singleDB.idx)
+			}
+			db.master.Feed(command, singleDB.idx)
+			db.saving.dirty.Add(1)
+		}
+		singleDB.freeMemory = db.freeMemoryIfNeeded
+	}
+	// 开启AOF时数据只从AOF加载，与Redis相同
+	if !config.Properties.AppendOnly {
+		rdbStart := time.Now()
+		err = loadRDB(db)
+		if err != nil {
+			log.Errorf("load rdb error: %v", err)
+		} else {
+			log.Info("RDB Loaded time used: %d ms", time.Now().Sub(rdbStart).Milliseconds())
+		}
 	}
 	scheduleSaving(db)
+	if config.Properties.UseScheduleExpire {
+		scheduleActiveExpire(db)
+	}
+	if host, port, ok := parseReplicaOf(config.Properties.ReplicaOf); ok {
+		db.startReplication(host, port)
+	}
 	return db
 }
 
@@ -93,6 +146,7 @@ func (m *MultiDB) initCommandExecutors() {
 	m.executors["bgrewriteaof"] = m.execBGRewriteAOF
 	m.executors["dbsize"] = m.execDBSize
 	m.executors["flushdb"] = m.execFlushDB
+	m.executors["flushall"] = m.execFlushAll
 	m.executors["multi"] = m.execMulti
 	m.executors["exec"] = m.execMultiExec
 	m.executors["watch"] = m.execWatch
@@ -105,6 +159,10 @@ func (m *MultiDB) initCommandExecutors() {
 	m.executors["save"] = m.execSave
 	m.executors["bgsave"] = m.execBGSave
 	m.executors["timed-bgsave"] = m.execTimedBGSave
+	m.executors["lastsave"] = m.execLastSave
+	m.executors["info"] = m.execInfo
+	m.executors["memory"] = m.execMemory
+	m.executors[activeExpireCommand] = m.execActiveExpireCycle
 }
 
 func (m *MultiDB) SubmitCommand(command redis.Command) {
@@ -116,6 +174,12 @@ func (m *MultiDB) Close() {
 		// close aof handler
 		m.aofHandler.Close()
 	}
+	if m.replica != nil {
+		m.replica.Stop()
+	}
+	if m.master != nil {
+		m.master.Close()
+	}
 	close(m.cmdChan)
 }
 
@@ -129,7 +193,7 @@ func (m *MultiDB) ExecuteLoop() error {
 		// 服务器级别的命令会返回reply，数据库命令会由数据库处理器执行
 		reply := m.Execute(cmd)
 		if reply != nil {
-			cmd.Connection().SendCommand(reply)
+			m.aofHandler.SendReply(cmd.Connection(), reply)
 		}
 	}
 }
@@ -144,6 +208,10 @@ func (m *MultiDB) Len(dbIdx int) int {
 func (m *MultiDB) Execute(command redis.Command) *redis.RespCommand {
 	conn := command.Connection()
 	name := command.Name()
+	if conn == nil {
+		// 服务器内部提交的命令（例如定时bgsave）没有客户端连接
+		return m.executeCommand(command)
+	}
 	if name == "multi" {
 		return m.execMulti(command)
 	} else if name == "exec" {
@@ -161,6 +229,18 @@ func (m *MultiDB) OnConnectionClosed(conn redis.Connection) {
 
 func (m *MultiDB) executeCommand(command redis.Command) *redis.RespCommand {
 	cmdName := command.Name()
+	if m.isReadOnlyRejected(command) {
+		return redis.NewErrorCommand(redis.ReadOnlyReplicaError)
+	}
+	if m.saving.writeRejected() && writeCommands[cmdName] && !replication.IsMasterConnection(command.Connection()) {
+		return redis.NewErrorCommand(redis.BgsaveErrorMisconfError)
+	}
+	// 内存超过maxMemory时先淘汰key，淘汰失败时拒绝会增加内存的写命令
+	if writeCommands[cmdName] {
+		if err := m.freeMemoryIfNeeded(); err != nil && denyOOMCommands[cmdName] {
+			return redis.NewErrorCommand(err)
+		}
+	}
 	if exec, ok := m.executors[cmdName]; ok {
 		return exec(command)
 	} else {
@@ -235,6 +315,20 @@ func (m *MultiDB) execFlushDB(command redis.Command) *redis.RespCommand {
 	return redis.OKCommand
 }
 
+// execFlushAll FLUSHALL [ASYNC]，清空所有数据库
+func (m *MultiDB) execFlushAll(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) > 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("FLUSHALL"))
+	}
+	async := len(args) == 1 && string(args[0]) == "ASYNC"
+	for _, db := range m.dbSet {
+		db.(*SingleDB).flushDB(async)
+	}
+	m.dbSet[0].(*SingleDB).addAof([][]byte{[]byte("FLUSHALL")})
+	return redis.OKCommand
+}
+
 func (m *MultiDB) execMulti(command redis.Command) *redis.RespCommand {
 	conn := command.Connection()
 	return StartMulti(conn)
@@ -350,27 +444,53 @@ func (m *MultiDB) execSave(command redis.Command) *redis.RespCommand {
 	startTime := time.Now()
 	err := rdb.Save(m)
 	if err != nil {
+		log.Errorf("RDB save error: %v", err)
 		return redis.NilCommand
 	}
+	m.saving.saved()
 	log.Info("RDB saved, time used: %d ms", time.Now().Sub(startTime).Milliseconds())
 	return redis.OKCommand
 }
 
+// execBGSave BGSAVE [SCHEDULE]，AOF重写正在进行时，SCHEDULE 让bgsave在重写结束后执行
 func (m *MultiDB) execBGSave(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	schedule := len(args) == 1 && strings.ToLower(string(args[0])) == "schedule"
+	if len(args) > 1 || len(args) == 1 && !schedule {
+		return redis.NewErrorCommand(redis.SyntaxError)
+	}
+	if m.saving.inProgress() {
+		return redis.NewErrorCommand(redis.BackgroundSaveInProgressError)
+	}
+	if m.aofHandler.RewriteStarted.Load().(bool) {
+		if !schedule {
+			return redis.NewErrorCommand(redis.BackgroundChildActiveError)
+		}
+		m.saving.scheduled.Store(true)
+		return redis.NewSingleLineCommand([]byte("Background saving scheduled"))
+	}
 	return BGSave(m, command)
 }
 
+// execTimedBGSave 定时检查：执行被 BGSAVE SCHEDULE 推迟的bgsave，或者满足 save 条件时自动bgsave
 func (m *MultiDB) execTimedBGSave(command redis.Command) *redis.RespCommand {
-	if m.modifyCount >= config.Properties.RdbThreshold {
-		// do rdb saving
+	// reschedule rdb saving
+	defer scheduleSaving(m)
+	if m.aofHandler.RewriteStarted.Load().(bool) {
+		return nil
+	}
+	if m.saving.scheduled.CompareAndSwap(true, false) {
+		BGSave(m, command)
+		return nil
+	}
+	if param, ok := m.saving.matchSaveParam(time.Now().Unix()); ok {
+		log.Info("%d changes in %d seconds. Saving...", param.changes, param.seconds)
 		BGSave(m, command)
 	}
-	m.modifyCount = 0
-	// reschedule rdb saving
-	scheduleSaving(m)
 	return nil
 }
 
-func (m *MultiDB) increaseModifyCount() {
-	m.modifyCount++
+// execLastSave LASTSAVE，返回上次成功保存RDB的unix时间戳
+func (m *MultiDB) execLastSave(command redis.Command) *redis.RespCommand {
+	return redis.NewNumberCommand(int(m.saving.lastSave.Load()))
 }
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"redigo/pkg/util/str"
+	"time"
+)
+
+/*
+定期删除（active expire cycle）：
+
+惰性删除只在访问key时删除过期的key，只写入一次、之后不再访问的key会一直占用内存。
+开启 useScheduleExpire 后，每秒执行 hz 次定期删除，与Redis的 activeExpireCycle 相同：
+从上次中断的数据库开始，每轮在 ttlMap 中随机抽样20个key，删除其中已经过期的key，
+过期key超过抽样数量的10%时说明还有较多过期key，继续下一轮抽样。
+每次执行最多占用 1000/hz 毫秒中的25%，超过时间后停止，下一次从中断的数据库继续。
+
+定期删除由定时器向executor提交内部命令执行，不会与其他命令并发修改数据库。
+从节点不主动删除过期key，由master传播的DEL删除。
+*/
+
+const (
+	// activeExpireCycleKeysPerLoop 每轮抽样的key数量
+	activeExpireCycleKeysPerLoop = 20
+	// activeExpireCycleAcceptableStale 过期key占抽样数量的百分比不超过该值时停止抽样
+	activeExpireCycleAcceptableStale = 10
+	// activeExpireCycleSlowTimePercent 定期删除最多占用的CPU时间百分比
+	activeExpireCycleSlowTimePercent = 25
+	// activeExpireCommand 提交到executor的内部命令
+	activeExpireCommand = "active-expire-cycle"
+)
+
+// expireState 定期删除的进度和统计
+type expireState struct {
+	nextDB           int   // nextDB 下一次定期删除开始的数据库
+	timeCapReached   int64 // timeCapReached 因为超出时间限制而中断的次数
+	lastStalePercent int   // lastStalePercent 最近一次定期删除中过期key占抽样数量的百分比
+}
+
+// scheduleActiveExpire 1000/hz 毫秒后执行一次定期删除
+func scheduleActiveExpire(db *MultiDB) {
+	time.AfterFunc(time.Second/time.Duration(config.Properties.Hz), func() {
+		db.SubmitCommand(redis.NewSingleLineCommand(str.StringToBytes(activeExpireCommand)))
+	})
+}
+
+// execActiveExpireCycle 在executor中执行一次定期删除
+func (m *MultiDB) execActiveExpireCycle(_ redis.Command) *redis.RespCommand {
+	// 执行结束后再安排下一次，executor繁忙时不会积压定期删除命令
+	defer scheduleActiveExpire(m)
+	if m.replica != nil {
+		return nil
+	}
+	timeLimit := time.Second / time.Duration(config.Properties.Hz) * activeExpireCycleSlowTimePercent / 100
+	start := time.Now()
+	sampled, expired := 0, 0
+	for i := 0; i < len(m.dbSet); i++ {
+		idx := m.expire.nextDB % len(m.dbSet)
+		m.expire.nextDB = idx + 1
+		db := m.dbSet[idx].(*SingleDB)
+		for loops := 1; db.ttlMap.Len() > 0; loops++ {
+			s, e := db.activeExpire(activeExpireCycleKeysPerLoop)
+			sampled += s
+			expired += e
+			// 与Redis相同，每16轮检查一次时间，减少获取时间的开销
+			if loops%16 == 0 && time.Since(start) > timeLimit {
+				m.expire.timeCapReached++
+				m.finishActiveExpire(sampled, expired)
+				return nil
+			}
+			if e*100 <= s*activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+	m.finishActiveExpire(sampled, expired)
+	return nil
+}
+
+func (m *MultiDB) finishActiveExpire(sampled, expired int) {
+	if sampled > 0 {
+		m.expire.lastStalePercent = expired * 100 / sampled
+	}
+	if expired > 0 {
+		log.Debug("active expire cycle: %d keys sampled, %d keys expired", sampled, expired)
+	}
+}
+
+// activeExpire 在 ttlMap 中随机抽样，删除已经过期的key，返回抽样数量和删除数量
+func (db *SingleDB) activeExpire(samples int) (int, int) {
+	keys := db.ttlMap.RandomKeysDistinct(samples)
+	expired := 0
+	for _, key := range keys {
+		if _, ok := db.data.Get(key); !ok {
+			// key已经被删除，只残留了过期时间
+			db.ttlMap.Remove(key)
+			expired++
+		} else if db.expireIfNeeded(key) {
+			expired++
+		}
+	}
+	return len(keys), expired
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestActiveExpireCycle ttlMap中大部分key已经过期时，定期删除只删除过期的key
+func TestActiveExpireCycle(t *testing.T) {
+	db := newTestDB()
+	sdb := db.dbSet[0].(*SingleDB)
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		execute(db, "set", key, "v")
+		if i%10 == 0 {
+			sdb.ExpireAt(key, &future)
+		} else {
+			sdb.ExpireAt(key, &past)
+		}
+	}
+	// 每次定期删除在抽样中过期key的比例降到10%以下或者超出时间限制时停止，多次执行后剩余的过期key不超过10%
+	stale := func() int {
+		return (sdb.ttlMap.Len() - 1000) * 100 / sdb.ttlMap.Len()
+	}
+	cycles := 0
+	for ; cycles < 1000 && stale() > activeExpireCycleAcceptableStale; cycles++ {
+		db.execActiveExpireCycle(nil)
+	}
+	if stale() > activeExpireCycleAcceptableStale {
+		t.Fatalf("%d%% keys in ttlMap are expired after %d cycles", stale(), cycles)
+	}
+	// 没有过期的key不能被删除
+	for i := 0; i < 10000; i += 10 {
+		if _, ok := sdb.data.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("key %d not expired but deleted", i)
+		}
+	}
+	if sdb.data.Len() != sdb.ttlMap.Len() || int64(10000-sdb.data.Len()) != sdb.expiredKeys {
+		t.Errorf("keys: %d, ttl: %d, expired: %d", sdb.data.Len(), sdb.ttlMap.Len(), sdb.expiredKeys)
+	}
+}
@@ -282,7 +282,7 @@ func execHVals(db *SingleDB, command redis.Command) *redis.RespCommand {
 
 func isHash(entry *database.Entry) bool {
 	switch entry.Data.(type) {
-	case *dict.SimpleDict:
+	case *dict.SimpleDict, *dict.IncrementalDict:
 		return true
 	}
 	return false
@@ -296,8 +296,8 @@ func getOrInitHash(db *SingleDB, key string) (dict.Dict, error) {
 		}
 		return entry.Data.(dict.Dict), nil
 	} else {
-		hash := dict.NewSimpleDict()
-		db.data.Put(key, &database.Entry{Data: hash})
+		hash := dict.New()
+		db.data.Put(key, database.NewEntry(key, hash))
 		return hash, nil
 	}
 }
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"redigo/pkg/replication"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// infoSection 生成 INFO 命令中的一个部分
+type infoSection func(m *MultiDB) string
+
+var (
+	infoSections     = make(map[string]infoSection)
+	infoSectionOrder []string
+	startTime        = time.Now()
+)
+
+func init() {
+	registerInfoSection("server", infoServer)
+	registerInfoSection("memory", infoMemory)
+	registerInfoSection("persistence", infoPersistence)
+	registerInfoSection("stats", infoStats)
+	registerInfoSection("replication", infoReplication)
+	registerInfoSection("keyspace", infoKeyspace)
+}
+
+func registerInfoSection(name string, section infoSection) {
+	infoSections[name] = section
+	infoSectionOrder = append(infoSectionOrder, name)
+}
+
+// execInfo INFO [section]，不指定section时返回所有部分
+func (m *MultiDB) execInfo(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) > 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("info"))
+	}
+	builder := strings.Builder{}
+	if len(args) == 1 && !isAllSections(string(args[0])) {
+		section, ok := infoSections[strings.ToLower(string(args[0]))]
+		if ok {
+			builder.WriteString(section(m))
+		}
+	} else {
+		for i, name := range infoSectionOrder {
+			if i > 0 {
+				builder.WriteString(redis.CRLF)
+			}
+			builder.WriteString(infoSections[name](m))
+		}
+	}
+	return redis.NewBulkStringCommand([]byte(builder.String()))
+}
+
+func isAllSections(name string) bool {
+	name = strings.ToLower(name)
+	return name == "all" || name == "default" || name == "everything"
+}
+
+func infoServer(_ *MultiDB) string {
+	mode := "standalone"
+	if config.Properties.EnableClusterMode {
+		mode = "cluster"
+	}
+	return formatInfo("Server",
+		"redis_mode", mode,
+		"os", runtime.GOOS+" "+runtime.GOARCH,
+		"go_version", runtime.Version(),
+		"process_id", os.Getpid(),
+		"tcp_address", config.Properties.Address,
+		"uptime_in_seconds", int(time.Since(startTime).Seconds()),
+	)
+}
+
+func infoMemory(m *MultiDB) string {
+	used := m.usedMemory()
+	maxMemory := config.Properties.MaxMemory
+	if maxMemory < 0 {
+		maxMemory = 0
+	}
+	return formatInfo("Memory",
+		"used_memory", used,
+		"used_memory_human", formatMemory(used),
+		"maxmemory", maxMemory,
+		"maxmemory_human", formatMemory(maxMemory),
+		"maxmemory_policy", config.Properties.MaxMemoryPolicy,
+	)
+}
+
+func infoStats(m *MultiDB) string {
+	var expiredKeys int64
+	for _, db := range m.dbSet {
+		expiredKeys += db.(*SingleDB).expiredKeys
+	}
+	return formatInfo("Stats",
+		"expired_keys", expiredKeys,
+		"expired_stale_perc", m.expire.lastStalePercent,
+		"expired_time_cap_reached_count", m.expire.timeCapReached,
+		"evicted_keys", m.evictedKeys,
+	)
+}
+
+func infoPersistence(m *MultiDB) string {
+	bgsaveInProgress, currentBgsaveTime := 0, int64(-1)
+	if start := m.saving.bgsaveStart.Load(); start != 0 {
+		bgsaveInProgress = 1
+		currentBgsaveTime = (time.Now().UnixMilli() - start) / 1000
+	}
+	bgsaveStatus := "ok"
+	if !m.saving.lastBgsaveOK.Load() {
+		bgsaveStatus = "err"
+	}
+	aofEnabled := 0
+	if config.Properties.AppendOnly {
+		aofEnabled = 1
+	}
+	return formatInfo("Persistence",
+		"loading", 0,
+		"rdb_changes_since_last_save", m.saving.dirty.Load(),
+		"rdb_bgsave_in_progress", bgsaveInProgress,
+		"rdb_last_save_time", m.saving.lastSave.Load(),
+		"rdb_last_bgsave_status", bgsaveStatus,
+		"rdb_last_bgsave_time_sec", m.saving.lastBgsaveTime.Load(),
+		"rdb_current_bgsave_time_sec", currentBgsaveTime,
+		"aof_enabled", aofEnabled,
+	)
+}
+
+func infoReplication(m *MultiDB) string {
+	var fields []interface{}
+	if m.replica != nil {
+		linkStatus := "down"
+		if m.replica.State() == replication.LinkStateConnected {
+			linkStatus = "up"
+		}
+		syncInProgress := 0
+		if m.replica.State() == replication.LinkStateSync {
+			syncInProgress = 1
+		}
+		readOnly := 0
+		if config.Properties.ReplicaReadOnly {
+			readOnly = 1
+		}
+		fields = append(fields,
+			"role", "slave",
+			"master_host", m.replica.MasterHost(),
+			"master_port", m.replica.MasterPort(),
+			"master_link_status", linkStatus,
+			"master_last_io_seconds_ago", m.replica.LastIOSecondsAgo(),
+			"master_sync_in_progress", syncInProgress,
+			"slave_repl_offset", m.replica.Offset(),
+			"slave_read_only", readOnly,
+		)
+	} else {
+		fields = append(fields, "role", "master")
+	}
+	replicas := m.master.Replicas()
+	fields = append(fields, "connected_slaves", len(replicas))
+	for i, replica := range replicas {
+		lag := int(time.Since(replica.LastAckTime).Seconds())
+		fields = append(fields, fmt.Sprintf("slave%d", i),
+			fmt.Sprintf("ip=%s,port=%d,state=%s,offset=%d,lag=%d", replica.IP, replica.ListeningPort, replica.State, replica.AckOffset, lag))
+	}
+	fields = append(fields,
+		"master_replid", m.master.ReplID(),
+		"master_repl_offset", m.master.Offset(),
+	)
+	first, histLen, active := m.master.BacklogInfo()
+	backlogActive := 0
+	if active {
+		backlogActive = 1
+	}
+	fields = append(fields,
+		"repl_backlog_active", backlogActive,
+		"repl_backlog_size", m.master.BacklogSize(),
+		"repl_backlog_first_byte_offset", first,
+		"repl_backlog_histlen", histLen,
+	)
+	return formatInfo("Replication", fields...)
+}
+
+func infoKeyspace(m *MultiDB) string {
+	var fields []interface{}
+	for i, db := range m.dbSet {
+		size := db.Len(i)
+		if size == 0 {
+			continue
+		}
+		expires := db.(*SingleDB).ttlMap.Len()
+		fields = append(fields, fmt.Sprintf("db%d", i), fmt.Sprintf("keys=%d,expires=%d", size, expires))
+	}
+	return formatInfo("Keyspace", fields...)
+}
+
+// formatInfo 将 name, value 交替排列的字段格式化成 INFO 的一个部分
+func formatInfo(title string, fields ...interface{}) string {
+	builder := strings.Builder{}
+	builder.WriteString("# " + title + redis.CRLF)
+	for i := 0; i+1 < len(fields); i += 2 {
+		builder.WriteString(fmt.Sprintf("%v:%v", fields[i], fields[i+1]) + redis.CRLF)
+	}
+	return builder.String()
+}
@@ -26,7 +26,7 @@ func init() {
 	RegisterCommandExecutor("randomkey", execRandomKey, 0)
 }
 
-func execKeys(db *SingleDB, command redis.Command, keys []string) *redis.RespCommand {
+func execKeys(command redis.Command, keys []string) *redis.RespCommand {
 	args := command.Args()
 	if len(args) != 1 {
 		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("KEYS"))
@@ -37,7 +37,7 @@ func execKeys(db *SingleDB, command redis.Command, keys []string) *redis.RespCom
 	p := pattern.ParsePattern(string(args[0]))
 	i := 0
 	for _, key := range keys {
-		if p.Matches(key) && db.TTL(key) != -2 {
+		if p.Matches(key) {
 			keys[i] = key
 			i++
 		}
@@ -212,7 +212,7 @@ func getOrInitLinkedList(db *SingleDB, key string) (*list.LinkedList, error) {
 	var linkedList *list.LinkedList
 	if !exists {
 		linkedList = list.NewLinkedList()
-		entry = &database.Entry{Data: linkedList}
+		entry = database.NewEntry(key, linkedList)
 		db.data.Put(key, entry)
 		return linkedList, nil
 	} else {
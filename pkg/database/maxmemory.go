@@ -0,0 +1,326 @@
+package database
+
+import (
+	"math"
+	"redigo/pkg/config"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"sort"
+	"time"
+)
+
+/*
+maxMemory 内存淘汰：
+
+每个entry记录key和value估算占用的内存（Entry.Size），SingleDB.used 是数据库中所有entry的总和。
+key的添加和删除都经过 memoryDict，在Put和Remove时更新 used；写命令原地修改value（例如LPUSH）时，
+GetEntry 会记录被修改的entry，命令执行结束后重新估算它们的大小。
+
+写命令执行前以及 putEntry 添加新key前，如果所有数据库的 used 之和超过 maxMemory，按照 maxMemoryPolicy 淘汰key，
+putEntry 中淘汰时跳过当前命令读写的key。
+与Redis相同，淘汰使用抽样的近似算法：每个数据库随机抽取 maxMemorySamples 个key放进淘汰池，
+淘汰池保留最适合淘汰的16个候选key，每次淘汰池中得分最高的key。没有可以淘汰的key时，
+会增加内存的写命令返回 -OOM 错误，DEL、EXPIRE等不会增加内存的写命令依然可以执行。
+*/
+
+const (
+	// evictionPoolSize 淘汰池中保留的候选key数量，与Redis的 EVPOOL_SIZE 相同
+	evictionPoolSize = 16
+)
+
+// denyOOMCommands 会增加内存的写命令，内存不足并且没有可以淘汰的key时拒绝执行，与Redis中标记了 denyoom 的命令对应
+var denyOOMCommands = map[string]bool{
+	"set": true, "setnx": true, "append": true, "incr": true, "decr": true, "incrby": true, "decrby": true,
+	"setbit": true, "mset": true, "restore": true,
+	"lpush": true, "rpush": true, "rpoplpush": true,
+	"hset": true, "hsetnx": true, "hincrby": true,
+	"sadd": true, "sdiffstore": true, "sinterstore": true,
+	"zadd": true, "geoadd": true,
+}
+
+// isEvictionPolicy 检查 maxMemoryPolicy 配置是否有效
+func isEvictionPolicy(policy string) bool {
+	switch policy {
+	case config.EvictNoEviction, config.EvictAllKeysLRU, config.EvictVolatileLRU, config.EvictAllKeysLFU,
+		config.EvictVolatileLFU, config.EvictAllKeysRandom, config.EvictVolatileRandom, config.EvictVolatileTTL:
+		return true
+	default:
+		return false
+	}
+}
+
+// memoryDict 在添加和删除entry时更新数据库的内存统计
+type memoryDict struct {
+	dict.Dict
+	db *SingleDB
+}
+
+func (d *memoryDict) Put(key string, value interface{}) int {
+	d.release(key)
+	d.account(key, value)
+	return d.Dict.Put(key, value)
+}
+
+func (d *memoryDict) PutIfAbsent(key string, value interface{}) int {
+	if _, ok := d.Dict.Get(key); ok {
+		return 0
+	}
+	d.account(key, value)
+	return d.Dict.PutIfAbsent(key, value)
+}
+
+func (d *memoryDict) PutIfExists(key string, value interface{}) int {
+	if _, ok := d.Dict.Get(key); !ok {
+		return 0
+	}
+	d.release(key)
+	d.account(key, value)
+	return d.Dict.PutIfExists(key, value)
+}
+
+func (d *memoryDict) Remove(key string) int {
+	d.release(key)
+	return d.Dict.Remove(key)
+}
+
+func (d *memoryDict) Clear() {
+	d.db.used = 0
+	d.Dict.Clear()
+}
+
+func (d *memoryDict) account(key string, value interface{}) {
+	entry := value.(*database.Entry)
+	entry.Size = estimateSize(key, entry.Data, sizeSamples)
+	d.db.used += entry.Size
+	// 写命令在添加entry之后还可能修改value，命令结束后重新估算
+	if d.db.writing {
+		d.db.touched = append(d.db.touched, touchedEntry{key: key, entry: entry})
+	}
+}
+
+func (d *memoryDict) release(key string) {
+	if v, ok := d.Dict.Get(key); ok {
+		d.db.used -= v.(*database.Entry).Size
+	}
+}
+
+// touchedEntry 写命令执行过程中获取或添加的entry
+type touchedEntry struct {
+	key   string
+	entry *database.Entry
+}
+
+// updateSizes 写命令执行结束后，重新估算被修改的entry的大小
+func (db *SingleDB) updateSizes() {
+	for _, t := range db.touched {
+		if v, ok := db.data.Get(t.key); ok && v == t.entry {
+			size := estimateSize(t.key, t.entry.Data, sizeSamples)
+			db.used += size - t.entry.Size
+			t.entry.Size = size
+		}
+	}
+	db.touched = db.touched[:0]
+}
+
+// isTouched 检查key是否是当前写命令获取或添加的key。MSET等命令在 putEntry 中淘汰时，
+// 不能淘汰同一条命令刚刚写入或者读取的key，否则命令返回OK之后它写入的key可能已经不存在
+func (db *SingleDB) isTouched(key string) bool {
+	for _, t := range db.touched {
+		if t.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// evictionCandidate 淘汰池中的候选key，score 越大越应该被淘汰
+type evictionCandidate struct {
+	dbIdx int
+	key   string
+	score int64
+}
+
+// evictionPool 按照 score 从小到大排列的候选key
+type evictionPool struct {
+	candidates []evictionCandidate
+}
+
+func newEvictionPool() *evictionPool {
+	return &evictionPool{candidates: make([]evictionCandidate, 0, evictionPoolSize)}
+}
+
+// add 加入候选key，淘汰池已满时替换得分最低的候选key
+func (p *evictionPool) add(c evictionCandidate) {
+	for i, old := range p.candidates {
+		if old.dbIdx == c.dbIdx && old.key == c.key {
+			p.candidates[i].score = c.score
+			p.sort()
+			return
+		}
+	}
+	if len(p.candidates) < evictionPoolSize {
+		p.candidates = append(p.candidates, c)
+	} else if c.score > p.candidates[0].score {
+		p.candidates[0] = c
+	} else {
+		return
+	}
+	p.sort()
+}
+
+func (p *evictionPool) sort() {
+	sort.Slice(p.candidates, func(i, j int) bool {
+		return p.candidates[i].score < p.candidates[j].score
+	})
+}
+
+// pop 取出得分最高的候选key
+func (p *evictionPool) pop() (evictionCandidate, bool) {
+	if len(p.candidates) == 0 {
+		return evictionCandidate{}, false
+	}
+	c := p.candidates[len(p.candidates)-1]
+	p.candidates = p.candidates[:len(p.candidates)-1]
+	return c, true
+}
+
+// usedMemory 所有数据库中entry估算占用的内存
+func (m *MultiDB) usedMemory() int64 {
+	var used int64
+	for _, sdb := range m.dbSet {
+		used += sdb.(*SingleDB).used
+	}
+	return used
+}
+
+// freeMemoryIfNeeded 内存超过 maxMemory 时淘汰key，没有可以淘汰的key时返回OOM错误。
+// 从节点不主动淘汰，由master传播淘汰产生的DEL；与Redis相同，加载AOF时不淘汰，保证数据完整地恢复
+func (m *MultiDB) freeMemoryIfNeeded() error {
+	maxMemory := config.Properties.MaxMemory
+	if maxMemory <= 0 || m.replica != nil || m.loading {
+		return nil
+	}
+	for m.usedMemory() > maxMemory {
+		if !m.evictKey() {
+			return redis.OOMCommandNotAllowedError
+		}
+	}
+	return nil
+}
+
+// evictKey 按照 maxMemoryPolicy 淘汰一个key，没有可以淘汰的key时返回false
+func (m *MultiDB) evictKey() bool {
+	policy := config.Properties.MaxMemoryPolicy
+	volatile := policy == config.EvictVolatileLRU || policy == config.EvictVolatileLFU ||
+		policy == config.EvictVolatileRandom || policy == config.EvictVolatileTTL
+	switch policy {
+	case config.EvictNoEviction:
+		return false
+	case config.EvictAllKeysRandom, config.EvictVolatileRandom:
+		return m.evictRandomKey(volatile)
+	}
+	now := time.Now().UnixMilli()
+	for {
+		sampled := 0
+		for _, sdb := range m.dbSet {
+			sampled += sdb.(*SingleDB).populateEvictionPool(m.evictionPool, policy, volatile, now)
+		}
+		if sampled == 0 {
+			return false
+		}
+		for c, ok := m.evictionPool.pop(); ok; c, ok = m.evictionPool.pop() {
+			db := m.dbSet[c.dbIdx].(*SingleDB)
+			if db.evictable(c.key, volatile) {
+				db.onKeyEvict(c.key)
+				m.evictedKeys++
+				return true
+			}
+		}
+	}
+}
+
+// evictRandomKey 从上次淘汰的下一个数据库开始，随机淘汰一个key
+func (m *MultiDB) evictRandomKey(volatile bool) bool {
+	for i := 0; i < len(m.dbSet); i++ {
+		idx := (m.nextEvictDB + i) % len(m.dbSet)
+		db := m.dbSet[idx].(*SingleDB)
+		keys := db.data
+		if volatile {
+			keys = db.ttlMap
+		}
+		// 当前命令的key不能淘汰，最多尝试 keys.Len() 次，避免只剩下这些key时无限循环
+		for tries := keys.Len(); tries > 0 && keys.Len() > 0; tries-- {
+			key := keys.RandomKeys(1)[0]
+			if db.evictable(key, volatile) {
+				db.onKeyEvict(key)
+				m.evictedKeys++
+				m.nextEvictDB = idx + 1
+				return true
+			}
+			// ttlMap 中可能残留已经删除的key
+			if _, ok := db.data.Get(key); !ok {
+				db.ttlMap.Remove(key)
+			}
+		}
+	}
+	return false
+}
+
+// populateEvictionPool 抽样 maxMemorySamples 个key加入淘汰池，返回抽样到的有效key数量
+func (db *SingleDB) populateEvictionPool(pool *evictionPool, policy string, volatile bool, now int64) int {
+	keys := db.data
+	if volatile {
+		keys = db.ttlMap
+	}
+	if keys.Len() == 0 {
+		return 0
+	}
+	sampled := 0
+	for _, key := range keys.RandomKeysDistinct(config.Properties.MaxMemorySamples) {
+		v, ok := db.data.Get(key)
+		if !ok {
+			db.ttlMap.Remove(key)
+			continue
+		}
+		if db.isTouched(key) {
+			continue
+		}
+		entry := v.(*database.Entry)
+		var score int64
+		switch policy {
+		case config.EvictAllKeysLFU, config.EvictVolatileLFU:
+			score = 255 - int64(lfuDecr(entry, now))
+		case config.EvictVolatileTTL:
+			expire, _ := db.ttlMap.Get(key)
+			score = math.MaxInt64 - expire.(*time.Time).UnixMilli()
+		default:
+			score = now - entry.AccessTime
+		}
+		pool.add(evictionCandidate{dbIdx: db.idx, key: key, score: score})
+		sampled++
+	}
+	return sampled
+}
+
+// evictable 检查淘汰池中的key是否依然存在，并且不是当前命令读写的key
+func (db *SingleDB) evictable(key string, volatile bool) bool {
+	if _, ok := db.data.Get(key); !ok || db.isTouched(key) {
+		return false
+	}
+	if volatile {
+		_, ok := db.ttlMap.Get(key)
+		return ok
+	}
+	return true
+}
+
+// onKeyEvict 删除被淘汰的key，并将DEL写入AOF和传播给从节点
+func (db *SingleDB) onKeyEvict(key string) {
+	db.data.Remove(key)
+	db.CancelTTL(key)
+	db.versionMap.Remove(key)
+	db.addAof([][]byte{[]byte("del"), []byte(key)})
+	log.Debug("key: %s evicted", key)
+}
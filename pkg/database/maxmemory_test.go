@@ -0,0 +1,133 @@
+package database
+
+import (
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setMaxMemory 修改 maxMemory 配置，测试结束后恢复
+func setMaxMemory(t *testing.T, maxMemory int64, policy string) {
+	oldMaxMemory, oldPolicy := config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy
+	config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy = maxMemory, policy
+	t.Cleanup(func() {
+		config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy = oldMaxMemory, oldPolicy
+	})
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	policies := []string{
+		config.EvictAllKeysLRU, config.EvictVolatileLRU, config.EvictAllKeysLFU, config.EvictVolatileLFU,
+		config.EvictAllKeysRandom, config.EvictVolatileRandom, config.EvictVolatileTTL,
+	}
+	value := strings.Repeat("x", 50)
+	for _, policy := range policies {
+		t.Run(policy, func(t *testing.T) {
+			setMaxMemory(t, 20000, policy)
+			db := newTestDB()
+			volatile := strings.HasPrefix(policy, "volatile")
+			// volatile 策略只淘汰设置了过期时间的key
+			for i := 0; i < 10; i++ {
+				execute(db, "set", "persistent"+strconv.Itoa(i), value)
+			}
+			for i := 0; i < 1000; i++ {
+				key := "k" + strconv.Itoa(i)
+				if reply := execute(db, "set", key, value); reply != redis.OKCommand {
+					t.Fatalf("set %s: %s", key, redis.Encode(reply))
+				}
+				if volatile {
+					execute(db, "expire", key, strconv.Itoa(1000+i))
+				}
+			}
+			// 与Redis相同，淘汰在写命令执行之前进行，最后一条命令可能暂时超过 maxMemory
+			if err := db.freeMemoryIfNeeded(); err != nil || db.usedMemory() > 20000 {
+				t.Errorf("used memory %d exceeds maxMemory, err: %v", db.usedMemory(), err)
+			}
+			if db.evictedKeys == 0 {
+				t.Errorf("no key evicted")
+			}
+			for i := 0; volatile && i < 10; i++ {
+				if _, ok := db.dbSet[0].(*SingleDB).GetEntry("persistent" + strconv.Itoa(i)); !ok {
+					t.Errorf("key without ttl evicted by %s", policy)
+				}
+			}
+		})
+	}
+}
+
+func TestEvictionOOM(t *testing.T) {
+	for _, policy := range []string{config.EvictNoEviction, config.EvictVolatileLRU} {
+		t.Run(policy, func(t *testing.T) {
+			setMaxMemory(t, 2000, policy)
+			db := newTestDB()
+			oom := false
+			for i := 0; i < 100 && !oom; i++ {
+				reply := execute(db, "set", "k"+strconv.Itoa(i), strings.Repeat("x", 50))
+				oom = reply.Type() == redis.CommandTypeError
+				if oom && string(redis.Encode(reply)) != string(redis.Encode(redis.NewErrorCommand(redis.OOMCommandNotAllowedError))) {
+					t.Fatalf("unexpected error: %s", redis.Encode(reply))
+				}
+			}
+			if !oom {
+				t.Fatalf("expect -OOM error")
+			}
+			// 不会增加内存的写命令和读命令依然可以执行
+			if reply := execute(db, "get", "k0"); reply.Type() == redis.CommandTypeError {
+				t.Errorf("get rejected: %s", redis.Encode(reply))
+			}
+			if reply := execute(db, "del", "k0"); reply.Type() == redis.CommandTypeError {
+				t.Errorf("del rejected: %s", redis.Encode(reply))
+			}
+		})
+	}
+}
+
+func TestEvictionSkippedWhileLoading(t *testing.T) {
+	setMaxMemory(t, 2000, config.EvictAllKeysRandom)
+	db := newTestDB()
+	db.loading = true
+	for i := 0; i < 100; i++ {
+		if reply := execute(db, "set", "k"+strconv.Itoa(i), strings.Repeat("x", 50)); reply != redis.OKCommand {
+			t.Fatalf("set rejected while loading: %s", redis.Encode(reply))
+		}
+	}
+	if n := db.dbSet[0].Len(0); n != 100 || db.evictedKeys != 0 {
+		t.Fatalf("expect 100 keys without eviction, got %d keys, %d evicted", n, db.evictedKeys)
+	}
+}
+
+// TestEvictionMSet MSET 写入的数据超过 maxMemory 时，执行过程中只淘汰其他的key，命令写入的key全部保留
+func TestEvictionMSet(t *testing.T) {
+	value := strings.Repeat("x", 50)
+	args := []string{"mset"}
+	for i := 0; i < 50; i++ {
+		args = append(args, "m"+strconv.Itoa(i), value)
+	}
+	for _, policy := range []string{config.EvictAllKeysRandom, config.EvictAllKeysLRU, config.EvictNoEviction} {
+		t.Run(policy, func(t *testing.T) {
+			setMaxMemory(t, 4000, policy)
+			db := newTestDB()
+			for i := 0; i < 10; i++ {
+				execute(db, "set", "k"+strconv.Itoa(i), value)
+			}
+			if reply := execute(db, args...); reply != redis.OKCommand {
+				t.Fatalf("mset: %s", redis.Encode(reply))
+			}
+			for i := 0; i < 50; i++ {
+				if _, ok := db.dbSet[0].(*SingleDB).GetEntry("m" + strconv.Itoa(i)); !ok {
+					t.Fatalf("key m%d written by mset evicted", i)
+				}
+			}
+			// 只剩下MSET写入的key也无法满足 maxMemory，下一条写命令淘汰它们或者返回 -OOM
+			reply := execute(db, "set", "next", value)
+			if policy == config.EvictNoEviction && reply.Type() != redis.CommandTypeError {
+				t.Errorf("expect -OOM after mset, got %s", redis.Encode(reply))
+			}
+			if policy != config.EvictNoEviction && (reply != redis.OKCommand || db.usedMemory() > 4000+int64(entryOverhead+len(value)+4)) {
+				t.Errorf("set after mset: %s, used memory %d", redis.Encode(reply), db.usedMemory())
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"fmt"
+	"redigo/pkg/datastruct/bitmap"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/redis"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+/*
+内存估算：Go没有Redis的zmalloc可以精确统计每个对象的内存，这里按照各个数据结构的字段大小和元素数量估算，
+集合类型抽样部分元素计算平均大小。估算值用于 maxMemory 淘汰和 MEMORY 命令，与Go堆的实际占用会有差距，
+已经删除但还没有被GC回收的对象不会被计算。
+*/
+
+const (
+	// sizeSamples 估算集合类型value大小时抽样的元素数量
+	sizeSamples = 5
+
+	// entryOverhead Entry结构体、key在map中的桶以及value接口占用的内存
+	entryOverhead = 96
+	// containerOverhead list、set、hash、zset本身的结构体占用的内存
+	containerOverhead = 48
+	// listNodeOverhead 链表节点的前后指针和value的slice header
+	listNodeOverhead = 40
+	// setMemberOverhead set元素的哈希表节点或map桶（包含string header）
+	setMemberOverhead = 48
+	// hashFieldOverhead hash字段的哈希表节点或map桶（包含field的string header）和value的slice header
+	hashFieldOverhead = 72
+	// zsetMemberOverhead zset元素的哈希表节点或map桶和跳表节点
+	zsetMemberOverhead = 120
+	// expireOverhead ttlMap中一个过期时间：map桶、key的string header和time.Time
+	expireOverhead = 64
+
+	// memoryDoctorMinDataset 数据集小于该值时 MEMORY DOCTOR 不做检查，与Redis相同为5MB
+	memoryDoctorMinDataset = 5 << 20
+	// bigClientBuffer 普通客户端平均缓冲区超过该值时 MEMORY DOCTOR 给出提示
+	bigClientBuffer = 200 << 10
+	// bigReplicaBuffer 从节点平均缓冲区超过该值时 MEMORY DOCTOR 给出提示
+	bigReplicaBuffer = 10 << 20
+	// bigAofBuffer AOF缓冲区超过该值时 MEMORY DOCTOR 给出提示
+	bigAofBuffer = 32 << 20
+)
+
+// ClientBuffers 遍历所有客户端连接和它们的缓冲区大小，由tcp服务器启动时设置，用于 MEMORY STATS
+var ClientBuffers func(fun func(conn redis.Connection, bufferSize int64))
+
+// estimateSize 估算key和value占用的内存，集合类型抽样 samples 个元素计算平均大小，再乘以元素数量
+func estimateSize(key string, data interface{}, samples int) int64 {
+	size := int64(entryOverhead + len(key))
+	switch value := data.(type) {
+	case []byte:
+		size += int64(len(value))
+	case *bitmap.BitMap:
+		size += int64(len(*value))
+	case *list.LinkedList:
+		size += containerOverhead + sampleSize(value.Size(), samples, func(visit func(int) bool) {
+			value.ForEach(func(_ int, v []byte) bool {
+				return visit(listNodeOverhead + len(v))
+			})
+		})
+	case *set.Set:
+		size += containerOverhead + sampleSize(value.Len(), samples, func(visit func(int) bool) {
+			value.ForEach(func(member string) bool {
+				return visit(setMemberOverhead + len(member))
+			})
+		})
+	case dict.Dict:
+		size += containerOverhead + sampleSize(value.Len(), samples, func(visit func(int) bool) {
+			value.ForEach(func(field string, v interface{}) bool {
+				n := hashFieldOverhead + len(field)
+				if b, ok := v.([]byte); ok {
+					n += len(b)
+				}
+				return visit(n)
+			})
+		})
+	case *zset.SortedSet:
+		size += containerOverhead + sampleSize(value.Size(), samples, func(visit func(int) bool) {
+			value.ForEach(func(_ float64, member string) bool {
+				return visit(zsetMemberOverhead + len(member))
+			})
+		})
+	}
+	return size
+}
+
+// sampleSize 遍历前 samples 个元素计算平均大小，samples 小于等于0时遍历所有元素
+func sampleSize(count, samples int, forEach func(visit func(size int) bool)) int64 {
+	if count == 0 {
+		return 0
+	}
+	total, n := 0, 0
+	forEach(func(size int) bool {
+		total += size
+		n++
+		return samples <= 0 || n < samples
+	})
+	if n == 0 {
+		return 0
+	}
+	return int64(total) * int64(count) / int64(n)
+}
+
+// formatMemory 将字节数格式化成 INFO 中 *_human 字段的格式
+func formatMemory(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"MALLOC-STATS",
+	"    Return internal statistics report from the memory allocator.",
+	"PURGE",
+	"    Attempt to purge dirty pages for reclamation by the allocator.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// execMemory MEMORY USAGE|STATS|DOCTOR|MALLOC-STATS|PURGE|HELP
+func (m *MultiDB) execMemory(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) == 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("memory"))
+	}
+	subcommand := strings.ToLower(string(args[0]))
+	switch subcommand {
+	case "help":
+		return redis.NewStringArrayCommand(memoryHelp)
+	case "usage":
+		return m.execMemoryUsage(command)
+	case "stats", "doctor", "malloc-stats", "purge":
+		if len(args) != 1 {
+			return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("memory|" + subcommand))
+		}
+	default:
+		return redis.NewErrorCommand(redis.CreateUnknownSubcommandError(string(args[0]), "MEMORY"))
+	}
+	switch subcommand {
+	case "stats":
+		return m.collectMemoryStats().toReply()
+	case "doctor":
+		return redis.NewBulkStringCommand([]byte(m.collectMemoryStats().doctor()))
+	case "malloc-stats":
+		return redis.NewBulkStringCommand([]byte("Stats not supported for the current allocator"))
+	default:
+		// 把GC之后空闲的内存还给操作系统
+		debug.FreeOSMemory()
+		return redis.OKCommand
+	}
+}
+
+// execMemoryUsage MEMORY USAGE key [SAMPLES count]，不会更新key的访问时间
+func (m *MultiDB) execMemoryUsage(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 2 && len(args) != 4 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("memory|usage"))
+	}
+	samples := sizeSamples
+	if len(args) == 4 {
+		if strings.ToLower(string(args[2])) != "samples" {
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+		n, err := strconv.Atoi(string(args[3]))
+		if err != nil {
+			return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+		}
+		if n < 0 {
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+		// SAMPLES 0 表示计算所有元素
+		samples = n
+	}
+	db := m.dbSet[command.Connection().DBIndex()].(*SingleDB)
+	key := string(args[1])
+	v, exists := db.data.Get(key)
+	if !exists || db.expireIfNeeded(key) {
+		return redis.NilCommand
+	}
+	return redis.NewNumberCommand(int(estimateSize(key, v.(*database.Entry).Data, samples)))
+}
+
+// dbMemory 一个数据库的key和过期时间占用的额外内存
+type dbMemory struct {
+	idx     int
+	keys    int
+	main    int64
+	expires int64
+}
+
+type memoryStats struct {
+	heap               runtime.MemStats
+	used               int64
+	replicationBacklog int64
+	replicas           int
+	clientsSlaves      int64
+	clients            int
+	clientsNormal      int64
+	aofBuffer          int64
+	dbs                []dbMemory
+	overheadTotal      int64
+	keys               int
+	dataset            int64
+}
+
+// collectMemoryStats 在executor中统计数据集、数据库、客户端、复制和AOF缓冲区的内存
+func (m *MultiDB) collectMemoryStats() *memoryStats {
+	stats := &memoryStats{used: m.usedMemory()}
+	runtime.ReadMemStats(&stats.heap)
+	if _, _, ok := m.master.BacklogInfo(); ok {
+		stats.replicationBacklog = int64(m.master.BacklogSize())
+	}
+	if ClientBuffers != nil {
+		ClientBuffers(func(conn redis.Connection, bufferSize int64) {
+			if m.master.IsReplica(conn) {
+				stats.replicas++
+				stats.clientsSlaves += bufferSize
+			} else {
+				stats.clients++
+				stats.clientsNormal += bufferSize
+			}
+		})
+	}
+	stats.aofBuffer = m.aofHandler.BufferSize()
+	stats.overheadTotal = stats.replicationBacklog + stats.clientsSlaves + stats.clientsNormal + stats.aofBuffer
+	var mainOverhead int64
+	for i, sdb := range m.dbSet {
+		db := sdb.(*SingleDB)
+		keys := db.data.Len()
+		if keys == 0 {
+			continue
+		}
+		mem := dbMemory{idx: i, keys: keys, main: int64(keys) * entryOverhead, expires: int64(db.ttlMap.Len()) * expireOverhead}
+		stats.dbs = append(stats.dbs, mem)
+		stats.keys += keys
+		mainOverhead += mem.main
+		stats.overheadTotal += mem.main + mem.expires
+	}
+	// 每个entry的估算值包含了 entryOverhead，数据集中不重复计算
+	stats.dataset = stats.used - mainOverhead
+	return stats
+}
+
+// toReply 按照Redis MEMORY STATS 的字段名称返回
+func (s *memoryStats) toReply() *redis.RespCommand {
+	var fields [][]byte
+	add := func(name string, value *redis.RespCommand) {
+		fields = append(fields, redis.Encode(redis.NewBulkStringCommand([]byte(name))), redis.Encode(value))
+	}
+	number := func(n int64) *redis.RespCommand {
+		return redis.NewNumberCommand(int(n))
+	}
+	percentage := func(part, total int64) *redis.RespCommand {
+		if total <= 0 {
+			return redis.NewBulkStringCommand([]byte("0"))
+		}
+		return redis.NewBulkStringCommand([]byte(strconv.FormatFloat(float64(part)*100/float64(total), 'f', 2, 64)))
+	}
+	add("total.allocated", number(int64(s.heap.HeapAlloc)))
+	add("heap.inuse", number(int64(s.heap.HeapInuse)))
+	add("heap.retained", number(int64(s.heap.HeapIdle-s.heap.HeapReleased)))
+	add("replication.backlog", number(s.replicationBacklog))
+	add("clients.slaves", number(s.clientsSlaves))
+	add("clients.normal", number(s.clientsNormal))
+	add("aof.buffer", number(s.aofBuffer))
+	for _, db := range s.dbs {
+		add(fmt.Sprintf("db.%d", db.idx), redis.NewNestedArrayCommand([][]byte{
+			redis.Encode(redis.NewBulkStringCommand([]byte("overhead.hashtable.main"))),
+			redis.Encode(number(db.main)),
+			redis.Encode(redis.NewBulkStringCommand([]byte("overhead.hashtable.expires"))),
+			redis.Encode(number(db.expires)),
+		}))
+	}
+	add("overhead.total", number(s.overheadTotal))
+	add("keys.count", number(int64(s.keys)))
+	bytesPerKey := int64(0)
+	if s.keys > 0 {
+		bytesPerKey = s.used / int64(s.keys)
+	}
+	add("keys.bytes-per-key", number(bytesPerKey))
+	add("dataset.bytes", number(s.dataset))
+	add("dataset.percentage", percentage(s.dataset, s.dataset+s.overheadTotal))
+	return redis.NewNestedArrayCommand(fields)
+}
+
+// doctor 根据内存统计给出可能存在的问题
+func (s *memoryStats) doctor() string {
+	if s.dataset+s.overheadTotal < memoryDoctorMinDataset {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+	var issues []string
+	retained := int64(s.heap.HeapIdle - s.heap.HeapReleased)
+	if retained > int64(s.heap.HeapAlloc) {
+		issues = append(issues, fmt.Sprintf(" * Peak memory: In the past this instance used more memory than it is currently using. "+
+			"The Go runtime retains %s of freed heap that is not returned to the OS yet, "+
+			"MEMORY PURGE can release it immediately.", formatMemory(retained)))
+	}
+	if s.heap.HeapAlloc > 0 && float64(s.heap.HeapInuse)/float64(s.heap.HeapAlloc) > 1.4 {
+		issues = append(issues, fmt.Sprintf(" * High heap fragmentation: %s of heap spans are in use to hold %s of live objects, "+
+			"many partially used spans are kept by a large number of small values being deleted.",
+			formatMemory(int64(s.heap.HeapInuse)), formatMemory(int64(s.heap.HeapAlloc))))
+	}
+	if s.clients > 0 && s.clientsNormal/int64(s.clients) > bigClientBuffer {
+		issues = append(issues, fmt.Sprintf(" * Big client buffers: The clients buffers are using %s on average for %d clients. "+
+			"This may be caused by large pipelines or big MULTI transactions.",
+			formatMemory(s.clientsNormal/int64(s.clients)), s.clients))
+	}
+	if s.replicas > 0 && s.clientsSlaves/int64(s.replicas) > bigReplicaBuffer {
+		issues = append(issues, fmt.Sprintf(" * Big replica buffers: The replica output buffers are using %s on average. "+
+			"This may be caused by slow replicas or a full synchronization in progress.",
+			formatMemory(s.clientsSlaves/int64(s.replicas))))
+	}
+	if s.aofBuffer > bigAofBuffer {
+		issues = append(issues, fmt.Sprintf(" * Big AOF buffer: %s of write commands are waiting to be written to the AOF. "+
+			"The disk may be too slow for the current write load.", formatMemory(s.aofBuffer)))
+	}
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" +
+		strings.Join(issues, "\n\n") + "\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
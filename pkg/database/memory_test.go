@@ -0,0 +1,83 @@
+package database
+
+import (
+	"redigo/pkg/datastruct/bitmap"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"redigo/pkg/interface/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateSize(t *testing.T) {
+	bits := bitmap.New()
+	bits.SetBit(100, 1)
+	s := set.NewSet()
+	s.Add("a")
+	s.Add("bb")
+	hash := dict.NewIncrementalDict()
+	hash.Put("f", []byte("value"))
+	hash.Put("ff", []byte("v"))
+	zs := zset.NewSortedSet()
+	zs.Add("a", 1)
+	zs.Add("bb", 2)
+	tests := []struct {
+		name     string
+		data     interface{}
+		expected int
+	}{
+		{"string", []byte("hello"), 5},
+		{"bitmap", bits, len(*bits)},
+		{"list", list.NewLinkedList([]byte("a"), []byte("bb")), containerOverhead + 2*listNodeOverhead + 3},
+		{"set", s, containerOverhead + 2*setMemberOverhead + 3},
+		{"hash", hash, containerOverhead + 2*hashFieldOverhead + 3 + 6},
+		{"zset", zs, containerOverhead + 2*zsetMemberOverhead + 3},
+		{"empty list", list.NewLinkedList(), containerOverhead},
+	}
+	for _, test := range tests {
+		if size := estimateSize("key", test.data, 0); size != int64(entryOverhead+3+test.expected) {
+			t.Errorf("%s: expect %d, got %d", test.name, entryOverhead+3+test.expected, size)
+		}
+	}
+}
+
+func TestEstimateSizeSamples(t *testing.T) {
+	// 抽样前 samples 个元素的平均大小乘以元素数量
+	l := list.NewLinkedList([]byte(strings.Repeat("x", 10)), []byte(strings.Repeat("x", 30)), []byte("x"), []byte("x"))
+	if size := estimateSize("", l, 2); size != entryOverhead+containerOverhead+4*(listNodeOverhead+20) {
+		t.Errorf("2 samples: got %d", size)
+	}
+	if size := estimateSize("", l, 0); size != entryOverhead+containerOverhead+4*listNodeOverhead+42 {
+		t.Errorf("all samples: got %d", size)
+	}
+}
+
+// TestUsedMemory 写命令执行后重新估算entry的大小，used 与所有entry的估算值之和保持一致
+func TestUsedMemory(t *testing.T) {
+	db := newTestDB()
+	sdb := db.dbSet[0].(*SingleDB)
+	commands := [][]string{
+		{"set", "str", "hello"},
+		{"append", "str", strings.Repeat("x", 100)},
+		{"rpush", "list", "a", "b", "c"},
+		{"lpop", "list"},
+		{"hset", "hash", "f1", "v1", "f2", "v2"},
+		{"sadd", "set", "a", "b"},
+		{"zadd", "zset", "1", "a"},
+		{"del", "set"},
+	}
+	for _, args := range commands {
+		execute(db, args...)
+		var expected int64
+		sdb.ForEach(0, func(key string, entry *database.Entry, _ *time.Time) bool {
+			expected += estimateSize(key, entry.Data, sizeSamples)
+			return true
+		})
+		if sdb.used != expected {
+			t.Fatalf("after %v: expect used %d, got %d", args, expected, sdb.used)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"redigo/pkg/config"
+	"redigo/pkg/rdb"
+	"redigo/pkg/rdb/codec"
+	"redigo/pkg/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommandExecutor("dump", execDump, 1)
+	RegisterCommandExecutor("restore", execRestore, -3)
+	// restore-asking 由集群模式下的 MIGRATE 发送，允许写入目标节点正在迁入的slot
+	RegisterCommandExecutor("restore-asking", execRestore, -3)
+	RegisterCommandExecutor("migrate", execMigrate, -5)
+	// undo-log 由集群的分布式事务使用，生成恢复key当前状态的命令
+	RegisterCommandExecutor("undo-log", execUndoLog, -1)
+}
+
+// execDump DUMP key，返回key的序列化数据，结尾是RDB版本和CRC64校验和
+func execDump(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("dump"))
+	}
+	payload, err := db.Dump(string(args[0]))
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	if payload == nil {
+		return redis.NilCommand
+	}
+	return redis.NewBulkStringCommand(payload)
+}
+
+// execRestore RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func execRestore(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError(command.Name()))
+	}
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+	}
+	if ttl < 0 {
+		return redis.NewErrorCommand(redis.InvalidTTLError)
+	}
+	replace, absTTL := false, false
+	// IDLETIME 和 FREQ 只能使用其中一个，-1表示没有设置
+	idleTime, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		hasValue := i+1 < len(args)
+		switch {
+		case option == "replace":
+			replace = true
+		case option == "absttl":
+			absTTL = true
+		case option == "idletime" && hasValue && freq == -1:
+			i++
+			if idleTime, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil {
+				return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+			}
+			if idleTime < 0 {
+				return redis.NewErrorCommand(redis.InvalidIdleTimeError)
+			}
+		case option == "freq" && hasValue && idleTime == -1:
+			i++
+			if freq, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil {
+				return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+			}
+			if freq < 0 || freq > 255 {
+				return redis.NewErrorCommand(redis.InvalidFreqError)
+			}
+		default:
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+	}
+	if _, exists := db.GetEntry(key); exists && !replace {
+		return redis.NewErrorCommand(redis.BusyKeyError)
+	}
+	payload, err := rdb.VerifyPayload(args[2])
+	if err != nil {
+		return redis.NewErrorCommand(redis.DumpPayloadError)
+	}
+	// 去掉footer之后是 DUMP 时的类型字节 + key + value，这里只使用其中的value
+	decoder := codec.NewDecoder(bufio.NewReader(bytes.NewReader(payload)))
+	b, err := decoder.ReadByte()
+	if err != nil {
+		return redis.NewErrorCommand(redis.BadDumpPayloadError)
+	}
+	_, entry, err := readEntry(decoder, b)
+	if err != nil {
+		return redis.NewErrorCommand(redis.BadDumpPayloadError)
+	}
+	entry.Key = key
+	if idleTime >= 0 {
+		entry.AccessTime = time.Now().UnixMilli() - idleTime*1000
+	}
+	if freq >= 0 {
+		entry.Freq = uint8(freq)
+	}
+	db.DeleteEntry(key)
+	db.data.Put(key, entry)
+	db.addAof([][]byte{[]byte("restore"), []byte(key), []byte("0"), args[2], []byte("REPLACE")})
+	if ttl > 0 {
+		expireAt := time.UnixMilli(ttl)
+		if !absTTL {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		db.ExpireAt(key, &expireAt)
+		db.addAof([][]byte{[]byte("pexpireat"), []byte(key), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))})
+	}
+	return redis.OKCommand
+}
+
+// execUndoLog UNDO-LOG key [key ...]，为每个key生成一条恢复当前状态的命令：
+// 不存在的key为 DEL，存在的key为带绝对过期时间的 RESTORE ... REPLACE ABSTTL
+func execUndoLog(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("undo-log"))
+	}
+	undoLogs := make([][]byte, len(args))
+	for i, key := range args {
+		payload, err := db.Dump(string(key))
+		if err != nil {
+			return redis.NewErrorCommand(err)
+		}
+		if payload == nil {
+			undoLogs[i] = redis.Encode(redis.NewArrayCommand([][]byte{[]byte("del"), key}))
+			continue
+		}
+		var expireAt int64
+		if ttl := db.TTL(string(key)); ttl >= 0 {
+			expireAt = time.Now().Add(ttl).UnixMilli()
+		}
+		restore := [][]byte{[]byte("restore"), key, []byte(strconv.FormatInt(expireAt, 10)), payload, []byte("REPLACE"), []byte("ABSTTL")}
+		undoLogs[i] = redis.Encode(redis.NewArrayCommand(restore))
+	}
+	return redis.NewArrayCommand(undoLogs)
+}
+
+// execMigrate MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [KEYS key [key ...]]
+// 将key通过 RESTORE 发送到目标实例，目标实例返回成功后删除本地的key。
+// 迁移过程中会阻塞当前数据库的命令执行，所以迁移期间不会有新的写入丢失
+func execMigrate(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("migrate"))
+	}
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	dbIndex, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+	}
+	timeoutMs, err := strconv.Atoi(string(args[4]))
+	if err != nil {
+		return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+	}
+	if timeoutMs <= 0 {
+		timeoutMs = 1000
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	copyKeys, replace := false, false
+	var password string
+	var keys []string
+	if len(args[2]) > 0 {
+		keys = []string{string(args[2])}
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= len(args) {
+				return redis.NewErrorCommand(redis.SyntaxError)
+			}
+			i++
+			password = string(args[i])
+		case "keys":
+			// 使用 KEYS 选项时key参数必须为空字符串
+			if len(args[2]) > 0 {
+				return redis.NewErrorCommand(redis.SyntaxError)
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+	}
+	restoreCmd := "RESTORE"
+	if config.Properties.EnableClusterMode {
+		restoreCmd = "RESTORE-ASKING"
+	}
+	requests := [][]string{{"SELECT", strconv.Itoa(dbIndex)}}
+	if password != "" {
+		requests = append([][]string{{"AUTH", password}}, requests...)
+	}
+	restoreStart := len(requests)
+	// 序列化所有存在的key
+	migrated := make([]string, 0, len(keys))
+	for _, key := range keys {
+		payload, err := db.Dump(key)
+		if err != nil {
+			return redis.NewErrorCommand(err)
+		}
+		if payload == nil {
+			continue
+		}
+		var ttl int64
+		if t := db.TTL(key); t > 0 {
+			ttl = t.Milliseconds()
+			if ttl == 0 {
+				ttl = 1
+			}
+		}
+		request := []string{restoreCmd, key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			request = append(request, "REPLACE")
+		}
+		requests = append(requests, request)
+		migrated = append(migrated, key)
+	}
+	if len(migrated) == 0 {
+		return redis.NewSingleLineCommand([]byte("NOKEY"))
+	}
+	replyErrs, err := sendMigrateRequests(addr, timeout, requests)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	for i := 0; i < restoreStart; i++ {
+		if replyErrs[i] != nil {
+			return redis.NewErrorCommand(replyErrs[i])
+		}
+	}
+	// 只删除目标实例成功写入的key
+	var replyErr error
+	for i, key := range migrated {
+		if err := replyErrs[restoreStart+i]; err != nil {
+			replyErr = err
+			continue
+		}
+		if !copyKeys {
+			db.DeleteEntry(key)
+			db.addAof([][]byte{[]byte("del"), []byte(key)})
+		}
+	}
+	if replyErr != nil {
+		return redis.NewErrorCommand(replyErr)
+	}
+	return redis.OKCommand
+}
+
+// sendMigrateRequests 将所有请求一次性发送给目标实例，然后依次读取回复，返回每个请求的错误回复
+func sendMigrateRequests(addr string, timeout time.Duration, requests [][]string) ([]error, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, redis.MigrateConnectError
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	buffer := bytes.Buffer{}
+	for _, request := range requests {
+		buffer.Write(redis.Encode(redis.NewStringArrayCommand(request)))
+	}
+	if _, err := conn.Write(buffer.Bytes()); err != nil {
+		return nil, redis.CreateMigrateIOError("writing to")
+	}
+	reader := redis.NewBlockingReader(bufio.NewReader(conn))
+	replyErrs := make([]error, len(requests))
+	for i := range requests {
+		reply, err := redis.Decode(reader)
+		if err != nil {
+			return nil, redis.CreateMigrateIOError("reading from")
+		}
+		if reply.Type() == redis.CommandTypeError {
+			replyErrs[i] = redis.CreateMigrateTargetError(strings.TrimSpace(string(redis.Encode(reply))[1:]))
+		}
+	}
+	return replyErrs, nil
+}
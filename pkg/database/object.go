@@ -0,0 +1,141 @@
+package database
+
+import (
+	"math/rand"
+	"redigo/pkg/datastruct/bitmap"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommandExecutor("object", execObject, -1)
+}
+
+const (
+	// lfuLogFactor 访问频率计数器的对数因子，越大计数器增长越慢，与Redis的 lfu-log-factor 默认值相同
+	lfuLogFactor = 10
+	// lfuDecayTime 访问频率计数器每隔多少分钟没有访问就减1，与Redis的 lfu-decay-time 默认值相同
+	lfuDecayTime = 1
+	// embstrSizeLimit 不超过该长度的字符串在Redis中使用embstr编码
+	embstrSizeLimit = 44
+)
+
+// touchEntry 访问key时更新entry的访问时间和访问频率
+func touchEntry(entry *database.Entry) {
+	now := time.Now().UnixMilli()
+	entry.Freq = lfuLogIncr(lfuDecr(entry, now))
+	entry.AccessTime = now
+}
+
+// lfuDecr 按照距离上次访问的时间衰减访问频率计数器
+func lfuDecr(entry *database.Entry, now int64) uint8 {
+	periods := (now - entry.AccessTime) / int64(time.Minute/time.Millisecond) / lfuDecayTime
+	if periods <= 0 {
+		return entry.Freq
+	}
+	if periods >= int64(entry.Freq) {
+		return 0
+	}
+	return entry.Freq - uint8(periods)
+}
+
+// lfuLogIncr 计数器越大，增加的概率越小，255次访问以内的计数器可以表示百万级别的访问次数
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	base := float64(counter) - database.LFUInitFreq
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// objectEncoding 返回value在Redis中对应的编码名称
+func objectEncoding(data interface{}) string {
+	switch value := data.(type) {
+	case []byte:
+		if n, err := strconv.ParseInt(string(value), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(value) {
+			return "int"
+		}
+		if len(value) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *bitmap.BitMap:
+		return "raw"
+	case *list.LinkedList:
+		return "linkedlist"
+	case *set.Set, dict.Dict:
+		return "hashtable"
+	case *zset.SortedSet:
+		return "skiplist"
+	default:
+		return "unknown"
+	}
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// execObject OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key，查看key的内部信息，不会更新key的访问时间
+func execObject(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("object"))
+	}
+	subcommand := strings.ToLower(string(args[0]))
+	if subcommand == "help" {
+		return redis.NewStringArrayCommand(objectHelp)
+	}
+	switch subcommand {
+	case "encoding", "idletime", "freq", "refcount":
+		if len(args) != 2 {
+			return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("object|" + subcommand))
+		}
+	default:
+		return redis.NewErrorCommand(redis.CreateUnknownSubcommandError(string(args[0]), "OBJECT"))
+	}
+	key := string(args[1])
+	v, exists := db.data.Get(key)
+	if !exists || db.expireIfNeeded(key) {
+		return redis.NilCommand
+	}
+	entry := v.(*database.Entry)
+	switch subcommand {
+	case "encoding":
+		return redis.NewBulkStringCommand([]byte(objectEncoding(entry.Data)))
+	case "idletime":
+		return redis.NewNumberCommand(int((time.Now().UnixMilli() - entry.AccessTime) / 1000))
+	case "freq":
+		return redis.NewNumberCommand(int(lfuDecr(entry, time.Now().UnixMilli())))
+	default:
+		// redigo不共享value对象，引用计数总是1
+		return redis.NewNumberCommand(1)
+	}
+}
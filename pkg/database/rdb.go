@@ -10,6 +10,9 @@ import (
 	"redigo/pkg/redis"
 	"redigo/pkg/util/str"
 	"redigo/pkg/util/timewheel"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,157 +24,185 @@ func loadRDB(db *MultiDB) error {
 		return nil
 	}
 	defer rdbFile.Close()
+	return decodeRDB(db, bufio.NewReader(rdbFile))
+}
+
+// decodeRDB 从reader中读取RDB数据并加载到数据库中，redigo和Redis格式的RDB都可以加载
+func decodeRDB(db *MultiDB, reader *bufio.Reader) error {
 	// create a file decoder
-	decoder := codec.NewDecoder(bufio.NewReader(rdbFile))
-
-	// check rdb file header for MagicNum and Version
-	if isRedis, versionOK, err := checkHeader(decoder); err != nil {
-		return fmt.Errorf("rdb file read header error: %v", err)
-	} else if !isRedis {
-		return fmt.Errorf("not valid rdb file format")
-	} else if !versionOK {
-		return fmt.Errorf("unsupported rdb file version")
+	decoder := codec.NewDecoder(reader)
+	if !config.Properties.RdbChecksum {
+		decoder.DisableChecksum()
 	}
-
-	currentDBIndex := -1
-	for {
-		// read the type byte
-		b, err := decoder.ReadByte()
-		if err != nil {
-			return fmt.Errorf("rdb read type byte error: %v", err)
+	now := time.Now()
+	return decoder.Walk(func(object *codec.Object) error {
+		if object.DB >= len(db.dbSet) || object.DB < 0 {
+			return fmt.Errorf("rdb read db index error: invalid db index")
 		}
-		switch b {
-		case codec.SelectDB:
-			// select database
-			index, err := decoder.ReadDBIndex()
-			if err != nil {
-				return err
+		singleDB := db.dbSet[object.DB].(*SingleDB)
+		var expireAt time.Time
+		if object.ExpireAt != 0 {
+			expireAt = time.UnixMilli(object.ExpireAt)
+			if expireAt.Before(now) {
+				// skip already expired key
+				return nil
 			}
-			currentDBIndex = index
-		case codec.ReSizeDB:
-			// read current database's size
-			size, _, err := decoder.ReadDBSize()
-			if err != nil {
-				return err
-			}
-			// read size amount of key value pairs
-			err = readKeyValues(decoder, db, currentDBIndex, size)
-			if err != nil {
-				return err
-			}
-		case codec.EOF:
-			// end of RDB file
-			return nil
 		}
-	}
+		singleDB.data.Put(object.Key, database.NewEntry(object.Key, object.Value))
+		// set key's expire time
+		if object.ExpireAt != 0 {
+			singleDB.ExpireAt(object.Key, &expireAt)
+		}
+		return nil
+	})
 }
 
-// read key value pairs of current database
-func readKeyValues(decoder *codec.Decoder, db *MultiDB, dbIndex int, size uint64) error {
-	if dbIndex >= len(db.dbSet) || dbIndex < 0 {
-		return fmt.Errorf("rdb read db index error: invalid db index")
-	}
-	singleDB := db.dbSet[dbIndex].(*SingleDB)
-	var i uint64
-	for i = 0; i < size; i++ {
-		// read data type
-		b, err := decoder.ReadByte()
+// readEntry 根据类型字节读取一个key-value
+func readEntry(decoder *codec.Decoder, b byte) (string, *database.Entry, error) {
+	switch b {
+	case codec.StringType:
+		k, value, err := decoder.ReadStringObject()
 		if err != nil {
-			return fmt.Errorf("rdb read key value type error: %v", err)
+			return "", nil, fmt.Errorf("rdb read string object error: %v", err)
 		}
-		hasExpire := false
-		var expireTime uint64
-		// parse expire time
-		if b == codec.ExpireTimeMs {
-			hasExpire = true
-			ttl, err := decoder.ReadTTL()
-			if err != nil {
-				return fmt.Errorf("rdb read key expire time error: %v", err)
-			}
-			expireTime = ttl
-			b, err = decoder.ReadByte()
-			if err != nil {
-				return fmt.Errorf("rdb read key value type error: %v", err)
-			}
+		return k, database.NewEntry(k, value), nil
+	case codec.SetType:
+		k, s, err := decoder.ReadSetObject()
+		if err != nil {
+			return "", nil, fmt.Errorf("rdb read set object error: %v", err)
 		}
-		var key string
-		var entry *database.Entry
-		switch b {
-		case codec.StringType:
-			k, value, err := decoder.ReadStringObject()
-			if err != nil {
-				return fmt.Errorf("rdb read string object error: %v", err)
-			}
-			entry = &database.Entry{Data: value}
-			key = k
-		case codec.SetType:
-			k, s, err := decoder.ReadSetObject()
-			if err != nil {
-				return fmt.Errorf("rdb read set object error: %v", err)
-			}
-			entry = &database.Entry{Data: s}
-			key = k
-		case codec.HashType:
-			k, h, err := decoder.ReadHash()
-			if err != nil {
-				return fmt.Errorf("rdb read hash object error: %v", err)
-			}
-			entry = &database.Entry{Data: h}
-			key = k
-		case codec.ListType:
-			k, l, err := decoder.ReadListObject()
-			if err != nil {
-				return fmt.Errorf("rdb read list object error: %v", err)
-			}
-			entry = &database.Entry{Data: l}
-			key = k
-		case codec.SortedSetType:
-			k, zs, err := decoder.ReadZSetObject()
-			if err != nil {
-				return fmt.Errorf("rdb read zset object error: %v", err)
-			}
-			entry = &database.Entry{Data: zs}
-			key = k
-		default:
-			break
+		return k, database.NewEntry(k, s), nil
+	case codec.HashType:
+		k, h, err := decoder.ReadHash()
+		if err != nil {
+			return "", nil, fmt.Errorf("rdb read hash object error: %v", err)
 		}
-		singleDB.data.Put(key, entry)
-		// set key's expire time
-		if hasExpire {
-			expireAt := time.UnixMilli(int64(expireTime))
-			if expireAt.Before(time.Now()) {
-				// remove already expired key
-				singleDB.data.Remove(key)
-			}
-			singleDB.ExpireAt(key, &expireAt)
+		return k, database.NewEntry(k, h), nil
+	case codec.ListType:
+		k, l, err := decoder.ReadListObject()
+		if err != nil {
+			return "", nil, fmt.Errorf("rdb read list object error: %v", err)
 		}
+		return k, database.NewEntry(k, l), nil
+	case codec.SortedSetType:
+		k, zs, err := decoder.ReadZSetObject()
+		if err != nil {
+			return "", nil, fmt.Errorf("rdb read zset object error: %v", err)
+		}
+		return k, database.NewEntry(k, zs), nil
+	default:
+		return "", nil, fmt.Errorf("rdb unknown value type: %d", b)
 	}
-	return nil
 }
 
-func checkHeader(decoder *codec.Decoder) (bool, bool, error) {
-	header := make([]byte, 9)
-	err := decoder.Read(header)
-	if err != nil {
-		return false, false, err
+// bgsaveRetryDelay bgsave失败后，至少等待该时间才会因为满足save条件再次自动bgsave，单位秒
+const bgsaveRetryDelay = 5
+
+// saveParam 自动bgsave的条件：距离上次保存超过seconds秒，并且至少有changes次修改
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveParams 解析 save 配置，格式为 "seconds changes [seconds changes ...]"，空字符串表示关闭自动保存
+func parseSaveParams(s string) ([]saveParam, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters: %s", s)
 	}
-	magicNum := header[0:5]
-	version := header[5:]
-	for i, b := range magicNum {
-		if b != codec.MagicNum[i] {
-			return false, false, nil
+	params := make([]saveParam, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save parameters: %s", s)
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters: %s", s)
 		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	return params, nil
+}
+
+/*
+savingState RDB持久化的状态。
+dirty 在写命令传播时增加，SAVE成功后清零；bgsave开始时记录当时的dirty，成功后只减去这部分，
+bgsave期间的修改留到下一次保存。bgsave在单独的goroutine中执行，结束时由这个goroutine更新状态，所以字段都是原子的
+*/
+type savingState struct {
+	params          []saveParam
+	dirty           atomic.Int64 // dirty 上次成功保存之后修改数据的次数
+	dirtyBeforeSave atomic.Int64 // dirtyBeforeSave 当前bgsave开始时的dirty
+	lastSave        atomic.Int64 // lastSave 上次成功保存的unix时间戳
+	lastBgsaveTry   atomic.Int64 // lastBgsaveTry 上次开始bgsave的unix时间戳
+	lastBgsaveOK    atomic.Bool  // lastBgsaveOK 上次bgsave是否成功
+	lastBgsaveTime  atomic.Int64 // lastBgsaveTime 上次bgsave的耗时，单位秒，-1表示还没有执行过bgsave
+	bgsaveStart     atomic.Int64 // bgsaveStart 正在进行的bgsave的开始时间，unix毫秒，0表示没有正在进行的bgsave
+	scheduled       atomic.Bool  // scheduled BGSAVE SCHEDULE 等待其他后台任务结束后执行bgsave
+}
+
+func newSavingState(params []saveParam) *savingState {
+	s := &savingState{params: params}
+	s.lastSave.Store(time.Now().Unix())
+	s.lastBgsaveOK.Store(true)
+	s.lastBgsaveTime.Store(-1)
+	return s
+}
+
+// inProgress 是否有正在进行的bgsave
+func (s *savingState) inProgress() bool {
+	return s.bgsaveStart.Load() != 0
+}
+
+// saved SAVE 成功
+func (s *savingState) saved() {
+	s.dirty.Store(0)
+	s.lastSave.Store(time.Now().Unix())
+	s.lastBgsaveOK.Store(true)
+}
+
+// bgsaveStarted 记录bgsave开始时的状态
+func (s *savingState) bgsaveStarted() {
+	now := time.Now()
+	s.dirtyBeforeSave.Store(s.dirty.Load())
+	s.lastBgsaveTry.Store(now.Unix())
+	s.bgsaveStart.Store(now.UnixMilli())
+}
+
+// bgsaveFinished bgsave结束，成功时减去bgsave开始前的修改次数并更新上次保存的时间
+func (s *savingState) bgsaveFinished(ok bool) {
+	now := time.Now()
+	if start := s.bgsaveStart.Swap(0); start != 0 {
+		s.lastBgsaveTime.Store((now.UnixMilli() - start) / 1000)
+	}
+	s.lastBgsaveOK.Store(ok)
+	if ok {
+		s.dirty.Add(-s.dirtyBeforeSave.Load())
+		s.lastSave.Store(now.Unix())
 	}
-	for i, b := range version {
-		if b != codec.Version[i] {
-			return true, false, nil
+}
+
+// matchSaveParam 返回满足的自动保存条件。上次bgsave失败时至少等待 bgsaveRetryDelay 秒再重试
+func (s *savingState) matchSaveParam(now int64) (saveParam, bool) {
+	dirty := s.dirty.Load()
+	for _, param := range s.params {
+		if dirty >= param.changes && now-s.lastSave.Load() > param.seconds &&
+			(s.lastBgsaveOK.Load() || now-s.lastBgsaveTry.Load() > bgsaveRetryDelay) {
+			return param, true
 		}
 	}
-	return true, true, nil
+	return saveParam{}, false
+}
+
+// writeRejected 开启 stopWritesOnBgsaveError 并配置了自动保存时，上次bgsave失败后拒绝写命令
+func (s *savingState) writeRejected() bool {
+	return config.Properties.StopWritesOnBgsaveError && len(s.params) > 0 && !s.lastBgsaveOK.Load()
 }
 
+// scheduleSaving 每秒检查一次是否需要自动bgsave
 func scheduleSaving(db *MultiDB) {
-	timewheel.ScheduleDelayed(60*time.Second, fmt.Sprintf("save-rdb-%d", time.Now().UnixMilli()), func() {
+	timewheel.ScheduleDelayed(time.Second, fmt.Sprintf("save-rdb-%d", time.Now().UnixMilli()), func() {
 		db.SubmitCommand(redis.NewSingleLineCommand(str.StringToBytes("timed-bgsave")))
 	})
 }
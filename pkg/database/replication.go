@@ -0,0 +1,201 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"redigo/pkg/config"
+	"redigo/pkg/rdb"
+	"redigo/pkg/redis"
+	"redigo/pkg/replication"
+	"redigo/pkg/util/log"
+	"strconv"
+	"strings"
+)
+
+// writeCommands 会修改数据的命令，只读从节点会拒绝客户端发送的这些命令。
+// SingleDB.Execute 执行这些命令时设置 writing，GetEntry 才会在bgsave期间复制value（见 bgsave.go），
+// 原地修改value的命令不在这里时，bgsave保存的是被修改之后的数据。新增这类命令时同时加入 TestBGSaveSnapshot
+var writeCommands = map[string]bool{
+	"set": true, "setnx": true, "mset": true, "append": true, "incr": true, "decr": true, "incrby": true, "decrby": true,
+	"setbit": true, "del": true, "persist": true, "expire": true, "pexpireat": true, "rename": true,
+	"renamenx": true, "move": true, "flushdb": true, "flushall": true,
+	"restore": true, "restore-asking": true, "migrate": true, "undo-log": true,
+	"lpush": true, "lpop": true, "rpush": true, "rpop": true, "rpoplpush": true,
+	"hset": true, "hdel": true, "hsetnx": true, "hincrby": true,
+	"sadd": true, "srem": true, "spop": true, "sdiffstore": true, "sinterstore": true,
+	"zadd": true, "zrem": true, "zpopmin": true, "zpopmax": true,
+	"geoadd": true,
+}
+
+func (m *MultiDB) initReplicationExecutors() {
+	m.executors["replicaof"] = m.execReplicaOf
+	m.executors["slaveof"] = m.execReplicaOf
+	m.executors["psync"] = m.execPSync
+	m.executors["replconf"] = m.execReplConf
+	m.executors["role"] = m.execRole
+	m.executors[replication.LoadRDBCommand] = m.execReplicaLoadRDB
+}
+
+// isReadOnlyRejected 只读从节点拒绝来自普通客户端的写命令，master传播的命令不受影响
+func (m *MultiDB) isReadOnlyRejected(command redis.Command) bool {
+	return m.replica != nil && config.Properties.ReplicaReadOnly && writeCommands[command.Name()] &&
+		!replication.IsMasterConnection(command.Connection())
+}
+
+// startReplication 成为 host:port 的从节点
+func (m *MultiDB) startReplication(host string, port int) {
+	if m.replica != nil {
+		m.replica.Stop()
+	}
+	// 主从身份改变，原来的从节点需要重新同步
+	m.master.Reset()
+	m.replica = replication.NewReplica(host, port, listeningPort(), m)
+	m.replica.Start()
+	log.Info("start replication with master %s:%d", host, port)
+}
+
+func (m *MultiDB) execReplicaOf(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("replicaof"))
+	}
+	host := string(args[0])
+	// REPLICAOF NO ONE，停止复制并提升为master
+	if strings.ToLower(host) == "no" && strings.ToLower(string(args[1])) == "one" {
+		if m.replica != nil {
+			m.replica.Stop()
+			m.replica = nil
+			m.master.Reset()
+			log.Info("replication stopped, promoted to master")
+		}
+		return redis.OKCommand
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return redis.NewErrorCommand(redis.InvalidMasterPortError)
+	}
+	if m.replica != nil && m.replica.MasterHost() == host && m.replica.MasterPort() == port {
+		return redis.NewSingleLineCommand([]byte("OK Already connected to specified master"))
+	}
+	m.startReplication(host, port)
+	return redis.OKCommand
+}
+
+func (m *MultiDB) execPSync(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 2 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("psync"))
+	}
+	conn := command.Connection()
+	replID := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+	}
+	if m.master.TryPartialSync(conn, replID, offset) {
+		log.Info("partial resync accepted, replica: %s, offset: %d", conn.RemoteAddr(), offset)
+		return nil
+	}
+	// 无法部分重同步，生成RDB快照进行全量同步。
+	// 在executor中生成快照，保证快照和之后传播的命令之间没有遗漏
+	buffer := &bytes.Buffer{}
+	if err := rdb.Write(m, buffer); err != nil {
+		log.Errorf("generate rdb for full resync error: %v", err)
+		return redis.NewErrorCommand(err)
+	}
+	m.master.FullSync(conn, buffer.Bytes())
+	log.Info("full resync with replica: %s, rdb size: %d", conn.RemoteAddr(), buffer.Len())
+	return nil
+}
+
+func (m *MultiDB) execReplConf(command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args)%2 != 0 {
+		return redis.NewErrorCommand(redis.SyntaxError)
+	}
+	conn := command.Connection()
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+			}
+			m.master.ReplConf(conn, port)
+		case "ack":
+			// ACK 不需要回复
+			if offset, err := strconv.ParseInt(string(args[i+1]), 10, 64); err == nil {
+				m.master.Ack(conn, offset)
+			}
+			return nil
+		case "capa", "ip-address":
+		default:
+			return redis.NewErrorCommand(redis.SyntaxError)
+		}
+	}
+	return redis.OKCommand
+}
+
+func (m *MultiDB) execRole(_ redis.Command) *redis.RespCommand {
+	if m.replica != nil {
+		return redis.NewNestedArrayCommand([][]byte{
+			redis.Encode(redis.NewBulkStringCommand([]byte("slave"))),
+			redis.Encode(redis.NewBulkStringCommand([]byte(m.replica.MasterHost()))),
+			redis.Encode(redis.NewNumberCommand(m.replica.MasterPort())),
+			redis.Encode(redis.NewBulkStringCommand([]byte(m.replica.State()))),
+			redis.Encode(redis.NewNumberCommand(int(m.replica.Offset()))),
+		})
+	}
+	replicas := m.master.Replicas()
+	parts := make([][]byte, len(replicas))
+	for i, replica := range replicas {
+		parts[i] = redis.Encode(redis.NewStringArrayCommand([]string{
+			replica.IP,
+			strconv.Itoa(replica.ListeningPort),
+			strconv.FormatInt(replica.AckOffset, 10),
+		}))
+	}
+	return redis.NewNestedArrayCommand([][]byte{
+		redis.Encode(redis.NewBulkStringCommand([]byte("master"))),
+		redis.Encode(redis.NewNumberCommand(int(m.master.Offset()))),
+		redis.Encode(redis.NewNestedArrayCommand(parts)),
+	})
+}
+
+// execReplicaLoadRDB 从节点全量同步时加载master发送的RDB，只接受来自master连接的命令
+func (m *MultiDB) execReplicaLoadRDB(command redis.Command) *redis.RespCommand {
+	if !replication.IsMasterConnection(command.Connection()) || len(command.Args()) != 1 {
+		return redis.NewErrorCommand(redis.CreateUnknownCommandError(command.Name()))
+	}
+	for _, db := range m.dbSet {
+		db.(*SingleDB).flushDB(false)
+	}
+	if err := decodeRDB(m, bufio.NewReader(bytes.NewReader(command.Args()[0]))); err != nil {
+		log.Errorf("replica load rdb error: %v", err)
+	}
+	return nil
+}
+
+// listeningPort 从服务器地址中解析出对客户端开放的端口
+func listeningPort() int {
+	_, portStr, err := net.SplitHostPort(config.Properties.Address)
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+// parseReplicaOf 解析配置中 "host port" 格式的master地址
+func parseReplicaOf(replicaOf string) (string, int, bool) {
+	fields := strings.Fields(replicaOf)
+	if len(fields) != 2 {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[0], port, true
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"fmt"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/pattern"
+	"strconv"
+	"strings"
+)
+
+/*
+SCAN、SSCAN、HSCAN、ZSCAN 游标遍历：
+
+游标由 dict.Dict 的 Scan 生成，服务器不保存任何游标状态。使用 incremental 哈希表时游标是Redis的反向二进制游标，
+遍历期间哈希表扩容或缩容，依然会返回整个遍历期间都存在的key，但是同一个key可能被返回多次。
+set、hash和sorted set的成员使用相同的哈希表实现，SSCAN、HSCAN、ZSCAN 同样按桶分批返回。
+使用 simple 类型（Go的map）时没有稳定的遍历顺序，SCAN、SSCAN、HSCAN、ZSCAN 都一次返回所有元素，游标为0。
+
+与Redis相同，COUNT 只是每次遍历的工作量提示：遍历到 COUNT 个元素或者访问 COUNT*10 个桶后返回，
+MATCH 和 TYPE 的过滤在遍历之后进行，所以一次返回的元素可能少于 COUNT，甚至为空。
+*/
+
+const (
+	// scanDefaultCount COUNT 的默认值
+	scanDefaultCount = 10
+	// scanMaxIterationsFactor 每次最多访问 COUNT 的该倍数个桶，避免大量空桶时长时间阻塞
+	scanMaxIterationsFactor = 10
+)
+
+func init() {
+	RegisterCommandExecutor("scan", execScan, -1)
+	RegisterCommandExecutor("sscan", execSScan, -2)
+	RegisterCommandExecutor("hscan", execHScan, -2)
+	RegisterCommandExecutor("zscan", execZScan, -2)
+}
+
+// scanOptions 游标和 MATCH、COUNT、TYPE 参数
+type scanOptions struct {
+	cursor  uint64
+	count   int
+	pattern *pattern.Pattern // pattern 为nil时不过滤
+	typ     string           // typ 只有SCAN支持，为空时不过滤
+}
+
+// parseScanOptions 解析 cursor [MATCH pattern] [COUNT count] [TYPE type]
+func parseScanOptions(args [][]byte, allowType bool) (*scanOptions, error) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, redis.InvalidCursorError
+	}
+	opts := &scanOptions{cursor: cursor, count: scanDefaultCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, redis.SyntaxError
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			// 与Redis相同，"*" 匹配所有元素，不需要进行模式匹配
+			if value != "*" {
+				opts.pattern = pattern.ParsePattern(value)
+			}
+		case "count":
+			if opts.count, err = strconv.Atoi(value); err != nil {
+				return nil, redis.ValueNotIntegerOrOutOfRangeError
+			}
+			if opts.count < 1 {
+				return nil, redis.SyntaxError
+			}
+		case "type":
+			if !allowType {
+				return nil, redis.SyntaxError
+			}
+			opts.typ = strings.ToLower(value)
+			if !isTypeName(opts.typ) {
+				return nil, fmt.Errorf(redis.UnknownTypeNameError, value)
+			}
+		default:
+			return nil, redis.SyntaxError
+		}
+	}
+	return opts, nil
+}
+
+func (opts *scanOptions) matches(s string) bool {
+	return opts.pattern == nil || opts.pattern.Matches(s)
+}
+
+// isTypeName 检查TYPE参数是否是 TYPE 命令可能返回的类型
+func isTypeName(typ string) bool {
+	switch typ {
+	case "string", "list", "set", "zset", "hash":
+		return true
+	default:
+		return false
+	}
+}
+
+// scanLoop 从游标开始反复调用scan，直到遍历到 count 个元素、访问 count*10 次或遍历结束，返回下一次的游标。
+// scan 返回下一次的游标和本次遍历到的元素数量
+func scanLoop(cursor uint64, count int, scan func(cursor uint64) (uint64, int)) uint64 {
+	found := 0
+	for i := 0; i < count*scanMaxIterationsFactor; i++ {
+		var n int
+		cursor, n = scan(cursor)
+		found += n
+		if cursor == 0 || found >= count {
+			break
+		}
+	}
+	return cursor
+}
+
+// scanReply 回复 [cursor, [element ...]]
+func scanReply(cursor uint64, elements [][]byte) *redis.RespCommand {
+	return redis.NewNestedArrayCommand([][]byte{
+		redis.Encode(redis.NewBulkStringCommand([]byte(strconv.FormatUint(cursor, 10)))),
+		redis.Encode(redis.NewArrayCommand(elements)),
+	})
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("scan"))
+	}
+	opts, err := parseScanOptions(args, true)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	keys := make([]string, 0, opts.count)
+	cursor := scanLoop(opts.cursor, opts.count, func(cursor uint64) (uint64, int) {
+		n := len(keys)
+		cursor = db.data.Scan(cursor, func(key string, _ interface{}) {
+			keys = append(keys, key)
+		})
+		return cursor, len(keys) - n
+	})
+	// 遍历过程中不能修改哈希表，遍历结束后再过滤，并删除已经过期的key
+	result := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if !opts.matches(key) || db.expireIfNeeded(key) {
+			continue
+		}
+		if opts.typ != "" {
+			v, _ := db.data.Get(key)
+			if typeOf(*v.(*database.Entry)) != opts.typ {
+				continue
+			}
+		}
+		result = append(result, []byte(key))
+	}
+	return scanReply(cursor, result)
+}
+
+// execSScan SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sscan"))
+	}
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	s, err := getSet(db, string(args[0]))
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	result := make([][]byte, 0)
+	if s == nil {
+		return scanReply(0, result)
+	}
+	cursor := scanLoop(opts.cursor, opts.count, func(cursor uint64) (uint64, int) {
+		n := 0
+		cursor = s.Scan(cursor, func(member string) {
+			n++
+			if opts.matches(member) {
+				result = append(result, []byte(member))
+			}
+		})
+		return cursor, n
+	})
+	return scanReply(cursor, result)
+}
+
+// execHScan HSCAN key cursor [MATCH pattern] [COUNT count]，回复中field和value交替出现
+func execHScan(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("hscan"))
+	}
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	hash, exists, err := getHash(db, string(args[0]))
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	result := make([][]byte, 0)
+	if !exists {
+		return scanReply(0, result)
+	}
+	cursor := scanLoop(opts.cursor, opts.count, func(cursor uint64) (uint64, int) {
+		n := 0
+		cursor = hash.Scan(cursor, func(field string, value interface{}) {
+			n++
+			if opts.matches(field) {
+				result = append(result, []byte(field), value.([]byte))
+			}
+		})
+		return cursor, n
+	})
+	return scanReply(cursor, result)
+}
+
+// execZScan ZSCAN key cursor [MATCH pattern] [COUNT count]，回复中member和score交替出现
+func execZScan(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("zscan"))
+	}
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	zs, err := getSortedSet(db, string(args[0]))
+	if err != nil {
+		return redis.NewErrorCommand(err)
+	}
+	result := make([][]byte, 0)
+	if zs == nil {
+		return scanReply(0, result)
+	}
+	cursor := scanLoop(opts.cursor, opts.count, func(cursor uint64) (uint64, int) {
+		n := 0
+		cursor = zs.Scan(cursor, func(member string, score float64) {
+			n++
+			if opts.matches(member) {
+				result = append(result, []byte(member), []byte(strconv.FormatFloat(score, 'f', -1, 64)))
+			}
+		})
+		return cursor, n
+	})
+	return scanReply(cursor, result)
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanAll 从游标0开始执行扫描命令直到游标为0，返回所有元素和执行次数。args 中游标的位置为 cursorIdx
+func scanAll(t *testing.T, db *MultiDB, cursorIdx int, args ...string) ([]string, int) {
+	var elements []string
+	cursor, rounds := "0", 0
+	for {
+		args[cursorIdx] = cursor
+		reply := execute(db, args...)
+		if reply.Type() == redis.CommandTypeError {
+			t.Fatalf("%v: %s", args, redis.Encode(reply))
+		}
+		parts := reply.Parts()
+		next, err := redis.Decode(bufio.NewReader(bytes.NewReader(parts[0])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		array, err := redis.Decode(bufio.NewReader(bytes.NewReader(parts[1])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, element := range array.Parts() {
+			elements = append(elements, string(element))
+		}
+		rounds++
+		if cursor = string(next.Parts()[0]); cursor == "0" {
+			return elements, rounds
+		}
+		if rounds > 10000 {
+			t.Fatalf("%v: scan not finished", args)
+		}
+	}
+}
+
+// toSet 将元素转换成集合，检查是否有遗漏的元素
+func toSet(elements []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, e := range elements {
+		result[e] = true
+	}
+	return result
+}
+
+func checkElements(t *testing.T, name string, elements []string, expected map[string]bool) {
+	got := toSet(elements)
+	if len(got) != len(expected) {
+		t.Errorf("%s: expect %d elements, got %d", name, len(expected), len(got))
+	}
+	for e := range expected {
+		if !got[e] {
+			t.Errorf("%s: %s not returned", name, e)
+			return
+		}
+	}
+}
+
+// forEachDictType 分别使用两种哈希表实现执行测试
+func forEachDictType(t *testing.T, test func(t *testing.T, incremental bool)) {
+	for _, dictType := range []string{config.DictIncremental, config.DictSimple} {
+		t.Run(dictType, func(t *testing.T) {
+			old := config.Properties.DictType
+			config.Properties.DictType = dictType
+			defer func() {
+				config.Properties.DictType = old
+			}()
+			test(t, dictType == config.DictIncremental)
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	forEachDictType(t, func(t *testing.T, incremental bool) {
+		db := newTestDB()
+		sdb := db.dbSet[0].(*SingleDB)
+		all, strs, lists, matched := make(map[string]bool), make(map[string]bool), make(map[string]bool), make(map[string]bool)
+		for i := 0; i < 300; i++ {
+			key := "k" + strconv.Itoa(i)
+			execute(db, "set", key, "v")
+			all[key], strs[key] = true, true
+			if strings.HasPrefix(key, "k1") {
+				matched[key] = true
+			}
+		}
+		for i := 0; i < 50; i++ {
+			key := "l" + strconv.Itoa(i)
+			execute(db, "rpush", key, "a")
+			all[key], lists[key] = true, true
+		}
+		past := time.Now().Add(-time.Second)
+		for i := 0; i < 20; i++ {
+			key := "e" + strconv.Itoa(i)
+			execute(db, "set", key, "v")
+			sdb.ExpireAt(key, &past)
+		}
+
+		keys, rounds := scanAll(t, db, 1, "scan", "", "COUNT", "20")
+		checkElements(t, "scan", keys, all)
+		if incremental && rounds == 1 || !incremental && rounds != 1 {
+			t.Errorf("incremental: %v, unexpected scan rounds: %d", incremental, rounds)
+		}
+		// 过期的key不返回，并且被删除
+		if sdb.data.Len() != len(all) {
+			t.Errorf("expired keys not deleted, keys: %d", sdb.data.Len())
+		}
+		keys, _ = scanAll(t, db, 1, "scan", "", "MATCH", "k1*", "COUNT", "20")
+		checkElements(t, "scan match", keys, matched)
+		keys, _ = scanAll(t, db, 1, "scan", "", "TYPE", "list")
+		checkElements(t, "scan type list", keys, lists)
+		keys, _ = scanAll(t, db, 1, "scan", "", "MATCH", "k*", "TYPE", "string", "COUNT", "100")
+		checkElements(t, "scan match type", keys, strs)
+	})
+}
+
+func TestScanMembers(t *testing.T) {
+	forEachDictType(t, func(t *testing.T, incremental bool) {
+		db := newTestDB()
+		members, fields, scores := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			member := strconv.Itoa(i)
+			execute(db, "sadd", "set", member)
+			execute(db, "hset", "hash", member, "v"+member)
+			execute(db, "zadd", "zset", member, member)
+			if strings.HasPrefix(member, "1") {
+				members[member] = true
+				fields[member], fields["v"+member] = true, true
+				scores[member] = true
+			}
+		}
+		elements, rounds := scanAll(t, db, 2, "sscan", "set", "", "MATCH", "1*", "COUNT", "10")
+		checkElements(t, "sscan", elements, members)
+		if incremental && rounds == 1 || !incremental && rounds != 1 {
+			t.Errorf("incremental: %v, unexpected sscan rounds: %d", incremental, rounds)
+		}
+		elements, _ = scanAll(t, db, 2, "hscan", "hash", "", "MATCH", "1*", "COUNT", "10")
+		checkElements(t, "hscan", elements, fields)
+		for i := 0; i+1 < len(elements); i += 2 {
+			if elements[i+1] != "v"+elements[i] {
+				t.Fatalf("hscan: field %s with value %s", elements[i], elements[i+1])
+			}
+		}
+		// zset的member和score相同
+		elements, _ = scanAll(t, db, 2, "zscan", "zset", "", "MATCH", "1*", "COUNT", "10")
+		checkElements(t, "zscan", elements, scores)
+		for i := 0; i+1 < len(elements); i += 2 {
+			if elements[i+1] != elements[i] {
+				t.Fatalf("zscan: member %s with score %s", elements[i], elements[i+1])
+			}
+		}
+		// 不存在的key返回空结果
+		if elements, _ = scanAll(t, db, 2, "sscan", "missing", ""); len(elements) != 0 {
+			t.Errorf("sscan missing key: %v", elements)
+		}
+	})
+}
+
+func TestScanErrors(t *testing.T) {
+	db := newTestDB()
+	execute(db, "set", "str", "v")
+	tests := []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"scan", "abc"}, redis.InvalidCursorError},
+		{[]string{"scan", "0", "COUNT", "0"}, redis.SyntaxError},
+		{[]string{"scan", "0", "COUNT", "x"}, redis.ValueNotIntegerOrOutOfRangeError},
+		{[]string{"scan", "0", "MATCH"}, redis.SyntaxError},
+		{[]string{"sscan", "str", "0"}, redis.WrongTypeOperationError},
+		{[]string{"hscan", "str", "0", "TYPE", "hash"}, redis.SyntaxError},
+	}
+	for _, test := range tests {
+		reply := execute(db, test.args...)
+		if string(redis.Encode(reply)) != string(redis.Encode(redis.NewErrorCommand(test.err))) {
+			t.Errorf("%v: expect %v, got %s", test.args, test.err, redis.Encode(reply))
+		}
+	}
+	if reply := execute(db, "scan", "0", "TYPE", "stream"); reply.Type() != redis.CommandTypeError {
+		t.Errorf("expect unknown type error, got %s", redis.Encode(reply))
+	}
+}
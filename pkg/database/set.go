@@ -205,7 +205,7 @@ func execSDiffStore(db *SingleDB, command redis.Command) *redis.RespCommand {
 	for _, value := range diff {
 		dest.Add(value)
 	}
-	db.data.Put(string(args[2]), &database.Entry{Data: dest})
+	db.data.Put(string(args[2]), database.NewEntry(string(args[2]), dest))
 	db.addAof(command.Parts())
 	return redis.NewStringArrayCommand(diff)
 }
@@ -255,7 +255,7 @@ func execSInterStore(db *SingleDB, command redis.Command) *redis.RespCommand {
 	for _, value := range inter {
 		dest.Add(value)
 	}
-	db.data.Put(string(args[0]), &database.Entry{Data: dest})
+	db.data.Put(string(args[0]), database.NewEntry(string(args[0]), dest))
 	db.addAof(command.Parts())
 	return redis.NewStringArrayCommand(inter)
 }
@@ -302,7 +302,7 @@ func getOrCreateSet(db *SingleDB, key string) (*set.Set, error) {
 	entry, exists := db.GetEntry(key)
 	if !exists {
 		s := set.NewSet()
-		entry = &database.Entry{Data: s}
+		entry = database.NewEntry(key, s)
 		db.data.Put(key, entry)
 		return s, nil
 	} else {
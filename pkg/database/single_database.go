@@ -2,14 +2,13 @@ package database
 
 import (
 	"errors"
-	"redigo/pkg/config"
 	"redigo/pkg/datastruct/dict"
 	"redigo/pkg/interface/database"
 	"redigo/pkg/rdb"
 	"redigo/pkg/redis"
 	"redigo/pkg/util/log"
-	"redigo/pkg/util/timewheel"
 	"runtime"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,16 +18,27 @@ type SingleDB struct {
 	idx        int            // idx 该数据库的index
 	addAof     func([][]byte) // addAof 写入AOF的函数，执行命令时通过调用该函数进行AOF持久化
 	versionMap dict.Dict      // versionMap key版本映射，主要用在发布订阅功能上，用来判断key的变化
+
+	snapshot atomic.Pointer[snapshot] // snapshot 正在进行的bgsave的快照，没有bgsave时为nil
+	writing  bool                     // writing 当前执行的是否是写命令，命令结束后重新估算被修改的entry的大小
+	inPlace  bool                     // inPlace 当前写命令是否会原地修改value，修改之前需要保留快照
+
+	used       int64          // used 数据库中所有entry估算占用的内存
+	touched    []touchedEntry // touched 当前写命令获取或添加的entry，命令结束后重新估算大小
+	freeMemory func() error   // freeMemory 内存超过maxMemory时淘汰key
+
+	expiredKeys int64 // expiredKeys 惰性删除和定期删除的过期key数量
 }
 
 func NewSingleDB(idx int) *SingleDB {
 	db := &SingleDB{
-		data:       dict.NewSimpleDict(),
-		ttlMap:     dict.NewSimpleDict(),
+		ttlMap:     dict.New(),
 		idx:        idx,
-		versionMap: dict.NewSimpleDict(),
+		versionMap: dict.New(),
 		addAof:     func(i [][]byte) {},
+		freeMemory: func() error { return nil },
 	}
+	db.data = &memoryDict{Dict: dict.New(), db: db}
 	return db
 }
 
@@ -44,16 +54,22 @@ func (db *SingleDB) Execute(command redis.Command) *redis.RespCommand {
 	cmd := command.Name()
 	if cmd == "keys" {
 		// 获取所有的keys，然后在单独的goroutine进行模式匹配，可以避免keys命令阻塞executor
-		// 大量keys可能导致内存激增
-		keys := db.data.Keys()
+		// 大量keys可能导致内存激增；过期检查需要读取ttlMap，必须在executor中完成
+		keys := db.unexpiredKeys()
 		go func(command redis.Command, keys []string) {
-			reply := execKeys(db, command, keys)
+			reply := execKeys(command, keys)
 			command.Connection().SendCommand(reply)
 		}(command, keys)
 		return nil
 	}
 	if exec, exists := executors[cmd]; exists {
+		db.writing = writeCommands[cmd]
+		db.inPlace = db.writing && !unlinkCommands[cmd]
 		reply := exec.execFunc(db, command)
+		if db.writing {
+			db.updateSizes()
+			db.writing, db.inPlace = false, false
+		}
 		return reply
 	}
 	return redis.NewErrorCommand(redis.CreateUnknownCommandError(cmd))
@@ -83,25 +99,10 @@ func (db *SingleDB) OnConnectionClosed(_ redis.Connection) {
 func (db *SingleDB) Expire(key string, ttl time.Duration) {
 	expireTime := time.Now().Add(ttl)
 	db.ttlMap.Put(key, &expireTime)
-	// if server enabled scheduler for expiring
-	if config.Properties.UseScheduleExpire {
-		// schedule auto remove in time wheel
-		timewheel.ScheduleDelayed(ttl, "expire_"+key, func() {
-			db.SubmitCommand(redis.NewCommand([][]byte{[]byte("del"), []byte(key)}))
-		})
-	}
 }
 
 func (db *SingleDB) ExpireAt(key string, expire *time.Time) {
 	db.ttlMap.Put(key, expire)
-	// if server enabled scheduler for expiring
-	if config.Properties.UseScheduleExpire {
-		ttl := expire.Sub(time.Now())
-		// schedule auto remove in time wheel
-		timewheel.ScheduleDelayed(ttl, "expire_"+key, func() {
-			db.SubmitCommand(redis.NewCommand([][]byte{[]byte("del"), []byte(key)}))
-		})
-	}
 }
 
 func (db *SingleDB) TTL(key string) time.Duration {
@@ -121,9 +122,6 @@ func (db *SingleDB) CancelTTL(key string) int {
 	_, exists := db.ttlMap.Get(key)
 	if exists {
 		db.ttlMap.Remove(key)
-		if config.Properties.UseScheduleExpire {
-			timewheel.Cancel("expire_" + key)
-		}
 		return 1
 	}
 	return 0
@@ -137,27 +135,33 @@ func (db *SingleDB) expireIfNeeded(key string) bool {
 	}
 	expireAt := v.(*time.Time)
 	if expireAt.Before(time.Now()) {
-		// remove key
+		// remove key and its ttl
 		db.data.Remove(key)
-		// remove the scheduler task for key's ttl
-		if config.Properties.UseScheduleExpire {
-			db.CancelTTL(key)
-		}
+		db.ttlMap.Remove(key)
+		db.expiredKeys++
 		// add delete key to aof
 		db.addAof([][]byte{[]byte("del"), []byte(key)})
-		log.Debug("Lazy expire key: %s", key)
+		log.Debug("Expire key: %s", key)
 		return true
 	}
 	return false
 }
 
-// GetEntry 获取一个Key的Entry，获取的同时检查TTL，并进行LRU
+// GetEntry 获取一个Key的Entry，获取的同时检查TTL，并更新访问时间和访问频率
 func (db *SingleDB) GetEntry(key string) (*database.Entry, bool) {
 	v, ok := db.data.Get(key)
 	if !ok || db.expireIfNeeded(key) {
 		return nil, false
 	}
 	entry := v.(*database.Entry)
+	touchEntry(entry)
+	if db.writing {
+		// bgsave期间，写命令修改value前先在快照中保留原来的value
+		if s := db.snapshot.Load(); s != nil && db.inPlace {
+			s.preserve(entry)
+		}
+		db.touched = append(db.touched, touchedEntry{key: key, entry: entry})
+	}
 	return entry, true
 }
 
@@ -228,6 +232,21 @@ func (db *SingleDB) Close() {
 
 }
 
+// unexpiredKeys 获取所有没有过期的key
+func (db *SingleDB) unexpiredKeys() []string {
+	keys := db.data.Keys()
+	now := time.Now()
+	i := 0
+	for _, key := range keys {
+		if v, ok := db.ttlMap.Get(key); ok && v.(*time.Time).Before(now) {
+			continue
+		}
+		keys[i] = key
+		i++
+	}
+	return keys[:i]
+}
+
 // randomKeys 获取 samples 个数的随机keys
 func (db *SingleDB) randomKeys(samples int) []string {
 	keys := db.data.RandomKeysDistinct(samples)
@@ -244,10 +263,10 @@ func (db *SingleDB) Dump(key string) ([]byte, error) {
 
 // putEntry 添加新的Entry，添加前进行内存淘汰
 func (db *SingleDB) putEntry(entry *database.Entry) int {
-	result := db.data.Put(entry.Key, entry)
-	if result != 0 {
-	}
-	return result
+	// 写命令执行前已经检查过内存，MSET等命令一次添加多个key时依然可能超过maxMemory，这里淘汰其他的key。
+	// 命令已经开始执行，与Redis相同，没有可以淘汰的key时不中断命令，下一条会增加内存的写命令返回 -OOM
+	_ = db.freeMemory()
+	return db.data.Put(entry.Key, entry)
 }
 
 // putOrUpdateEntry 添加新的Entry或者更新entry的值
@@ -274,19 +293,16 @@ func (db *SingleDB) putIfExists(key string, value []byte) int {
 	if v, ok := db.data.Get(key); !ok {
 		return 0
 	} else if entry, ok := v.(*database.Entry); ok {
-		entry.Data = value
+		db.updateEntry(entry, value)
+		return 1
 	}
 	return 0
 }
 
-// updateEntry 更新entry中的值，该方法只能由于字符串类型的value
+// updateEntry 更新entry中的值，该方法只能由于字符串类型的value。entry没有经过 GetEntry 获取，需要记录下来重新估算大小
 func (db *SingleDB) updateEntry(entry *database.Entry, value []byte) {
 	entry.Data = value
-}
-
-func (db *SingleDB) onKeyEvict(key string, _ interface{}) {
-	db.data.Remove(key)
-	db.ttlMap.Remove(key)
-	db.versionMap.Remove(key)
-	log.Debug("key: %s evicted", key)
+	if db.writing {
+		db.touched = append(db.touched, touchedEntry{key: entry.Key, entry: entry})
+	}
 }
@@ -361,7 +361,7 @@ func getOrInitSortedSet(db *SingleDB, key string) (*zset.SortedSet, error) {
 	entry, exists := db.GetEntry(key)
 	if !exists {
 		zs := zset.NewSortedSet()
-		db.data.Put(key, &database.Entry{Data: zs})
+		db.data.Put(key, database.NewEntry(key, zs))
 		return zs, nil
 	} else {
 		if isSortedSet(*entry) {
@@ -21,6 +21,8 @@ const (
 func init() {
 	RegisterCommandExecutor("set", executeSet, -2)
 	RegisterCommandExecutor("get", executeGet, 1)
+	RegisterCommandExecutor("mget", executeMGet, -1)
+	RegisterCommandExecutor("mset", executeMSet, -2)
 	RegisterCommandExecutor("setnx", executeSetNX, 2)
 	RegisterCommandExecutor("append", executeAppend, 2)
 	RegisterCommandExecutor("incr", executeIncr, 1)
@@ -51,11 +53,12 @@ func executeSet(db *SingleDB, command redis.Command) *redis.RespCommand {
 			policy = insertPolicy
 		case arg == "XX":
 			policy = updatePolicy
-		case arg == "PX" || arg == "PX":
+		case arg == "EX" || arg == "PX":
 			if expireTime != infiniteExpireTime || i == len(args)-1 {
 				return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("set "))
 			}
-			if expireTime, err := strconv.Atoi(string(args[i+1])); err != nil {
+			var err error
+			if expireTime, err = strconv.Atoi(string(args[i+1])); err != nil {
 				log.Println("Error arg: ", arg)
 				return redis.NewErrorCommand(redis.HashValueNotIntegerError)
 			} else {
@@ -80,8 +83,11 @@ func executeSet(db *SingleDB, command redis.Command) *redis.RespCommand {
 		result = db.putIfExists(key, value)
 	}
 
+	if result == 0 {
+		return redis.NilCommand
+	}
 	db.addAof(command.Parts())
-	// set ttl
+	// set ttl，NX或XX没有写入时不能修改原来的ttl
 	if expireTime != infiniteExpireTime {
 		db.Expire(key, delay)
 		db.addAof(buildExpireCommand(key, delay))
@@ -93,12 +99,8 @@ func executeSet(db *SingleDB, command redis.Command) *redis.RespCommand {
 			db.addAof([][]byte{str.StringToBytes("persist"), args[0]})
 		}
 	}
-	if result == 0 {
-		return redis.NilCommand
-	} else {
-		db.addVersion(key)
-		return redis.OKCommand
-	}
+	db.addVersion(key)
+	return redis.OKCommand
 }
 
 func executeGet(db *SingleDB, command redis.Command) *redis.RespCommand {
@@ -116,6 +118,40 @@ func executeGet(db *SingleDB, command redis.Command) *redis.RespCommand {
 	return redis.NilCommand
 }
 
+// executeMGet MGET key [key ...]，不存在或者不是字符串的key返回nil
+func executeMGet(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("mget"))
+	}
+	values := make([][]byte, len(args))
+	for i, key := range args {
+		value, exists, err := getString(db, str.BytesToString(key))
+		if err == nil && exists {
+			values[i] = value
+		}
+	}
+	return redis.NewArrayCommand(values)
+}
+
+// executeMSet MSET key value [key value ...]
+func executeMSet(db *SingleDB, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if !ValidateArgCount(command.Name(), len(args)) || len(args)%2 != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("mset"))
+	}
+	db.addAof(command.Parts())
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.putOrUpdateEntry(database.NewEntry(key, args[i+1]))
+		if db.CancelTTL(key) == 1 {
+			db.addAof([][]byte{[]byte("persist"), args[i]})
+		}
+		db.addVersion(key)
+	}
+	return redis.OKCommand
+}
+
 func executeSetNX(db *SingleDB, command redis.Command) *redis.RespCommand {
 	args := command.Args()
 	if !ValidateArgCount(command.Name(), len(args)) {
@@ -123,7 +159,7 @@ func executeSetNX(db *SingleDB, command redis.Command) *redis.RespCommand {
 	}
 	key := string(args[0])
 	value := args[1]
-	entry := &database.Entry{Data: value}
+	entry := database.NewEntry(key, value)
 	result := db.putIfAbsent(entry)
 	if result != 0 {
 		// add command to AOF
@@ -163,7 +199,7 @@ func executeAppend(db *SingleDB, command redis.Command) *redis.RespCommand {
 		db.addAof(command.Parts())
 	} else {
 		// key doesn't exist.
-		entry := &database.Entry{Data: appendValue}
+		entry := database.NewEntry(key, appendValue)
 		_ = db.putEntry(entry)
 		length = len(appendValue)
 		db.addAof([][]byte{[]byte("SET"), args[0], args[1]})
@@ -238,7 +274,7 @@ func add(db *SingleDB, key string, delta int) *redis.RespCommand {
 			return redis.NewNumberCommand(val)
 		}
 	} else {
-		entry := &database.Entry{Data: []byte(strconv.Itoa(delta))}
+		entry := database.NewEntry(key, []byte(strconv.Itoa(delta)))
 		db.data.Put(key, entry)
 		return redis.NewNumberCommand(delta)
 	}
@@ -279,7 +315,7 @@ func execSetBit(db *SingleDB, command redis.Command) *redis.RespCommand {
 	if !exists {
 		// create a new bitmap if not exist
 		bm = bitmap.New()
-		entry := &database.Entry{Data: bm}
+		entry := database.NewEntry(key, bm)
 		db.data.Put(string(args[0]), entry)
 	}
 	db.addVersion(key)
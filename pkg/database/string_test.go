@@ -0,0 +1,45 @@
+package database
+
+import (
+	"redigo/pkg/redis"
+	"testing"
+	"time"
+)
+
+// TestSetNXXXWithExpire NX或XX没有写入时，SET不能修改或取消原来的ttl
+func TestSetNXXXWithExpire(t *testing.T) {
+	db := newTestDB()
+	sdb := db.dbSet[0].(*SingleDB)
+	tests := []struct {
+		args  []string
+		reply *redis.RespCommand
+		key   string
+		value string
+		ttl   time.Duration // ttl 命令执行后key的ttl上限，-1表示没有ttl
+	}{
+		{[]string{"set", "k", "v1", "EX", "100"}, redis.OKCommand, "k", "v1", 100 * time.Second},
+		{[]string{"set", "k", "v2", "NX", "EX", "10"}, redis.NilCommand, "k", "v1", 100 * time.Second},
+		{[]string{"set", "k", "v2", "NX"}, redis.NilCommand, "k", "v1", 100 * time.Second},
+		{[]string{"set", "k", "v3", "XX", "PX", "5000"}, redis.OKCommand, "k", "v3", 5 * time.Second},
+		{[]string{"set", "k", "v4", "XX"}, redis.OKCommand, "k", "v4", -1},
+		{[]string{"set", "missing", "v", "XX", "EX", "10"}, redis.NilCommand, "missing", "", -1},
+		{[]string{"set", "n", "v", "NX", "PX", "5000"}, redis.OKCommand, "n", "v", 5 * time.Second},
+	}
+	for _, test := range tests {
+		if reply := execute(db, test.args...); reply != test.reply {
+			t.Fatalf("%v: expect %s, got %s", test.args, redis.Encode(test.reply), redis.Encode(reply))
+		}
+		value, _, _ := getString(sdb, test.key)
+		if string(value) != test.value {
+			t.Errorf("%v: expect value %q, got %q", test.args, test.value, value)
+		}
+		ttl := sdb.TTL(test.key)
+		if test.ttl == -1 && ttl != -1 {
+			t.Errorf("%v: expect no ttl, got %v", test.args, ttl)
+		}
+		// ttl 只比设置的值小一点，说明被设置为了新的值或者保留了原来的值
+		if test.ttl != -1 && (ttl > test.ttl || ttl < test.ttl-time.Second) {
+			t.Errorf("%v: expect ttl %v, got %v", test.args, test.ttl, ttl)
+		}
+	}
+}
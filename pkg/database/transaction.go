@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-var forbiddenCmds = map[string]bool{"flushdb": true, "watch": true, "unwatch": true}
+var forbiddenCmds = map[string]bool{"flushdb": true, "flushall": true, "watch": true, "unwatch": true}
 
 func Watch(db *SingleDB, conn redis.Connection, keys []string) *redis.RespCommand {
 	for _, key := range keys {
@@ -1,5 +1,7 @@
 package dict
 
+import "redigo/pkg/config"
+
 //Consumer 用于foreach遍历，返回false表示结束遍历
 type Consumer func(key string, value interface{}) bool
 
@@ -15,4 +17,14 @@ type Dict interface {
 	Len() int
 	RandomKeys(int) []string
 	RandomKeysDistinct(int) []string
+	// Scan 从cursor开始遍历一部分key，返回下一次遍历的cursor，返回0表示遍历结束
+	Scan(cursor uint64, fun func(key string, value interface{})) uint64
+}
+
+// New 按照 dictType 配置创建哈希表，数据库的key以及set、hash、sorted set的成员都使用该实现
+func New() Dict {
+	if config.Properties.DictType == config.DictSimple {
+		return NewSimpleDict()
+	}
+	return NewIncrementalDict()
 }
@@ -0,0 +1,377 @@
+package dict
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+/*
+IncrementalDict 与Redis的dict相同的两张哈希表实现。
+
+扩容或缩容时不会一次性迁移所有key，而是创建新的哈希表，之后每次写操作（Put、Remove等）迁移一个桶，
+迁移期间新key写入新表，查询同时查找两张表，迁移完成后新表替换旧表。这样百万级别的数据库扩容时不会出现长时间的停顿。
+
+随机获取key时随机选择一个非空的桶，元素数量不少于桶数量的1/8，期望的查找次数是常数。
+Scan 使用Redis的反向二进制游标，哈希表在两次 Scan 之间扩容或缩容时，依然能返回整个遍历期间都存在的所有key。
+*/
+
+const (
+	// initTableSize 哈希表初始的桶数量
+	initTableSize = 4
+	// minFillPercent 元素数量少于桶数量的该百分比时缩容
+	minFillPercent = 12
+	// rehashEmptyVisits 每次迁移最多跳过的空桶数量，避免单次写操作耗时过长
+	rehashEmptyVisits = 10
+)
+
+type dictEntry struct {
+	key   string
+	value interface{}
+	next  *dictEntry
+}
+
+type hashTable struct {
+	buckets []*dictEntry
+	mask    uint64
+	used    int
+}
+
+func newHashTable(size int) hashTable {
+	return hashTable{buckets: make([]*dictEntry, size), mask: uint64(size - 1)}
+}
+
+func (t *hashTable) size() int {
+	return len(t.buckets)
+}
+
+type IncrementalDict struct {
+	tables    [2]hashTable
+	rehashIdx int // rehashIdx 下一个要迁移的旧表的桶，-1 表示没有在迁移
+	seed      maphash.Seed
+}
+
+func NewIncrementalDict() *IncrementalDict {
+	return &IncrementalDict{
+		tables:    [2]hashTable{newHashTable(initTableSize)},
+		rehashIdx: -1,
+		seed:      maphash.MakeSeed(),
+	}
+}
+
+func (d *IncrementalDict) hash(key string) uint64 {
+	return maphash.String(d.seed, key)
+}
+
+func (d *IncrementalDict) isRehashing() bool {
+	return d.rehashIdx != -1
+}
+
+// find 在两张表中查找key
+func (d *IncrementalDict) find(key string) *dictEntry {
+	h := d.hash(key)
+	for i := 0; i < 2; i++ {
+		t := &d.tables[i]
+		if t.size() == 0 {
+			break
+		}
+		for e := t.buckets[h&t.mask]; e != nil; e = e.next {
+			if e.key == key {
+				return e
+			}
+		}
+		if !d.isRehashing() {
+			break
+		}
+	}
+	return nil
+}
+
+// insert 添加一个不存在的key，迁移期间写入新表
+func (d *IncrementalDict) insert(key string, value interface{}) {
+	t := &d.tables[0]
+	if d.isRehashing() {
+		t = &d.tables[1]
+	}
+	idx := d.hash(key) & t.mask
+	t.buckets[idx] = &dictEntry{key: key, value: value, next: t.buckets[idx]}
+	t.used++
+}
+
+// beforeWrite 写操作之前迁移一个桶，并检查是否需要扩容
+func (d *IncrementalDict) beforeWrite() {
+	if d.isRehashing() {
+		d.rehash(1)
+	} else if d.tables[0].used >= d.tables[0].size() {
+		d.resize(d.tables[0].used * 2)
+	}
+}
+
+// resize 创建容量为不小于 size 的2的幂的新表，开始迁移
+func (d *IncrementalDict) resize(size int) {
+	if size < initTableSize {
+		size = initTableSize
+	}
+	size = 1 << bits.Len(uint(size-1))
+	if size == d.tables[0].size() {
+		return
+	}
+	d.tables[1] = newHashTable(size)
+	d.rehashIdx = 0
+}
+
+// rehash 迁移 n 个非空的桶，迁移完成时返回false
+func (d *IncrementalDict) rehash(n int) bool {
+	emptyVisits := n * rehashEmptyVisits
+	old, table := &d.tables[0], &d.tables[1]
+	for ; n > 0 && old.used > 0; n-- {
+		for old.buckets[d.rehashIdx] == nil {
+			d.rehashIdx++
+			emptyVisits--
+			if emptyVisits == 0 {
+				return true
+			}
+		}
+		for e := old.buckets[d.rehashIdx]; e != nil; {
+			next := e.next
+			idx := d.hash(e.key) & table.mask
+			e.next = table.buckets[idx]
+			table.buckets[idx] = e
+			old.used--
+			table.used++
+			e = next
+		}
+		old.buckets[d.rehashIdx] = nil
+		d.rehashIdx++
+	}
+	if old.used == 0 {
+		d.tables[0] = d.tables[1]
+		d.tables[1] = hashTable{}
+		d.rehashIdx = -1
+		return false
+	}
+	return true
+}
+
+func (d *IncrementalDict) Put(key string, value interface{}) int {
+	d.beforeWrite()
+	if e := d.find(key); e != nil {
+		e.value = value
+	} else {
+		d.insert(key, value)
+	}
+	return 1
+}
+
+func (d *IncrementalDict) Get(key string) (interface{}, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+	return nil, false
+}
+
+func (d *IncrementalDict) PutIfAbsent(key string, value interface{}) int {
+	d.beforeWrite()
+	if d.find(key) != nil {
+		return 0
+	}
+	d.insert(key, value)
+	return 1
+}
+
+func (d *IncrementalDict) PutIfExists(key string, value interface{}) int {
+	d.beforeWrite()
+	if e := d.find(key); e != nil {
+		e.value = value
+		return 1
+	}
+	return 0
+}
+
+// ForEach 遍历所有key，遍历过程中不能修改dict
+func (d *IncrementalDict) ForEach(consumer Consumer) {
+	for i := 0; i < 2; i++ {
+		for _, e := range d.tables[i].buckets {
+			for e != nil {
+				next := e.next
+				if !consumer(e.key, e.value) {
+					return
+				}
+				e = next
+			}
+		}
+	}
+}
+
+func (d *IncrementalDict) Remove(key string) int {
+	if d.isRehashing() {
+		d.rehash(1)
+	}
+	h := d.hash(key)
+	for i := 0; i < 2; i++ {
+		t := &d.tables[i]
+		if t.size() == 0 {
+			break
+		}
+		idx := h & t.mask
+		var prev *dictEntry
+		for e := t.buckets[idx]; e != nil; prev, e = e, e.next {
+			if e.key != key {
+				continue
+			}
+			if prev == nil {
+				t.buckets[idx] = e.next
+			} else {
+				prev.next = e.next
+			}
+			t.used--
+			d.shrinkIfNeeded()
+			return 1
+		}
+		if !d.isRehashing() {
+			break
+		}
+	}
+	return 0
+}
+
+// shrinkIfNeeded 元素数量少于桶数量的 minFillPercent% 时缩容
+func (d *IncrementalDict) shrinkIfNeeded() {
+	t := &d.tables[0]
+	if !d.isRehashing() && t.size() > initTableSize && t.used*100 < t.size()*minFillPercent {
+		d.resize(t.used)
+	}
+}
+
+func (d *IncrementalDict) Keys() []string {
+	keys := make([]string, 0, d.Len())
+	d.ForEach(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (d *IncrementalDict) Clear() {
+	*d = *NewIncrementalDict()
+}
+
+func (d *IncrementalDict) Len() int {
+	return d.tables[0].used + d.tables[1].used
+}
+
+// randomEntry 随机选择一个非空的桶，再从桶的链表中随机选择一个元素
+func (d *IncrementalDict) randomEntry() *dictEntry {
+	if d.Len() == 0 {
+		return nil
+	}
+	var bucket *dictEntry
+	if d.isRehashing() {
+		// 旧表中 rehashIdx 之前的桶已经迁移，都是空的
+		size0 := d.tables[0].size()
+		total := size0 + d.tables[1].size()
+		for bucket == nil {
+			idx := d.rehashIdx + rand.Intn(total-d.rehashIdx)
+			if idx < size0 {
+				bucket = d.tables[0].buckets[idx]
+			} else {
+				bucket = d.tables[1].buckets[idx-size0]
+			}
+		}
+	} else {
+		t := &d.tables[0]
+		for bucket == nil {
+			bucket = t.buckets[rand.Intn(t.size())]
+		}
+	}
+	length := 0
+	for e := bucket; e != nil; e = e.next {
+		length++
+	}
+	for n := rand.Intn(length); n > 0; n-- {
+		bucket = bucket.next
+	}
+	return bucket
+}
+
+func (d *IncrementalDict) RandomKeys(count int) []string {
+	if d.Len() == 0 {
+		return []string{}
+	}
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = d.randomEntry().key
+	}
+	return keys
+}
+
+// RandomKeysDistinct 与Redis的 dictGetSomeKeys 相同，从随机的桶开始连续获取key，不保证均匀分布
+func (d *IncrementalDict) RandomKeysDistinct(count int) []string {
+	if count > d.Len() {
+		count = d.Len()
+	}
+	result := make([]string, 0, count)
+	if count == 0 {
+		return result
+	}
+	maxSize := d.tables[0].size()
+	if d.tables[1].size() > maxSize {
+		maxSize = d.tables[1].size()
+	}
+	start := rand.Intn(maxSize)
+	for i := 0; i < maxSize && len(result) < count; i++ {
+		idx := (start + i) % maxSize
+		for j := 0; j < 2; j++ {
+			t := &d.tables[j]
+			// 旧表中 rehashIdx 之前的桶已经迁移
+			if idx >= t.size() || (j == 0 && d.isRehashing() && idx < d.rehashIdx) {
+				continue
+			}
+			for e := t.buckets[idx]; e != nil && len(result) < count; e = e.next {
+				result = append(result, e.key)
+			}
+		}
+	}
+	return result
+}
+
+// Scan 遍历游标对应的桶并返回下一个游标，返回0表示遍历结束。
+// 游标按照反向二进制递增，扩容后旧桶对应的新桶都排在游标之后，缩容后新桶包含了所有还没有遍历的旧桶，
+// 所以遍历期间一直存在的key都会被返回，但是缩容时同一个key可能被返回多次
+func (d *IncrementalDict) Scan(cursor uint64, fun func(key string, value interface{})) uint64 {
+	if d.Len() == 0 {
+		return 0
+	}
+	visit := func(t *hashTable, idx uint64) {
+		for e := t.buckets[idx]; e != nil; e = e.next {
+			fun(e.key, e.value)
+		}
+	}
+	if !d.isRehashing() {
+		t := &d.tables[0]
+		visit(t, cursor&t.mask)
+		return nextCursor(cursor, t.mask)
+	}
+	small, large := &d.tables[0], &d.tables[1]
+	if small.size() > large.size() {
+		small, large = large, small
+	}
+	visit(small, cursor&small.mask)
+	// 遍历大表中与小表的桶对应的所有桶
+	for {
+		visit(large, cursor&large.mask)
+		cursor = nextCursor(cursor, large.mask)
+		if cursor&(small.mask^large.mask) == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+// nextCursor 将游标的高位按照反向二进制加1
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
@@ -0,0 +1,104 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIncrementalDict_PutRemove(t *testing.T) {
+	d := NewIncrementalDict()
+	for i := 0; i < 10000; i++ {
+		if d.PutIfAbsent(strconv.Itoa(i), i) != 1 {
+			t.FailNow()
+		}
+	}
+	if d.Len() != 10000 || d.PutIfAbsent("1", 1) != 0 || d.PutIfExists("-1", 1) != 0 {
+		t.FailNow()
+	}
+	for i := 0; i < 10000; i++ {
+		if v, ok := d.Get(strconv.Itoa(i)); !ok || v.(int) != i {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+	for i := 0; i < 9990; i++ {
+		if d.Remove(strconv.Itoa(i)) != 1 {
+			t.Fatalf("remove key %d failed", i)
+		}
+	}
+	if d.Len() != 10 || d.Remove("0") != 0 || len(d.Keys()) != 10 {
+		t.FailNow()
+	}
+	for i := 9990; i < 10000; i++ {
+		if _, ok := d.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+	// 缩容之后的哈希表
+	for i := 0; i < 100; i++ {
+		d.Remove(strconv.Itoa(9990 + i%10))
+	}
+	if d.Len() != 0 || d.tables[0].size()+d.tables[1].size() > 64 {
+		t.Fatalf("dict not shrunk, size: %d %d", d.tables[0].size(), d.tables[1].size())
+	}
+}
+
+func TestIncrementalDict_RandomKeys(t *testing.T) {
+	d := NewIncrementalDict()
+	if len(d.RandomKeys(5)) != 0 || len(d.RandomKeysDistinct(5)) != 0 {
+		t.FailNow()
+	}
+	for i := 0; i < 1000; i++ {
+		d.Put(strconv.Itoa(i), i)
+		expect := 20
+		if i+1 < expect {
+			expect = i + 1
+		}
+		if keys := d.RandomKeysDistinct(20); len(keys) != expect {
+			t.Fatalf("expect %d keys, got %d", expect, len(keys))
+		}
+		for _, key := range d.RandomKeys(3) {
+			if _, ok := d.Get(key); !ok {
+				t.Fatalf("random key %s not exists", key)
+			}
+		}
+	}
+	distinct := make(map[string]struct{})
+	for _, key := range d.RandomKeysDistinct(1000) {
+		distinct[key] = struct{}{}
+	}
+	if len(distinct) != 1000 {
+		t.Fatalf("expect 1000 distinct keys, got %d", len(distinct))
+	}
+}
+
+func TestIncrementalDict_Scan(t *testing.T) {
+	d := NewIncrementalDict()
+	for i := 0; i < 1000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	// 遍历期间不断添加和删除key触发扩容和缩容，一直存在的key都要被返回
+	seen := make(map[string]struct{})
+	var cursor uint64
+	for round := 0; ; round++ {
+		cursor = d.Scan(cursor, func(key string, _ interface{}) {
+			seen[key] = struct{}{}
+		})
+		if cursor == 0 {
+			break
+		}
+		if round < 200 {
+			for i := 0; i < 50; i++ {
+				d.Put("tmp"+strconv.Itoa(round*50+i), nil)
+			}
+		} else {
+			for i := 0; i < 50; i++ {
+				d.Remove("tmp" + strconv.Itoa((round-200)*50+i))
+			}
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := seen[strconv.Itoa(i)]; !ok {
+			t.Fatalf("key %d not scanned", i)
+		}
+	}
+}
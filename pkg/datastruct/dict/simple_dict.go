@@ -98,3 +98,11 @@ func (s *SimpleDict) RandomKeysDistinct(count int) (result []string) {
 	}
 	return
 }
+
+// Scan map没有稳定的遍历顺序，一次返回所有key
+func (s *SimpleDict) Scan(_ uint64, fun func(key string, value interface{})) uint64 {
+	for key, value := range s.store {
+		fun(key, value)
+	}
+	return 0
+}
@@ -7,7 +7,7 @@ type Set struct {
 }
 
 func NewSet() *Set {
-	return &Set{data: dict.NewSimpleDict()}
+	return &Set{data: dict.New()}
 }
 
 func (s *Set) Add(value string) int {
@@ -49,6 +49,13 @@ func (s *Set) RandomMembersDistinct(count int) []string {
 	return s.data.RandomKeysDistinct(count)
 }
 
+// Scan 从cursor开始遍历一部分成员，返回下一次遍历的cursor，返回0表示遍历结束
+func (s *Set) Scan(cursor uint64, consumer func(string)) uint64 {
+	return s.data.Scan(cursor, func(value string, _ interface{}) {
+		consumer(value)
+	})
+}
+
 func (s *Set) Diff(other *Set) []string {
 	result := make([]string, 0)
 	s.ForEach(func(val string) bool {
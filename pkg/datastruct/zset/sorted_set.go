@@ -1,34 +1,45 @@
 package zset
 
+import "redigo/pkg/datastruct/dict"
+
 type SortedSet struct {
-	dict map[string]*node
+	dict dict.Dict // dict member到跳表节点的映射，按照 dictType 配置选择哈希表实现
 	skl  *skipList
 }
 
 func NewSortedSet() *SortedSet {
 	return &SortedSet{
-		dict: make(map[string]*node),
+		dict: dict.New(),
 		skl:  newSkipList(),
 	}
 }
 
+// getNode 获取member对应的跳表节点
+func (zs *SortedSet) getNode(member string) (*node, bool) {
+	v, ok := zs.dict.Get(member)
+	if !ok {
+		return nil, false
+	}
+	return v.(*node), true
+}
+
 func (zs *SortedSet) Add(member string, score float64) int {
-	n, ok := zs.dict[member]
+	n, ok := zs.getNode(member)
 	if ok && n.Score != score {
 		zs.skl.Remove(member, score)
 		n = zs.skl.Insert(member, score)
-		zs.dict[member] = n
+		zs.dict.Put(member, n)
 		return 1
 	} else if !ok {
 		n = zs.skl.Insert(member, score)
-		zs.dict[member] = n
+		zs.dict.Put(member, n)
 		return 1
 	}
 	return 0
 }
 
 func (zs *SortedSet) GetScore(member string) (*Element, bool) {
-	n, ok := zs.dict[member]
+	n, ok := zs.getNode(member)
 	if ok {
 		return &n.Element, true
 	}
@@ -36,16 +47,16 @@ func (zs *SortedSet) GetScore(member string) (*Element, bool) {
 }
 
 func (zs *SortedSet) Remove(member string) int {
-	n, ok := zs.dict[member]
+	n, ok := zs.getNode(member)
 	if !ok {
 		return 0
 	}
-	delete(zs.dict, member)
+	zs.dict.Remove(member)
 	return zs.skl.Remove(member, n.Score)
 }
 
 func (zs *SortedSet) Rank(member string) int64 {
-	n, ok := zs.dict[member]
+	n, ok := zs.getNode(member)
 	if !ok {
 		return -1
 	}
@@ -66,7 +77,7 @@ func (zs *SortedSet) PopMin() *Element {
 }
 
 func (zs *SortedSet) Size() int {
-	return len(zs.dict)
+	return zs.dict.Len()
 }
 
 func (zs *SortedSet) Range(start, end int) []Element {
@@ -103,3 +114,10 @@ func (zs *SortedSet) RangeByScore(min, max float64, offset, count int, lOpen, rO
 func (zs *SortedSet) ForEach(fun func(score float64, value string) bool) {
 	zs.skl.forEach(fun)
 }
+
+// Scan 从cursor开始遍历哈希表的一部分桶，返回下一次遍历的cursor，返回0表示遍历结束
+func (zs *SortedSet) Scan(cursor uint64, fun func(member string, score float64)) uint64 {
+	return zs.dict.Scan(cursor, func(member string, v interface{}) {
+		fun(member, v.(*node).Score)
+	})
+}
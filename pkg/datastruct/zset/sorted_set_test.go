@@ -99,6 +99,30 @@ func TestSortedSet_CountBetween(t *testing.T) {
 	}
 }
 
+func TestSortedSet_Scan(t *testing.T) {
+	set := initTest(1000)
+	seen := make(map[string]float64)
+	var cursor uint64
+	rounds := 0
+	for {
+		cursor = set.Scan(cursor, func(member string, score float64) {
+			seen[member] = score
+		})
+		rounds++
+		if cursor == 0 {
+			break
+		}
+	}
+	if rounds == 1 {
+		t.Fatalf("expect scan in multiple rounds")
+	}
+	for i := 1; i <= 1000; i++ {
+		if score, ok := seen[strconv.Itoa(i)]; !ok || int(score) != i {
+			t.Fatalf("member %d not scanned", i)
+		}
+	}
+}
+
 // Benchmark ZADD
 func BenchmarkSortedSet_Add(b *testing.B) {
 	set := NewSortedSet()
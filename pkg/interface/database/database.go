@@ -21,17 +21,25 @@ type DB interface {
 	OnConnectionClosed(conn redis.Connection)
 }
 
+// LFUInitFreq 新建entry的访问频率计数器初始值，与Redis的 LFU_INIT_VAL 相同，避免新key马上被淘汰
+const LFUInitFreq = 5
+
 /*
-Entry key-value数据库entry，包括了key、value属性
+Entry key-value数据库entry，包括了key、value属性，以及 OBJECT IDLETIME 、OBJECT FREQ 和内存淘汰使用的访问信息
 */
 type Entry struct {
-	Key  string
-	Data any
+	Key        string
+	Data       any
+	AccessTime int64 // AccessTime 最近一次访问的unix毫秒时间
+	Freq       uint8 // Freq 对数递增的访问频率计数器
+	Size       int64 // Size key和value估算占用的内存，单位字节，用于maxMemory内存淘汰
 }
 
 func NewEntry(key string, value any) *Entry {
 	return &Entry{
-		Key:  key,
-		Data: value,
+		Key:        key,
+		Data:       value,
+		AccessTime: time.Now().UnixMilli(),
+		Freq:       LFUInitFreq,
 	}
 }
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+)
+
+// jonesTable Redis使用的CRC-64/Jones多项式，反射形式
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// jones 与Redis相同的CRC64：初始值和结果异或值都是0。
+// 标准库的crc64会对初始值和结果取反，与Redis的结果不同，所以这里只复用它的查找表
+type jones struct {
+	crc uint64
+}
+
+func newCRC64() hash.Hash64 {
+	return &jones{}
+}
+
+func (j *jones) Write(p []byte) (int, error) {
+	crc := j.crc
+	for _, b := range p {
+		crc = jonesTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	j.crc = crc
+	return len(p), nil
+}
+
+func (j *jones) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, j.crc)
+}
+
+func (j *jones) Reset() {
+	j.crc = 0
+}
+
+func (j *jones) Size() int {
+	return 8
+}
+
+func (j *jones) BlockSize() int {
+	return 1
+}
+
+func (j *jones) Sum64() uint64 {
+	return j.crc
+}
+
+// CRC64 计算数据的CRC64，与RDB文件结尾的校验和使用相同的算法
+func CRC64(data []byte) uint64 {
+	crc := &jones{}
+	_, _ = crc.Write(data)
+	return crc.crc
+}
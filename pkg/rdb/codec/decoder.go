@@ -2,9 +2,11 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 )
 
@@ -15,29 +17,67 @@ const (
 	ExpireTimeMs = byte(0xfc)
 	ReSizeDB     = byte(0xfb)
 	AUX          = byte(0xfa)
+	Freq         = byte(0xf9)
+	Idle         = byte(0xf8)
+	ModuleAux    = byte(0xf7)
+	Function2    = byte(0xf5)
 
 	StringType    = byte(0x00)
 	ListType      = byte(0x01)
 	SetType       = byte(0x02)
 	SortedSetType = byte(0x03)
 	HashType      = byte(0x04)
+
+	// Redis RDB中的其他编码，读取时转换为上面的五种类型
+	zset2Type          = byte(5)
+	listZiplistType    = byte(10)
+	setIntsetType      = byte(11)
+	zsetZiplistType    = byte(12)
+	hashZiplistType    = byte(13)
+	listQuicklistType  = byte(14)
+	hashListpackType   = byte(16)
+	zsetListpackType   = byte(17)
+	listQuicklist2Type = byte(18)
+	setListpackType    = byte(20)
 )
 
+// ErrChecksumMismatch 文件结尾的校验和与数据不一致
+var ErrChecksumMismatch = errors.New("rdb checksum mismatch")
+
 var (
 	MagicNum = []byte{52, 45, 44, 49, 53}
 	Version  = []byte("0007")
+
+	// RedisMagicNum Redis的RDB文件头，后面是4位数字的版本号
+	RedisMagicNum = []byte("REDIS")
+	// RedisVersion 兼容模式写入的版本号，Redis 5.0 及以上版本都可以加载
+	RedisVersion = []byte("0009")
 )
 
+// maxRedisVersion 能够读取的最高Redis RDB版本，即 Redis 7.2
+const maxRedisVersion = 11
+
+// IsRDB 判断数据是否以redigo或Redis的RDB文件头开头
+func IsRDB(header []byte) bool {
+	return bytes.HasPrefix(header, MagicNum) || bytes.HasPrefix(header, RedisMagicNum)
+}
+
 type Decoder struct {
-	reader *bufio.Reader
+	reader     *bufio.Reader
+	crc        hash.Hash64 // crc 已读取数据的CRC64，用来校验文件结尾的校验和
+	offset     int64       // offset 已读取的数据长度
+	redis      bool        // redis 数据是Redis的RDB格式，由 ReadHeader 根据文件头设置
+	noChecksum bool        // noChecksum 读取但不校验文件结尾的校验和
 }
 
 func NewDecoder(reader *bufio.Reader) *Decoder {
-	return &Decoder{reader: reader}
+	return &Decoder{reader: reader, crc: newCRC64()}
 }
 
 func (dec *Decoder) Read(buf []byte) error {
-	_, err := io.ReadFull(dec.reader, buf)
+	n, err := io.ReadFull(dec.reader, buf)
+	dec.offset += int64(n)
+	_, _ = dec.crc.Write(buf[:n])
 	return err
 }
 
@@ -64,6 +104,14 @@ func (dec *Decoder) readLength() (uint64, bool, error) {
 		// 01****** & 00111111 << 8
 		length = uint64(firstByte&0x3f)<<8 | uint64(secByte)
 	case 2:
+		if firstByte == 0x81 {
+			// 10000001 + uint64
+			buf := make([]byte, 8)
+			if err := dec.Read(buf); err != nil {
+				return 0, false, fmt.Errorf("rdb read uint64 error %v", err)
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
 		// 10 + uint32
 		buf := make([]byte, 4)
 		err := dec.Read(buf)
@@ -79,7 +127,53 @@ func (dec *Decoder) readLength() (uint64, bool, error) {
 }
 
 func (dec *Decoder) ReadByte() (byte, error) {
-	return dec.reader.ReadByte()
+	b, err := dec.reader.ReadByte()
+	if err == nil {
+		dec.offset++
+		_, _ = dec.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// Offset 已读取的数据长度
+func (dec *Decoder) Offset() int64 {
+	return dec.offset
+}
+
+// ReadChecksum 读取EOF标记之后8字节的校验和，返回文件中的校验和与已读取数据的校验和。
+// 文件在EOF标记之后直接结束时返回 io.EOF，这是没有校验和的旧版本文件
+func (dec *Decoder) ReadChecksum() (uint64, uint64, error) {
+	computed := dec.crc.Sum64()
+	buf := make([]byte, 8)
+	n, err := io.ReadFull(dec.reader, buf)
+	dec.offset += int64(n)
+	if err == io.EOF {
+		return 0, computed, io.EOF
+	}
+	if err != nil {
+		return 0, computed, fmt.Errorf("rdb read checksum error: %v", err)
+	}
+	return binary.LittleEndian.Uint64(buf), computed, nil
+}
+
+// DisableChecksum 读取到EOF标记时只读取校验和，不做校验
+func (dec *Decoder) DisableChecksum() {
+	dec.noChecksum = true
+}
+
+// VerifyChecksum 读取并校验EOF标记之后的校验和。校验和为0表示写入时关闭了校验，没有校验和的旧版本文件也不校验
+func (dec *Decoder) VerifyChecksum() error {
+	stored, computed, err := dec.ReadChecksum()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !dec.noChecksum && stored != 0 && stored != computed {
+		return fmt.Errorf("%w: expected %016x, got %016x", ErrChecksumMismatch, stored, computed)
+	}
+	return nil
 }
 
 // ReadTTL TTL time
@@ -89,9 +183,21 @@ func (dec *Decoder) ReadTTL() (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	if dec.redis {
+		return binary.LittleEndian.Uint64(buf), nil
+	}
 	return binary.BigEndian.Uint64(buf), nil
 }
 
+// readExpireTimeSec Redis旧版本写入的秒级过期时间，4字节小端，返回毫秒时间戳
+func (dec *Decoder) readExpireTimeSec() (int64, error) {
+	buf := make([]byte, 4)
+	if err := dec.Read(buf); err != nil {
+		return 0, err
+	}
+	return int64(int32(binary.LittleEndian.Uint32(buf))) * 1000, nil
+}
+
 // ReadDBIndex database index
 func (dec *Decoder) ReadDBIndex() (int, error) {
 	length, special, err := dec.readLength()
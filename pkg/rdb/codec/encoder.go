@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash"
-	"hash/crc64"
 	"io"
 	"redigo/pkg/datastruct/bitmap"
 	"redigo/pkg/datastruct/dict"
@@ -16,11 +15,11 @@ import (
 type Encoder struct {
 	writer io.Writer
 	crc    hash.Hash64
+	redis  bool // redis 按Redis的RDB格式写入
 }
 
 func NewEncoder(writer io.Writer) *Encoder {
-	crcTab := crc64.MakeTable(crc64.ISO)
-	return &Encoder{writer: writer, crc: crc64.New(crcTab)}
+	return &Encoder{writer: writer, crc: newCRC64()}
 }
 
 func (enc *Encoder) Write(data []byte) error {
@@ -39,6 +38,39 @@ func (enc *Encoder) Write(data []byte) error {
 	return nil
 }
 
+// UseRedisFormat 按Redis的RDB格式写入，生成的文件可以被Redis加载
+func (enc *Encoder) UseRedisFormat() {
+	enc.redis = true
+}
+
+// WriteHeader 写入文件头：MagicNum 和 Version，Redis格式下是 REDIS0009
+func (enc *Encoder) WriteHeader() error {
+	if enc.redis {
+		return enc.Write(append(append([]byte{}, RedisMagicNum...), RedisVersion...))
+	}
+	return enc.Write(append(append([]byte{}, MagicNum...), Version...))
+}
+
+// DisableChecksum 不计算校验和，WriteEOF 写入的校验和为0
+func (enc *Encoder) DisableChecksum() {
+	enc.crc = nil
+}
+
+// WriteEOF 写入EOF标记和8字节的CRC64校验和，校验和覆盖从文件头到EOF标记的所有数据
+func (enc *Encoder) WriteEOF() error {
+	if err := enc.Write([]byte{EOF}); err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	if enc.crc != nil {
+		binary.LittleEndian.PutUint64(buf, enc.crc.Sum64())
+	}
+	if _, err := enc.writer.Write(buf); err != nil {
+		return fmt.Errorf("write rdb checksum failed %v", err)
+	}
+	return nil
+}
+
 func (enc *Encoder) writeLength(length uint64) error {
 	var buf []byte
 	if length <= maxUint6 {
@@ -86,7 +118,11 @@ func (enc *Encoder) WriteTTL(expireAt uint64) error {
 	buf := make([]byte, 9)
 	// prefix byte 0xfc
 	buf[0] = ExpireTimeMs
-	binary.BigEndian.PutUint64(buf[1:], expireAt)
+	if enc.redis {
+		binary.LittleEndian.PutUint64(buf[1:], expireAt)
+	} else {
+		binary.BigEndian.PutUint64(buf[1:], expireAt)
+	}
 	return enc.Write(buf)
 }
 
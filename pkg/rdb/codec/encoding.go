@@ -0,0 +1,258 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+/*
+Redis RDB中的紧凑编码：ziplist、listpack、intset，以及LZF压缩的字符串。
+这些编码都保存在一个字符串中，这里只负责把它们解析成元素列表，整数元素转换为十进制字符串。
+*/
+
+var errBadEncoding = errors.New("rdb bad compact encoding")
+
+// lzfDecompress 解压LZF压缩的数据，length 是解压后的长度
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// 字面量，长度为 ctrl+1
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, errBadEncoding
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+		// 回溯引用，高3位是长度，低5位和下一个字节是偏移量
+		ref := len(out) - (ctrl&0x1f)<<8 - 1
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(in) {
+				return nil, errBadEncoding
+			}
+			size += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadEncoding
+		}
+		ref -= int(in[i])
+		i++
+		if ref < 0 {
+			return nil, errBadEncoding
+		}
+		// 引用的区域可能和输出重叠，需要逐字节复制
+		for j := 0; j < size+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, errBadEncoding
+	}
+	return out, nil
+}
+
+// parseZiplist 解析ziplist：zlbytes(4) zltail(4) zllen(2) entry... 0xff
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errBadEncoding
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:]))
+	for i := 10; ; {
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[i] == 0xff {
+			return entries, nil
+		}
+		// 跳过前一个entry的长度
+		if buf[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		entry, n, err := parseZiplistEntry(buf[i:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		i += n
+	}
+}
+
+// parseZiplistEntry 解析一个entry的编码和内容，返回内容和占用的字节数
+func parseZiplistEntry(buf []byte) ([]byte, int, error) {
+	b := buf[0]
+	var length, header int
+	switch b >> 6 {
+	case 0:
+		length, header = int(b&0x3f), 1
+	case 1:
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		length, header = int(b&0x3f)<<8|int(buf[1]), 2
+	case 2:
+		if len(buf) < 5 {
+			return nil, 0, errBadEncoding
+		}
+		length, header = int(binary.BigEndian.Uint32(buf[1:])), 5
+	default:
+		return parseZiplistInt(buf)
+	}
+	if header+length > len(buf) {
+		return nil, 0, errBadEncoding
+	}
+	return buf[header : header+length], header + length, nil
+}
+
+func parseZiplistInt(buf []byte) ([]byte, int, error) {
+	b := buf[0]
+	var value int64
+	var size int
+	switch {
+	case b == 0xc0:
+		size = 2
+	case b == 0xd0:
+		size = 4
+	case b == 0xe0:
+		size = 8
+	case b == 0xf0:
+		size = 3
+	case b == 0xfe:
+		size = 1
+	case b >= 0xf1 && b <= 0xfd:
+		// 1111xxxx，xxxx-1 就是0到12之间的整数
+		return []byte(strconv.Itoa(int(b&0x0f) - 1)), 1, nil
+	default:
+		return nil, 0, errBadEncoding
+	}
+	if 1+size > len(buf) {
+		return nil, 0, errBadEncoding
+	}
+	value = readIntLE(buf[1:1+size], size)
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+// parseListpack 解析listpack：total-bytes(4) num-elements(2) entry... 0xff，每个entry后面是entry长度的反向编码
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errBadEncoding
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(buf[4:]))
+	for i := 6; ; {
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[i] == 0xff {
+			return entries, nil
+		}
+		entry, n, err := parseListpackEntry(buf[i:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		i += n + backlenSize(n)
+	}
+}
+
+// parseListpackEntry 解析一个entry的编码和内容，返回内容和编码加内容占用的字节数
+func parseListpackEntry(buf []byte) ([]byte, int, error) {
+	b := buf[0]
+	var length, header int
+	switch {
+	case b&0x80 == 0:
+		// 0xxxxxxx 7位无符号整数
+		return []byte(strconv.Itoa(int(b))), 1, nil
+	case b&0xc0 == 0x80:
+		// 10xxxxxx 6位长度的字符串
+		length, header = int(b&0x3f), 1
+	case b&0xe0 == 0xc0:
+		// 110xxxxx yyyyyyyy 13位有符号整数
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		value := int(b&0x1f)<<8 | int(buf[1])
+		if value >= 1<<12 {
+			value -= 1 << 13
+		}
+		return []byte(strconv.Itoa(value)), 2, nil
+	case b&0xf0 == 0xe0:
+		// 1110xxxx yyyyyyyy 12位长度的字符串
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		length, header = int(b&0x0f)<<8|int(buf[1]), 2
+	case b == 0xf0:
+		if len(buf) < 5 {
+			return nil, 0, errBadEncoding
+		}
+		length, header = int(binary.LittleEndian.Uint32(buf[1:])), 5
+	case b >= 0xf1 && b <= 0xf4:
+		size := [...]int{2, 3, 4, 8}[b-0xf1]
+		if 1+size > len(buf) {
+			return nil, 0, errBadEncoding
+		}
+		return []byte(strconv.FormatInt(readIntLE(buf[1:1+size], size), 10)), 1 + size, nil
+	default:
+		return nil, 0, errBadEncoding
+	}
+	if header+length > len(buf) {
+		return nil, 0, errBadEncoding
+	}
+	return buf[header : header+length], header + length, nil
+}
+
+// backlenSize entry长度的反向编码占用的字节数，每个字节保存7位，边界与Redis的 lpEncodeBacklen 一致
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseIntset 解析intset：encoding(4) length(4) 按encoding宽度保存的有序整数
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errBadEncoding
+	}
+	size := int(binary.LittleEndian.Uint32(buf))
+	count := int(binary.LittleEndian.Uint32(buf[4:]))
+	if size != 2 && size != 4 && size != 8 || 8+size*count > len(buf) {
+		return nil, errBadEncoding
+	}
+	members := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		value := readIntLE(buf[8+i*size:8+(i+1)*size], size)
+		members[i] = []byte(strconv.FormatInt(value, 10))
+	}
+	return members, nil
+}
+
+// readIntLE 读取size字节的小端有符号整数
+func readIntLE(buf []byte, size int) int64 {
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(buf[i])
+	}
+	// 符号扩展
+	shift := 64 - 8*size
+	return int64(value<<shift) >> shift
+}
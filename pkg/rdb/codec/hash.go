@@ -53,7 +53,7 @@ func (dec *Decoder) ReadHash() (string, dict.Dict, error) {
 	if special {
 		return "", nil, fmt.Errorf("wrong length bytes for hash structure")
 	}
-	hash := dict.NewSimpleDict()
+	hash := dict.New()
 	var i uint64
 	for i = 0; i < length; i++ {
 		hKeyBytes, err := dec.readString()
@@ -0,0 +1,178 @@
+package codec
+
+import (
+	"fmt"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"strconv"
+)
+
+// readEncodedObject 读取Redis RDB中使用紧凑编码保存的对象，转换成redigo的数据结构，Object.Type 是转换后的类型
+func (dec *Decoder) readEncodedObject(b byte) (*Object, error) {
+	keyBytes, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	object := &Object{Key: string(keyBytes)}
+	var entries [][]byte
+	switch b {
+	case zset2Type:
+		object.Type = SortedSetType
+		object.Value, err = dec.readZSet2()
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	case listZiplistType:
+		object.Type = ListType
+		entries, err = dec.readEncoded(parseZiplist)
+	case listQuicklistType:
+		object.Type = ListType
+		entries, err = dec.readQuicklist(false)
+	case listQuicklist2Type:
+		object.Type = ListType
+		entries, err = dec.readQuicklist(true)
+	case setIntsetType:
+		object.Type = SetType
+		entries, err = dec.readEncoded(parseIntset)
+	case setListpackType:
+		object.Type = SetType
+		entries, err = dec.readEncoded(parseListpack)
+	case zsetZiplistType:
+		object.Type = SortedSetType
+		entries, err = dec.readEncoded(parseZiplist)
+	case zsetListpackType:
+		object.Type = SortedSetType
+		entries, err = dec.readEncoded(parseListpack)
+	case hashZiplistType:
+		object.Type = HashType
+		entries, err = dec.readEncoded(parseZiplist)
+	case hashListpackType:
+		object.Type = HashType
+		entries, err = dec.readEncoded(parseListpack)
+	default:
+		// module、stream、zipmap等类型redigo没有对应的数据结构
+		return nil, fmt.Errorf("unsupported redis value type: %d", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	object.Value, err = buildValue(object.Type, entries)
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// readZSet2 读取 ZSET_2 的长度和所有 member-score，key已经读取
+func (dec *Decoder) readZSet2() (*zset.SortedSet, error) {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if special {
+		return nil, fmt.Errorf("wrong length bytes")
+	}
+	zs := zset.NewSortedSet()
+	for i := uint64(0); i < length; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		score, err := dec.readBinaryScore()
+		if err != nil {
+			return nil, err
+		}
+		zs.Add(string(member), score)
+	}
+	return zs, nil
+}
+
+// readEncoded 读取一个字符串，并用parse解析其中的紧凑编码
+func (dec *Decoder) readEncoded(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(buf)
+}
+
+// readQuicklist 读取quicklist的所有节点。旧版本的节点都是ziplist；
+// Redis 7.0 之后每个节点前有容器类型，1表示节点是单个元素，2表示节点是listpack
+func (dec *Decoder) readQuicklist(withContainer bool) ([][]byte, error) {
+	count, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]byte, 0)
+	for i := uint64(0); i < count; i++ {
+		if !withContainer {
+			node, err := dec.readEncoded(parseZiplist)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, node...)
+			continue
+		}
+		container, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		switch container {
+		case 1:
+			element, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, element)
+		case 2:
+			node, err := dec.readEncoded(parseListpack)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, node...)
+		default:
+			return nil, fmt.Errorf("unknown quicklist container: %d", container)
+		}
+	}
+	return entries, nil
+}
+
+// buildValue 用解析出的元素创建对应类型的数据结构，zset和hash的元素是成对保存的
+func buildValue(valueType byte, entries [][]byte) (interface{}, error) {
+	switch valueType {
+	case ListType:
+		l := list.NewLinkedList()
+		for _, entry := range entries {
+			l.AddRight(entry)
+		}
+		return l, nil
+	case SetType:
+		s := set.NewSet()
+		for _, entry := range entries {
+			s.Add(string(entry))
+		}
+		return s, nil
+	}
+	if len(entries)%2 != 0 {
+		return nil, errBadEncoding
+	}
+	if valueType == SortedSetType {
+		zs := zset.NewSortedSet()
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+			if err != nil {
+				return nil, err
+			}
+			zs.Add(string(entries[i]), score)
+		}
+		return zs, nil
+	}
+	hash := dict.New()
+	for i := 0; i < len(entries); i += 2 {
+		hash.Put(string(entries[i]), entries[i+1])
+	}
+	return hash, nil
+}
@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"redigo/pkg/datastruct/dict"
+	"redigo/pkg/datastruct/list"
+	"redigo/pkg/datastruct/set"
+	"redigo/pkg/datastruct/zset"
+	"testing"
+)
+
+var (
+	// ["a", 1, -1, "hello"]
+	testListpack = []byte{0x16, 0, 0, 0, 4, 0, 0x81, 'a', 2, 0x01, 1, 0xdf, 0xff, 2, 0x85, 'h', 'e', 'l', 'l', 'o', 6, 0xff}
+	// ["m", "2.5"]
+	testZSetListpack = []byte{0x0f, 0, 0, 0, 2, 0, 0x81, 'm', 2, 0x83, '2', '.', '5', 4, 0xff}
+	// ["a", 300, 5]
+	testZiplist = []byte{0x14, 0, 0, 0, 0x11, 0, 0, 0, 3, 0, 0, 0x01, 'a', 3, 0xc0, 0x2c, 0x01, 4, 0xf6, 0xff}
+	// int16 [1, 2, 300]
+	testIntset = []byte{2, 0, 0, 0, 3, 0, 0, 0, 1, 0, 2, 0, 0x2c, 0x01}
+)
+
+// redisRDB 按Redis的格式拼出一个RDB文件
+type redisRDB struct {
+	bytes.Buffer
+}
+
+func (r *redisRDB) str(s []byte) {
+	r.WriteByte(byte(len(s)))
+	r.Write(s)
+}
+
+func (r *redisRDB) object(t byte, key string) {
+	r.WriteByte(t)
+	r.str([]byte(key))
+}
+
+func (r *redisRDB) finish() []byte {
+	r.WriteByte(EOF)
+	crc := newCRC64()
+	_, _ = crc.Write(r.Bytes())
+	_ = binary.Write(r, binary.LittleEndian, crc.Sum64())
+	return r.Bytes()
+}
+
+func TestDecoder_RedisFormat(t *testing.T) {
+	r := &redisRDB{}
+	r.WriteString("REDIS0011")
+	r.WriteByte(AUX)
+	r.str([]byte("redis-ver"))
+	r.str([]byte("7.2.0"))
+	r.WriteByte(AUX)
+	r.str([]byte("redis-bits"))
+	r.Write([]byte{0xc0, 64})
+	r.Write([]byte{SelectDB, 0, ReSizeDB, 10, 2})
+	// 整数编码的字符串，带毫秒过期时间
+	r.WriteByte(ExpireTimeMs)
+	_ = binary.Write(r, binary.LittleEndian, uint64(1700000000000))
+	r.object(StringType, "int")
+	r.Write([]byte{0xc1, 0xd4, 0xfe})
+	// LZF压缩的字符串，带LRU和LFU信息
+	r.Write([]byte{Idle, 5, Freq, 3})
+	r.object(StringType, "lzf")
+	r.Write([]byte{0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00})
+	r.object(listQuicklist2Type, "quicklist")
+	r.Write([]byte{2, 2})
+	r.str(testListpack)
+	r.WriteByte(1)
+	r.str([]byte("plain"))
+	r.object(listZiplistType, "ziplist")
+	r.str(testZiplist)
+	r.object(setIntsetType, "intset")
+	r.str(testIntset)
+	r.object(zsetListpackType, "zsetlp")
+	r.str(testZSetListpack)
+	r.object(hashListpackType, "hashlp")
+	r.str(testListpack)
+	r.object(SortedSetType, "zset")
+	r.Write([]byte{2, 1, 'x', 3, '1', '.', '5', 1, 'y', 254})
+	// 秒级过期时间
+	r.WriteByte(ExpireTime)
+	_ = binary.Write(r, binary.LittleEndian, uint32(1700000000))
+	r.object(zset2Type, "zset2")
+	r.Write([]byte{1, 1, 'x'})
+	_ = binary.Write(r, binary.LittleEndian, math.Float64bits(2))
+	data := r.finish()
+
+	objects := make(map[string]*Object)
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	err := dec.Walk(func(object *Object) error {
+		objects[object.Key] = object
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk error: %v", err)
+	}
+	if len(objects) != 9 {
+		t.Fatalf("expect 9 objects, got: %d", len(objects))
+	}
+	if o := objects["int"]; string(o.Value.([]byte)) != "-300" || o.ExpireAt != 1700000000000 {
+		t.Errorf("unexpected int string: %s, expire at: %d", o.Value, o.ExpireAt)
+	}
+	if o := objects["lzf"]; string(o.Value.([]byte)) != "aaaaaaaaaa" || o.ExpireAt != 0 {
+		t.Errorf("unexpected lzf string: %s", o.Value)
+	}
+	assertList(t, objects["quicklist"], "a", "1", "-1", "hello", "plain")
+	assertList(t, objects["ziplist"], "a", "300", "5")
+	if o := objects["intset"]; o.Type != SetType || o.Value.(*set.Set).Len() != 3 || o.Value.(*set.Set).Has("300") == 0 {
+		t.Errorf("unexpected intset: %v", o.Value)
+	}
+	if o := objects["zsetlp"]; o.Type != SortedSetType {
+		t.Errorf("unexpected zset type: %d", o.Type)
+	} else if score := zsetScore(o, "m"); score != 2.5 {
+		t.Errorf("unexpected zset score: %v", score)
+	}
+	hash := objects["hashlp"].Value.(dict.Dict)
+	if v, _ := hash.Get("-1"); hash.Len() != 2 || string(v.([]byte)) != "hello" {
+		t.Errorf("unexpected hash: %v", v)
+	}
+	if score := zsetScore(objects["zset"], "y"); !math.IsInf(score, 1) {
+		t.Errorf("expect +inf, got: %v", score)
+	}
+	if score := zsetScore(objects["zset"], "x"); score != 1.5 {
+		t.Errorf("expect 1.5, got: %v", score)
+	}
+	if o := objects["zset2"]; o.Type != SortedSetType || o.ExpireAt != 1700000000000 {
+		t.Errorf("unexpected zset2: type %d, expire at %d", o.Type, o.ExpireAt)
+	}
+}
+
+func TestDecoder_RedisChecksumMismatch(t *testing.T) {
+	r := &redisRDB{}
+	r.WriteString("REDIS0009")
+	r.object(StringType, "k")
+	r.str([]byte("v"))
+	data := r.finish()
+	data[len(data)-1] ^= 0xff
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	if err := dec.Walk(func(object *Object) error { return nil }); err == nil {
+		t.Error("expect checksum mismatch")
+	}
+}
+
+func TestDecoder_UnsupportedRedisVersion(t *testing.T) {
+	r := &redisRDB{}
+	r.WriteString("REDIS0012")
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(r.finish())))
+	if err := dec.Walk(func(object *Object) error { return nil }); err == nil {
+		t.Error("expect unsupported version error")
+	}
+}
+
+func TestEncoder_RedisFormat(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	enc := NewEncoder(buffer)
+	enc.UseRedisFormat()
+	zs := zset.NewSortedSet()
+	zs.Add("m", 1.25)
+	_ = enc.WriteHeader()
+	_ = enc.WriteDBIndex(0)
+	_ = enc.WriteTTL(1700000000000)
+	_ = enc.WriteKeyValue("small", []byte("-5"))
+	_ = enc.WriteKeyValue("big", []byte("12345678901"))
+	_ = enc.WriteKeyValue("zero", []byte("007"))
+	_ = enc.WriteKeyValue("zset", zs)
+	if err := enc.WriteEOF(); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	if !bytes.HasPrefix(data, []byte("REDIS0009")) {
+		t.Fatalf("unexpected header: %q", data[:9])
+	}
+	// 过期时间是小端，整数字符串使用Redis的 INT8 编码
+	expect := []byte{ExpireTimeMs, 0x00, 0x68, 0xe5, 0xcf, 0x8b, 0x01, 0x00, 0x00, StringType, 5, 's', 'm', 'a', 'l', 'l', 0xc0, 0xfb}
+	if !bytes.Contains(data, expect) {
+		t.Errorf("unexpected encoding: %x", data)
+	}
+
+	objects := make(map[string]*Object)
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	err := dec.Walk(func(object *Object) error {
+		objects[object.Key] = object
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk error: %v", err)
+	}
+	for key, value := range map[string]string{"small": "-5", "big": "12345678901", "zero": "007"} {
+		if string(objects[key].Value.([]byte)) != value {
+			t.Errorf("expect %s: %s, got: %s", key, value, objects[key].Value)
+		}
+	}
+	if objects["small"].ExpireAt != 1700000000000 {
+		t.Errorf("unexpected expire time: %d", objects["small"].ExpireAt)
+	}
+	if score := zsetScore(objects["zset"], "m"); score != 1.25 {
+		t.Errorf("expect score 1.25, got: %v", score)
+	}
+}
+
+func assertList(t *testing.T, object *Object, expect ...string) {
+	t.Helper()
+	if object.Type != ListType {
+		t.Errorf("%s: expect list type, got: %d", object.Key, object.Type)
+		return
+	}
+	values := make([]string, 0)
+	object.Value.(*list.LinkedList).ForEach(func(idx int, value []byte) bool {
+		values = append(values, string(value))
+		return true
+	})
+	if len(values) != len(expect) {
+		t.Errorf("%s: expect %v, got: %v", object.Key, expect, values)
+		return
+	}
+	for i := range values {
+		if values[i] != expect[i] {
+			t.Errorf("%s: expect %v, got: %v", object.Key, expect, values)
+			return
+		}
+	}
+}
+
+func zsetScore(object *Object, member string) float64 {
+	element, ok := object.Value.(*zset.SortedSet).GetScore(member)
+	if !ok {
+		return math.NaN()
+	}
+	return element.Score
+}
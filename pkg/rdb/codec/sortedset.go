@@ -6,10 +6,16 @@ import (
 	"log"
 	"math"
 	"redigo/pkg/datastruct/zset"
+	"strconv"
 )
 
 func (enc *Encoder) WriteZSetObject(key string, zs *zset.SortedSet) error {
-	err := enc.Write([]byte{SortedSetType})
+	// Redis格式使用 ZSET_2 类型，score 是小端的二进制double
+	zsetType := SortedSetType
+	if enc.redis {
+		zsetType = zset2Type
+	}
+	err := enc.Write([]byte{zsetType})
 	if err != nil {
 		log.Println("RDB write zset type bytes error: ", err)
 		return err
@@ -65,13 +71,10 @@ func (dec *Decoder) ReadZSetObject() (string, *zset.SortedSet, error) {
 		if err != nil {
 			return key, nil, err
 		}
-		buf := make([]byte, 8)
-		err = dec.Read(buf)
+		score, err := dec.readScore()
 		if err != nil {
 			return key, nil, err
 		}
-		bits := binary.BigEndian.Uint64(buf)
-		score := math.Float64frombits(bits)
 		zs.Add(string(val), score)
 	}
 	return key, zs, nil
@@ -80,6 +83,47 @@ func (dec *Decoder) ReadZSetObject() (string, *zset.SortedSet, error) {
 func (enc *Encoder) writeFloat64(value float64) error {
 	bits := math.Float64bits(value)
 	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, bits)
+	if enc.redis {
+		binary.LittleEndian.PutUint64(buf, bits)
+	} else {
+		binary.BigEndian.PutUint64(buf, bits)
+	}
 	return enc.Write(buf)
 }
+
+// readScore 读取 SortedSetType 中的score：redigo格式是大端的二进制double，Redis格式是字符串形式的double
+func (dec *Decoder) readScore() (float64, error) {
+	if !dec.redis {
+		buf := make([]byte, 8)
+		if err := dec.Read(buf); err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	}
+	length, err := dec.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	if err := dec.Read(buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readBinaryScore 读取 ZSET_2 中小端的二进制double
+func (dec *Decoder) readBinaryScore() (float64, error) {
+	buf := make([]byte, 8)
+	if err := dec.Read(buf); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
@@ -46,36 +46,41 @@ func (dec *Decoder) readString() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	var result uint64
 	// this string is number format
 	if special {
+		if dec.redis {
+			return dec.readRedisSpecialString(length)
+		}
+		var result int64
 		switch length {
 		case 0xc1:
-			// read uint8
+			// read int8
 			readBytes, err := dec.ReadByte()
 			if err != nil {
 				return nil, err
 			}
-			result = uint64(readBytes)
+			result = int64(int8(readBytes))
 		case 0xc2:
-			// read uint16
+			// read int16
 			buf := make([]byte, 2)
 			err = dec.Read(buf)
 			if err != nil {
 				return nil, err
 			}
-			result = binary.BigEndian.Uint64(buf)
+			result = int64(int16(binary.BigEndian.Uint16(buf)))
 		case 0xc3:
-			// read uint32
+			// read int32
 			buf := make([]byte, 4)
 			err = dec.Read(buf)
 			if err != nil {
 				return nil, err
 			}
-			result = binary.BigEndian.Uint64(buf)
+			result = int64(int32(binary.BigEndian.Uint32(buf)))
+		default:
+			return nil, fmt.Errorf("rdb unknown string encoding: %x", length)
 		}
 		// ItoA, get string
-		return []byte(strconv.FormatInt(int64(result), 10)), nil
+		return []byte(strconv.FormatInt(result, 10)), nil
 	} else {
 		// read normal string
 		buf := make([]byte, length)
@@ -87,6 +92,35 @@ func (dec *Decoder) readString() ([]byte, error) {
 	}
 }
 
+// readRedisSpecialString Redis格式中低6位为0、1、2时是小端的8、16、32位整数，为3时是LZF压缩的字符串
+func (dec *Decoder) readRedisSpecialString(encoding uint64) ([]byte, error) {
+	switch encoding & 0x3f {
+	case 0, 1, 2:
+		size := 1 << (encoding & 0x3f)
+		buf := make([]byte, size)
+		if err := dec.Read(buf); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(readIntLE(buf, size), 10)), nil
+	case 3:
+		compressed, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		length, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, compressed)
+		if err := dec.Read(buf); err != nil {
+			return nil, err
+		}
+		return lzfDecompress(buf, int(length))
+	default:
+		return nil, fmt.Errorf("rdb unknown string encoding: %x", encoding)
+	}
+}
+
 func (enc *Encoder) writeString(value string) error {
 	// check whether value is an int, try encoding in integer way
 	isInt, err := enc.tryWriteAsInt(value)
@@ -111,14 +145,23 @@ func (enc *Encoder) writeString(value string) error {
 
 // Encode string as a int value
 func (enc *Encoder) tryWriteAsInt(value string) (bool, error) {
-	// parse int, check if string is int value
+	// parse int, check if string is int value. 只有转换回字符串后不变的整数才能这样保存，例如 "007" 不行
 	num, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
+	if err != nil || strconv.FormatInt(num, 10) != value {
 		return false, nil
 	}
 	var buf []byte
-	// 11 + 01 + 8bits int
-	if num >= math.MinInt8 && num <= math.MaxInt8 {
+	if enc.redis {
+		// Redis格式：11 + 000000/000001/000010，后面是小端的8、16、32位整数
+		if num >= math.MinInt8 && num <= math.MaxInt8 {
+			buf = []byte{0xc0, byte(num)}
+		} else if num >= math.MinInt16 && num <= math.MaxInt16 {
+			buf = binary.LittleEndian.AppendUint16([]byte{0xc1}, uint16(int16(num)))
+		} else if num >= math.MinInt32 && num <= math.MaxInt32 {
+			buf = binary.LittleEndian.AppendUint32([]byte{0xc2}, uint32(int32(num)))
+		}
+	} else if num >= math.MinInt8 && num <= math.MaxInt8 {
+		// 11 + 01 + 8bits int
 		buf = make([]byte, 2)
 		buf[0] = 0b11000001
 		buf[1] = byte(num)
@@ -133,6 +176,10 @@ func (enc *Encoder) tryWriteAsInt(value string) (bool, error) {
 		buf[0] = 0b11000011
 		binary.BigEndian.PutUint32(buf[1:], uint32(int32(num)))
 	}
+	if buf == nil {
+		// 超出32位的整数按普通字符串保存
+		return false, nil
+	}
 	err = enc.Write(buf)
 	if err != nil {
 		return true, err
@@ -0,0 +1,150 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Object RDB中的一个key-value
+type Object struct {
+	DB       int
+	Key      string
+	Type     byte
+	Value    interface{}
+	ExpireAt int64 // ExpireAt 过期时间的unix毫秒时间戳，0表示没有过期时间
+}
+
+// ReadHeader 读取并检查RDB文件头：redigo的 MagicNum 和 Version，或者Redis的 REDIS 和不超过 maxRedisVersion 的版本号
+func (dec *Decoder) ReadHeader() error {
+	header := make([]byte, len(MagicNum)+len(Version))
+	if err := dec.Read(header); err != nil {
+		return fmt.Errorf("rdb read header error: %v", err)
+	}
+	switch {
+	case bytes.Equal(header[:len(MagicNum)], MagicNum):
+		if !bytes.Equal(header[len(MagicNum):], Version) {
+			return errors.New("unsupported rdb file version")
+		}
+	case bytes.Equal(header[:len(RedisMagicNum)], RedisMagicNum):
+		version, err := strconv.Atoi(string(header[len(RedisMagicNum):]))
+		if err != nil || version < 1 || version > maxRedisVersion {
+			return fmt.Errorf("unsupported redis rdb file version: %s", header[len(RedisMagicNum):])
+		}
+		dec.redis = true
+	default:
+		return errors.New("not valid rdb file format")
+	}
+	return nil
+}
+
+// Walk 读取RDB文件头和所有的key-value，按文件中的顺序交给visit，读到EOF标记并校验校验和后返回。
+// visit 返回错误时停止读取并返回该错误
+func (dec *Decoder) Walk(visit func(object *Object) error) error {
+	if err := dec.ReadHeader(); err != nil {
+		return err
+	}
+	dbIndex := 0
+	var expireAt int64
+	for {
+		b, err := dec.ReadByte()
+		if err != nil {
+			return fmt.Errorf("rdb read type byte error: %v", err)
+		}
+		switch b {
+		case EOF:
+			return dec.VerifyChecksum()
+		case SelectDB:
+			if dbIndex, err = dec.ReadDBIndex(); err != nil {
+				return err
+			}
+		case ReSizeDB:
+			if _, _, err := dec.ReadDBSize(); err != nil {
+				return err
+			}
+		case ExpireTimeMs:
+			ttl, err := dec.ReadTTL()
+			if err != nil {
+				return fmt.Errorf("rdb read key expire time error: %v", err)
+			}
+			expireAt = int64(ttl)
+		case ExpireTime:
+			if expireAt, err = dec.readExpireTimeSec(); err != nil {
+				return fmt.Errorf("rdb read key expire time error: %v", err)
+			}
+		case AUX:
+			// Redis的辅助字段，例如 redis-ver、ctime，跳过
+			if err := dec.skipStrings(2); err != nil {
+				return fmt.Errorf("rdb read aux field error: %v", err)
+			}
+		case Idle:
+			if _, _, err := dec.readLength(); err != nil {
+				return fmt.Errorf("rdb read key idle time error: %v", err)
+			}
+		case Freq:
+			if _, err := dec.ReadByte(); err != nil {
+				return fmt.Errorf("rdb read key frequency error: %v", err)
+			}
+		case Function2:
+			// Redis Functions的库代码，redigo不支持，跳过
+			if err := dec.skipStrings(1); err != nil {
+				return fmt.Errorf("rdb read function error: %v", err)
+			}
+		case ModuleAux:
+			return errors.New("rdb module aux data is not supported")
+		default:
+			object, err := dec.readObject(b)
+			if err != nil {
+				return err
+			}
+			object.DB, object.ExpireAt = dbIndex, expireAt
+			expireAt = 0
+			if err := visit(object); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipStrings 读取并丢弃count个字符串
+func (dec *Decoder) skipStrings(count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := dec.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readObject 根据类型字节读取一个key-value
+func (dec *Decoder) readObject(b byte) (*Object, error) {
+	var key string
+	var value interface{}
+	var err error
+	switch b {
+	case StringType:
+		key, value, err = dec.ReadStringObject()
+	case ListType:
+		key, value, err = dec.ReadListObject()
+	case SetType:
+		key, value, err = dec.ReadSetObject()
+	case SortedSetType:
+		key, value, err = dec.ReadZSetObject()
+	case HashType:
+		key, value, err = dec.ReadHash()
+	default:
+		if !dec.redis {
+			return nil, fmt.Errorf("rdb unknown value type: %d", b)
+		}
+		object, err := dec.readEncodedObject(b)
+		if err != nil {
+			return nil, fmt.Errorf("rdb read object error: %v", err)
+		}
+		return object, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rdb read object error: %v", err)
+	}
+	return &Object{Key: key, Type: b, Value: value}, nil
+}
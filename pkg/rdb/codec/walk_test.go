@@ -0,0 +1,82 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func encodeTestRDB(t *testing.T) []byte {
+	buffer := &bytes.Buffer{}
+	enc := NewEncoder(buffer)
+	if err := enc.Write(append(append([]byte{}, MagicNum...), Version...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = enc.WriteDBIndex(0)
+	_ = enc.WriteDBSize(2, 1)
+	_ = enc.WriteTTL(1700000000000)
+	_ = enc.WriteKeyValue("k1", []byte("v1"))
+	_ = enc.WriteKeyValue("k2", []byte("v2"))
+	if err := enc.WriteEOF(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDecoder_Walk(t *testing.T) {
+	data := encodeTestRDB(t)
+	objects := make([]*Object, 0)
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	err := dec.Walk(func(object *Object) error {
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk error: %v", err)
+	}
+	if len(objects) != 2 || objects[0].Key != "k1" || objects[1].Key != "k2" {
+		t.Fatalf("unexpected objects: %v", objects)
+	}
+	if objects[0].ExpireAt != 1700000000000 || objects[1].ExpireAt != 0 {
+		t.Errorf("unexpected expire time: %d, %d", objects[0].ExpireAt, objects[1].ExpireAt)
+	}
+	if dec.Offset() != int64(len(data)) {
+		t.Errorf("expect offset: %d, got: %d", len(data), dec.Offset())
+	}
+}
+
+func TestDecoder_ChecksumMismatch(t *testing.T) {
+	data := encodeTestRDB(t)
+	// 修改value的内容，数据仍然可以解析，但校验和不一致
+	data[bytes.Index(data, []byte("v2"))] = 'x'
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	err := dec.Walk(func(object *Object) error {
+		return nil
+	})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expect checksum mismatch, got: %v", err)
+	}
+}
+
+func TestDecoder_DisabledChecksum(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	enc := NewEncoder(buffer)
+	enc.DisableChecksum()
+	_ = enc.Write(append(append([]byte{}, MagicNum...), Version...))
+	_ = enc.WriteKeyValue("k", []byte("v"))
+	_ = enc.WriteEOF()
+	dec := NewDecoder(bufio.NewReader(bytes.NewReader(buffer.Bytes())))
+	if err := dec.Walk(func(object *Object) error { return nil }); err != nil {
+		t.Errorf("walk error: %v", err)
+	}
+}
+
+func TestCRC64(t *testing.T) {
+	// Redis crc64.c 中的测试数据
+	crc := newCRC64()
+	_, _ = crc.Write([]byte("123456789"))
+	if crc.Sum64() != 0xe9c6d914c4b8d9ca {
+		t.Errorf("expect crc64: %x, got: %x", uint64(0xe9c6d914c4b8d9ca), crc.Sum64())
+	}
+}
@@ -2,29 +2,68 @@ package rdb
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"redigo/pkg/datastruct/bitmap"
 	"redigo/pkg/datastruct/dict"
 	"redigo/pkg/datastruct/list"
 	"redigo/pkg/datastruct/set"
 	"redigo/pkg/datastruct/zset"
 	"redigo/pkg/interface/database"
 	"redigo/pkg/rdb/codec"
+	"strconv"
 )
 
-// SerializeEntry to RDB byte stream
+// dumpVersion DUMP数据中记录的RDB版本，与 codec.Version 相同
+var dumpVersion = func() uint16 {
+	version, _ := strconv.Atoi(string(codec.Version))
+	return uint16(version)
+}()
+
+// ErrBadPayload DUMP数据的版本或校验和不正确
+var ErrBadPayload = errors.New("dump payload version or checksum are wrong")
+
+// SerializeEntry to RDB byte stream, with a footer of 2 bytes RDB version and 8 bytes CRC64, used by DUMP.
+// 与Redis相同，版本号和校验和都是小端，校验和覆盖footer之前的所有数据和版本号
 func SerializeEntry(key string, entry *database.Entry) ([]byte, error) {
+	var payload []byte
+	var err error
 	switch entry.Data.(type) {
 	case []byte:
-		return serializeString(key, entry.Data)
+		payload, err = serializeString(key, entry.Data)
+	case *bitmap.BitMap:
+		payload, err = serializeString(key, []byte(*entry.Data.(*bitmap.BitMap)))
 	case *list.LinkedList:
-		return serializeList(key, entry.Data)
+		payload, err = serializeList(key, entry.Data)
 	case dict.Dict:
-		return serializeHash(key, entry.Data)
+		payload, err = serializeHash(key, entry.Data)
 	case *set.Set:
-		return serializeSet(key, entry.Data)
+		payload, err = serializeSet(key, entry.Data)
 	case *zset.SortedSet:
-		return serializeSortedSet(key, entry.Data)
+		payload, err = serializeSortedSet(key, entry.Data)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload = binary.LittleEndian.AppendUint16(payload, dumpVersion)
+	return binary.LittleEndian.AppendUint64(payload, codec.CRC64(payload)), nil
+}
+
+// VerifyPayload 检查DUMP数据的版本和校验和，返回去掉footer的RDB数据
+func VerifyPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, ErrBadPayload
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) != dumpVersion {
+		return nil, ErrBadPayload
+	}
+	if binary.LittleEndian.Uint64(footer[2:]) != codec.CRC64(payload[:len(payload)-8]) {
+		return nil, ErrBadPayload
 	}
-	return nil, nil
+	return payload[:len(payload)-10], nil
 }
 
 func serializeString(key string, value interface{}) ([]byte, error) {
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"redigo/pkg/datastruct/bitmap"
 	"redigo/pkg/datastruct/dict"
 	"redigo/pkg/datastruct/list"
 	"redigo/pkg/datastruct/set"
@@ -137,3 +138,27 @@ func TestSerializeZSetEntry(t *testing.T) {
 		return true
 	})
 }
+
+func TestVerifyPayload(t *testing.T) {
+	serialized, err := SerializeEntry("bm", &database.Entry{Data: bitmap.New()})
+	if err != nil || serialized == nil {
+		t.Fatalf("serialize bitmap error: %v", err)
+	}
+	payload, err := VerifyPayload(serialized)
+	if err != nil {
+		t.Fatalf("verify payload error: %v", err)
+	}
+	if len(payload) != len(serialized)-10 || payload[0] != codec.StringType {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+	for _, i := range []int{0, len(serialized) - 10, len(serialized) - 1} {
+		corrupted := append([]byte(nil), serialized...)
+		corrupted[i] ^= 0xff
+		if _, err := VerifyPayload(corrupted); err != ErrBadPayload {
+			t.Errorf("expect bad payload when byte %d changed, got: %v", i, err)
+		}
+	}
+	if _, err := VerifyPayload(serialized[:9]); err != ErrBadPayload {
+		t.Errorf("expect bad payload for short data, got: %v", err)
+	}
+}
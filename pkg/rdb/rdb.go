@@ -1,33 +1,59 @@
 package rdb
 
 import (
+	"bufio"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
 	"redigo/pkg/config"
 	"redigo/pkg/interface/database"
 	"redigo/pkg/rdb/codec"
-	"redigo/pkg/util/log"
 	"time"
 )
 
-type DataEntry struct {
-	Key        string
-	Value      interface{}
-	ExpireTime *time.Time
+// Source RDB的数据来源，database.DB 和bgsave的快照都实现了这两个方法
+type Source interface {
+	Len(dbIdx int) int
+	ForEach(dbIdx int, fun func(key string, entry *database.Entry, expire *time.Time) bool)
 }
 
-// Save generate a RDB file, write all data in memory to RDB
-func Save(db database.DB) error {
+// Save generate a RDB file, write all data in source to RDB.
+// 先写入同一目录中的临时文件，fsync后再rename，保存失败时不会破坏原来的RDB文件
+func Save(db Source) error {
 	// open rdb file
-	rdbFile, err := ioutil.TempFile("./", "dump-*.rdb")
+	rdbFile, err := os.CreateTemp(filepath.Dir(config.Properties.DBFileName), "temp-dump-*.rdb")
 	if err != nil {
 		return err
 	}
-	defer rdbFile.Close()
-	encoder := codec.NewEncoder(rdbFile)
+	defer func() {
+		_ = rdbFile.Close()
+		if err != nil {
+			_ = os.Remove(rdbFile.Name())
+		}
+	}()
+	writer := bufio.NewWriter(rdbFile)
+	if err = Write(db, writer); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return fmt.Errorf("write rdb file error: %v", err)
+	}
+	if err = rdbFile.Sync(); err != nil {
+		return fmt.Errorf("sync rdb file error: %v", err)
+	}
+	_ = rdbFile.Close()
+	if err = os.Rename(rdbFile.Name(), config.Properties.DBFileName); err != nil {
+		return fmt.Errorf("rename temp rdb file error: %v", err)
+	}
+	return nil
+}
+
+// Write encode all data in source to RDB format, and write it to writer
+func Write(db Source, writer io.Writer) error {
+	encoder := newEncoder(writer)
 	// write REDIS and VERSION
-	err = writeHeader(encoder)
+	err := writeHeader(encoder)
 	if err != nil {
 		return fmt.Errorf("rdb write header error: %v", err)
 	}
@@ -51,99 +77,43 @@ func Save(db database.DB) error {
 		db.ForEach(i, func(key string, entry *database.Entry, expire *time.Time) bool {
 			// write key's expire time
 			if expire != nil {
-				ttlErr := encoder.WriteTTL(uint64(expire.UnixMilli()))
-				if ttlErr != nil {
-					log.Errorf("RDB write key expire time error: ", ttlErr)
+				if err = encoder.WriteTTL(uint64(expire.UnixMilli())); err != nil {
 					return false
 				}
 			}
 			// write key and value
-			kvErr := encoder.WriteKeyValue(key, entry.Data)
-			if kvErr != nil {
-				log.Errorf("RDB write key value error: %v", kvErr)
-				return false
-			}
-			return true
+			err = encoder.WriteKeyValue(key, entry.Data)
+			return err == nil
 		})
+		if err != nil {
+			return fmt.Errorf("rdb write key value error: %v", err)
+		}
 	}
-	err = encoder.Write([]byte{codec.EOF})
+	err = encoder.WriteEOF()
 	if err != nil {
 		return fmt.Errorf("rdb write EOF error: %v", err)
 	}
-	rdbFile.Close()
-	err = os.Rename(rdbFile.Name(), config.Properties.DBFileName)
-	if err != nil {
-		return fmt.Errorf("rename temp rdb file error: %v", err)
-	}
 	return nil
 }
 
-func BGSave(entries [][]*DataEntry) error {
-	// open rdb file
-	rdbFile, err := ioutil.TempFile("./", "dump-*.rdb")
-	if err != nil {
-		return err
-	}
-	defer rdbFile.Close()
-	encoder := codec.NewEncoder(rdbFile)
-	// write REDIS and VERSION
-	err = writeHeader(encoder)
-	if err != nil {
-		return fmt.Errorf("rdb write header error: %v", err)
-	}
-
-	for i, entrySet := range entries {
-		if entrySet != nil && len(entrySet) > 0 {
-			// select DB
-			err = encoder.WriteDBIndex(uint64(i))
-			if err != nil {
-				return fmt.Errorf("rdb write database index error: %v", err)
-			}
-			size := len(entrySet)
-			err = encoder.WriteDBSize(uint64(size), 0)
-			if err != nil {
-				return fmt.Errorf("rdb write database size error: %v", err)
-			}
-			// write key-value pairs
-			for _, entry := range entrySet {
-				if entry.ExpireTime != nil {
-					if entry.ExpireTime.Before(time.Now()) {
-						continue
-					} else {
-						// write expire time
-						err := encoder.WriteTTL(uint64(entry.ExpireTime.UnixMilli()))
-						if err != nil {
-							log.Errorf("rdb write expire time error: %v", err)
-							break
-						}
-					}
-				}
-				// write entry's key value
-				err := encoder.WriteKeyValue(entry.Key, entry.Value)
-				if err != nil {
-					log.Errorf("rdb write key value error: %v", err)
-					break
-				}
-			}
-		}
-	}
-
-	err = encoder.Write([]byte{codec.EOF})
-	if err != nil {
-		return fmt.Errorf("rdb write EOF error: %v", err)
+// newEncoder 创建encoder，rdbChecksum 关闭时不计算校验和，rdbCompatible 开启时按Redis的格式写入
+func newEncoder(writer io.Writer) *codec.Encoder {
+	encoder := codec.NewEncoder(writer)
+	if !config.Properties.RdbChecksum {
+		encoder.DisableChecksum()
 	}
-	rdbFile.Close()
-	err = os.Rename(rdbFile.Name(), config.Properties.DBFileName)
-	if err != nil {
-		return fmt.Errorf("rename temp rdb file error: %v", err)
+	if config.Properties.RdbCompatible {
+		encoder.UseRedisFormat()
 	}
-	return nil
+	return encoder
 }
 
 func writeHeader(encoder *codec.Encoder) error {
-	err := encoder.Write(codec.MagicNum)
-	if err != nil {
-		return err
-	}
-	return encoder.Write(codec.Version)
+	return encoder.WriteHeader()
+}
+
+// BGSave 把后台保存时截取的快照写入RDB文件，在executor之外的goroutine中执行。
+// 快照的 ForEach 负责与executor的写操作同步，这里与 Save 的写入流程完全相同
+func BGSave(snapshot Source) error {
+	return Save(snapshot)
 }
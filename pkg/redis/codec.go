@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"bufio"
 	"errors"
 	"io"
 	"redigo/pkg/util/str"
@@ -13,6 +14,19 @@ type CodecBuffer interface {
 	ReadBytes(delim byte) ([]byte, error)
 }
 
+// BlockingReader 每次Read都会读满缓冲区，客户端从阻塞的网络连接读取回复时使用，避免大的bulk string被截断
+type BlockingReader struct {
+	*bufio.Reader
+}
+
+func NewBlockingReader(reader *bufio.Reader) BlockingReader {
+	return BlockingReader{Reader: reader}
+}
+
+func (r BlockingReader) Read(p []byte) (int, error) {
+	return io.ReadFull(r.Reader, p)
+}
+
 // Decode 解码Redis网络协议
 func Decode(reader CodecBuffer) (*RespCommand, error) {
 	// 读取一行数据，即读取  ...\r\n
@@ -64,11 +78,15 @@ func Decode(reader CodecBuffer) (*RespCommand, error) {
 		if size == 0 {
 			command = NewEmptyListCommand()
 		}
-		if parts, err := readArray(reader, size); err != nil {
+		if parts, raw, nested, err := readArray(reader, size); err != nil {
 			return nil, err
+		} else if nested {
+			command = NewNestedArrayCommand(raw)
 		} else {
 			command = NewCommand(parts)
 		}
+	default:
+		return nil, errors.New("protocol error: " + string(msg))
 	}
 	command.SetFromCluster(fromCluster)
 	return command, nil
@@ -89,6 +107,8 @@ func Encode(command *RespCommand) []byte {
 		return []byte("$-1\r\n")
 	case ReplyEmptyList:
 		return []byte("*0\r\n")
+	case ReplyTypeRaw:
+		return command.parts[0]
 	case CommandTypeArray:
 		builder := strings.Builder{}
 		// * length
@@ -136,26 +156,66 @@ func readBulkString(reader io.Reader, lengthStr []byte) ([]byte, error) {
 	return buffer[0:length], nil
 }
 
-func readArray(reader CodecBuffer, size int) ([][]byte, error) {
+// readArray 读取数组的元素。
+// 元素只有多行字符串和整数时返回parts；包含单行字符串、错误或嵌套数组时 nested 为true，
+// 此时raw中是每个元素的RESP编码，可以作为嵌套数组原样返回给客户端
+func readArray(reader CodecBuffer, size int) ([][]byte, [][]byte, bool, error) {
+	if size < 0 {
+		size = 0
+	}
 	parts := make([][]byte, size)
+	raw := make([][]byte, size)
+	nested := false
 	for i := 0; i < size; i++ {
 		// read a line
 		msg, ioErr, err := readLine(reader)
 		if ioErr {
-			return nil, io.EOF
+			return nil, nil, false, io.EOF
 		} else if err != nil {
-			return nil, err
+			return nil, nil, false, err
 		}
 		// read RESP Array
-		if msg[0] == '$' || msg[0] == ':' {
+		switch msg[0] {
+		case '$':
 			bulk, err := readBulkString(reader, msg)
 			if err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
 			parts[i] = bulk
+			if bulk == nil {
+				raw[i] = msg
+			} else {
+				raw[i] = append(append(msg, bulk...), CRLF...)
+			}
+		case ':':
+			// 数组中的整数元素，保存整数的字符串形式
+			parts[i] = msg[1 : len(msg)-2]
+			raw[i] = msg
+		case '*':
+			nested = true
+			n, err := strconv.Atoi(str.BytesToString(msg[1 : len(msg)-2]))
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if n < 0 {
+				raw[i] = msg
+				continue
+			}
+			elemParts, elemRaw, elemNested, err := readArray(reader, n)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if elemNested {
+				raw[i] = Encode(NewNestedArrayCommand(elemRaw))
+			} else {
+				raw[i] = Encode(NewArrayCommand(elemParts))
+			}
+		default:
+			nested = true
+			raw[i] = msg
 		}
 	}
-	return parts, nil
+	return parts, raw, nested, nil
 }
 
 func readLine(reader CodecBuffer) ([]byte, bool, error) {
@@ -163,7 +223,7 @@ func readLine(reader CodecBuffer) ([]byte, bool, error) {
 	if err != nil {
 		return nil, true, err
 	}
-	if len(msg) == 0 || msg[len(msg)-2] != '\r' {
+	if len(msg) < 2 || msg[len(msg)-2] != '\r' {
 		return nil, false, errors.New("protocol error: " + string(msg))
 	}
 	return msg, false, nil
@@ -15,6 +15,7 @@ const (
 	CommandTypeError                  // 错误
 	ReplyTypeNil                      // 空返回，Nil
 	ReplyEmptyList                    // 空列表返回
+	ReplyTypeRaw                      // 已编码的原始字节流，比如主从复制的RDB和命令流
 	CRLF = "\r\n"
 )
 
@@ -102,6 +103,14 @@ func NewEmptyListCommand() *RespCommand {
 	}
 }
 
+// NewRawCommand 创建原始字节流回复，编码时直接输出 raw，不做任何处理
+func NewRawCommand(raw []byte) *RespCommand {
+	return &RespCommand{
+		parts:       [][]byte{raw},
+		commandType: ReplyTypeRaw,
+	}
+}
+
 func NewErrorCommand(err error) *RespCommand {
 	return &RespCommand{
 		err:         err,
@@ -142,6 +151,11 @@ func (c *RespCommand) Get(idx int) string {
 	return string(c.parts[idx])
 }
 
+// Number 整数回复的值
+func (c *RespCommand) Number() int {
+	return c.number
+}
+
 func (c *RespCommand) Len() int {
 	return len(c.parts)
 }
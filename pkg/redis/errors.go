@@ -17,8 +17,13 @@ var (
 	DBIndexOutOfRangeError           = errors.New("ERR DB index is out of range")
 	ValueNotFloatError               = errors.New("ERR value is not a valid float")
 	SyntaxError                      = errors.New("ERR syntax error")
+	InvalidCursorError               = errors.New("ERR invalid cursor")
+	UnknownTypeNameError             = "ERR unknown type name '%s'"
 	AppendOnlyRewriteInProgressError = errors.New("ERR Background append only file rewriting already in progress")
 	BackgroundSaveInProgressError    = errors.New("ERR Background save already in progress")
+	BackgroundChildActiveError       = errors.New("ERR Another child process is active (AOF?): can't BGSAVE right now. Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.")
+	OOMCommandNotAllowedError        = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+	BgsaveErrorMisconfError          = errors.New("MISCONF Redis is configured to save RDB snapshots, but it's currently unable to persist to disk. Commands that may modify the data set are disabled, because this instance is configured to report errors during writes if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the Redis logs for details about the RDB error.")
 	NestedMultiCallError             = errors.New("ERR MULTI calls can not be nested")
 	CommandCannotUseInMultiError     = errors.New("ERR Command can't be used in MULTI")
 	ExecWithoutMultiError            = errors.New("ERR EXEC without MULTI")
@@ -26,10 +31,42 @@ var (
 	NoSuchKeyError                   = errors.New("ERR no such key")
 	ClusterPeerNotFoundError         = errors.New("ERR cluster peer not found")
 	ClusterPeerUnreachableError      = errors.New("ERR can't reach cluster peer")
-	MovedError                       = "MOVED %s"
+	ClusterPeerBusyError             = errors.New("ERR too many pending requests to cluster peer")
+	MovedError                       = "MOVED %d %s"
+	AskError                         = "ASK %d %s"
 	WatchInsideMultiError            = errors.New("ERR WATCH inside MULTI is not allowed")
 	InvalidCoordinatePairError       = "ERR invalid longitude,latitude pair %.6f,%.6f"
 	DistanceUnitError                = errors.New("ERR unsupported unit provided. please use m, km, ft, mi")
+	ReadOnlyReplicaError             = errors.New("READONLY You can't write against a read only replica.")
+	InvalidMasterPortError           = errors.New("ERR Invalid master port")
+	ReplicaOfInClusterModeError      = errors.New("ERR REPLICAOF not allowed in cluster mode.")
+	NoSuchMasterError                = errors.New("ERR No such master with that name")
+	FailoverInProgressError          = errors.New("INPROG Failover already in progress")
+	NoGoodReplicaError               = errors.New("NOGOODSLAVE No suitable replica to promote")
+	BusyKeyError                     = errors.New("BUSYKEY Target key name already exists.")
+	BadDumpPayloadError              = errors.New("ERR Bad data format")
+	InvalidTTLError                  = errors.New("ERR Invalid TTL value, must be >= 0")
+	DumpPayloadError                 = errors.New("ERR DUMP payload version or checksum are wrong")
+	InvalidIdleTimeError             = errors.New("ERR Invalid IDLETIME value, must be >= 0")
+	InvalidFreqError                 = errors.New("ERR Invalid FREQ value, must be >= 0 and <= 255")
+	UnknownSubcommandError           = "ERR unknown subcommand '%s'. Try %s HELP."
+	MigrateConnectError              = errors.New("IOERR error or timeout connecting to the client")
+	MigrateIOError                   = "IOERR error or timeout %s target instance"
+	MigrateTargetError               = "ERR Target instance replied with error: %s"
+	SelectInClusterModeError         = errors.New("ERR SELECT is not allowed in cluster mode")
+	SlotNotServedError               = errors.New("CLUSTERDOWN Hash slot not served")
+	ClusterDownError                 = errors.New("CLUSTERDOWN The cluster is down")
+	ForgetMyselfError                = errors.New("ERR I tried hard but I can't forget myself...")
+	InvalidNodeAddressError          = "ERR Invalid node address specified: %s"
+	InvalidSlotError                 = errors.New("ERR Invalid or out of range slot")
+	UnknownNodeError                 = "ERR I don't know about node %s"
+	NotSlotOwnerError                = "ERR I'm not the owner of hash slot %d"
+	AlreadySlotOwnerError            = "ERR I'm already the owner of hash slot %d"
+	SlotNotEmptyError                = "ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot."
+	CrossSlotError                   = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	KeyLockedError                   = errors.New("TRYAGAIN Key is locked by a cluster transaction")
+	TxNotFoundError                  = errors.New("ERR transaction not found")
+	TxAbortError                     = "EXECABORT Transaction discarded because of: %s"
 )
 
 func CreateWrongArgumentNumberError(command string) error {
@@ -40,10 +77,50 @@ func CreateUnknownCommandError(command string) error {
 	return fmt.Errorf(UnknownCommandError, command)
 }
 
-func CreateMovedError(targetAddr string) error {
-	return fmt.Errorf(MovedError, targetAddr)
+func CreateUnknownSubcommandError(subcommand, command string) error {
+	return fmt.Errorf(UnknownSubcommandError, subcommand, command)
+}
+
+func CreateMovedError(slot int, targetAddr string) error {
+	return fmt.Errorf(MovedError, slot, targetAddr)
+}
+
+func CreateAskError(slot int, targetAddr string) error {
+	return fmt.Errorf(AskError, slot, targetAddr)
 }
 
 func CreateInvalidCoordinatePairError(longitude, latitude float64) error {
 	return fmt.Errorf(InvalidCoordinatePairError, longitude, latitude)
 }
+
+func CreateMigrateIOError(operation string) error {
+	return fmt.Errorf(MigrateIOError, operation)
+}
+
+func CreateMigrateTargetError(message string) error {
+	return fmt.Errorf(MigrateTargetError, message)
+}
+
+func CreateUnknownNodeError(nodeID string) error {
+	return fmt.Errorf(UnknownNodeError, nodeID)
+}
+
+func CreateNotSlotOwnerError(slot int) error {
+	return fmt.Errorf(NotSlotOwnerError, slot)
+}
+
+func CreateAlreadySlotOwnerError(slot int) error {
+	return fmt.Errorf(AlreadySlotOwnerError, slot)
+}
+
+func CreateSlotNotEmptyError(slot int) error {
+	return fmt.Errorf(SlotNotEmptyError, slot)
+}
+
+func CreateTxAbortError(reason string) error {
+	return fmt.Errorf(TxAbortError, reason)
+}
+
+func CreateInvalidNodeAddressError(addr string) error {
+	return fmt.Errorf(InvalidNodeAddressError, addr)
+}
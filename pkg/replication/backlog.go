@@ -0,0 +1,64 @@
+package replication
+
+// Backlog 复制积压缓冲区，环形保存master最近传播的命令流，用于从节点断线后的部分重同步
+type Backlog struct {
+	buf     []byte
+	size    int
+	idx     int   // idx 下一个字节的写入位置
+	histLen int   // histLen 缓冲区中有效数据的长度
+	offset  int64 // offset master的复制偏移量，即写入过的总字节数
+}
+
+func NewBacklog(size int) *Backlog {
+	if size <= 0 {
+		size = 1 << 20
+	}
+	return &Backlog{
+		buf:  make([]byte, size),
+		size: size,
+	}
+}
+
+// Append 将传播给从节点的命令流写入缓冲区
+func (b *Backlog) Append(data []byte) {
+	b.offset += int64(len(data))
+	// 数据超过缓冲区大小，只保留尾部
+	if len(data) > b.size {
+		data = data[len(data)-b.size:]
+	}
+	for len(data) > 0 {
+		n := copy(b.buf[b.idx:], data)
+		b.idx = (b.idx + n) % b.size
+		b.histLen += n
+		data = data[n:]
+	}
+	if b.histLen > b.size {
+		b.histLen = b.size
+	}
+}
+
+// Offset 当前的复制偏移量
+func (b *Backlog) Offset() int64 {
+	return b.offset
+}
+
+// FirstOffset 缓冲区中第一个字节的偏移量，偏移量从1开始计数
+func (b *Backlog) FirstOffset() int64 {
+	return b.offset - int64(b.histLen) + 1
+}
+
+// ReadFrom 读取从 offset 开始（包含）到最新的数据，如果 offset 已经不在缓冲区范围内返回false
+func (b *Backlog) ReadFrom(offset int64) ([]byte, bool) {
+	if offset < b.FirstOffset() || offset > b.offset+1 {
+		return nil, false
+	}
+	length := int(b.offset - offset + 1)
+	result := make([]byte, length)
+	// 计算 offset 在环形缓冲区中的位置
+	start := (b.idx - length + b.size) % b.size
+	n := copy(result, b.buf[start:])
+	if n < length {
+		copy(result[n:], b.buf[:length-n])
+	}
+	return result, true
+}
@@ -0,0 +1,48 @@
+package replication
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBacklog_ReadFrom(t *testing.T) {
+	b := NewBacklog(8)
+	b.Append([]byte("hello"))
+	if b.Offset() != 5 {
+		t.Fatalf("offset should be 5, got %d", b.Offset())
+	}
+	data, ok := b.ReadFrom(3)
+	if !ok || !bytes.Equal(data, []byte("llo")) {
+		t.Fatalf("read from 3 failed, got %s", string(data))
+	}
+	// 读取最新位置之后的数据，结果为空
+	data, ok = b.ReadFrom(6)
+	if !ok || len(data) != 0 {
+		t.Fatalf("read from 6 should be empty")
+	}
+	if _, ok = b.ReadFrom(7); ok {
+		t.Fatalf("offset out of range should fail")
+	}
+}
+
+func TestBacklog_Wrap(t *testing.T) {
+	b := NewBacklog(8)
+	b.Append([]byte("hello"))
+	b.Append([]byte("world"))
+	// 缓冲区只保留最后8个字节: "loworld"前的数据被覆盖
+	if b.FirstOffset() != 3 {
+		t.Fatalf("first offset should be 3, got %d", b.FirstOffset())
+	}
+	if _, ok := b.ReadFrom(2); ok {
+		t.Fatalf("overwritten offset should fail")
+	}
+	data, ok := b.ReadFrom(3)
+	if !ok || !bytes.Equal(data, []byte("lloworld")) {
+		t.Fatalf("read after wrap failed, got %s", string(data))
+	}
+	b.Append([]byte("0123456789"))
+	data, ok = b.ReadFrom(b.FirstOffset())
+	if !ok || !bytes.Equal(data, []byte("23456789")) {
+		t.Fatalf("read after overflow failed, got %s", string(data))
+	}
+}
@@ -0,0 +1,76 @@
+package replication
+
+import "redigo/pkg/redis"
+
+// MasterConnection 从节点上代表master的虚拟客户端连接，master传播的命令通过它提交执行，执行结果直接丢弃
+type MasterConnection struct {
+	addr       string
+	selectedDB int
+	multi      bool
+	cmdQueue   []*redis.RespCommand
+}
+
+func NewMasterConnection(addr string) *MasterConnection {
+	return &MasterConnection{addr: addr}
+}
+
+// IsMasterConnection 判断命令是否来自master的复制连接
+func IsMasterConnection(conn redis.Connection) bool {
+	_, ok := conn.(*MasterConnection)
+	return ok
+}
+
+func (m *MasterConnection) ReadLoop() error {
+	panic("method not allowed")
+}
+
+func (m *MasterConnection) Close() {
+}
+
+func (m *MasterConnection) SendCommand(_ *redis.RespCommand) {
+}
+
+func (m *MasterConnection) SelectDB(index int) {
+	m.selectedDB = index
+}
+
+func (m *MasterConnection) DBIndex() int {
+	return m.selectedDB
+}
+
+func (m *MasterConnection) SetMulti(multi bool) {
+	if !multi {
+		m.cmdQueue = nil
+	}
+	m.multi = multi
+}
+
+func (m *MasterConnection) IsMulti() bool {
+	return m.multi
+}
+
+func (m *MasterConnection) EnqueueCommand(command *redis.RespCommand) {
+	m.cmdQueue = append(m.cmdQueue, command)
+}
+
+func (m *MasterConnection) GetQueuedCommands() []*redis.RespCommand {
+	return m.cmdQueue
+}
+
+func (m *MasterConnection) AddWatching(_ string, _ int64) {
+}
+
+func (m *MasterConnection) GetWatching() map[string]int64 {
+	return nil
+}
+
+func (m *MasterConnection) UnWatch() {
+}
+
+func (m *MasterConnection) Active() bool {
+	return true
+}
+
+func (m *MasterConnection) RemoteAddr() string {
+	return m.addr
+}
@@ -0,0 +1,261 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"redigo/pkg/redis"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 从节点在master上的状态
+const (
+	ReplicaStateWaitBgSave = "wait_bgsave"
+	ReplicaStateOnline     = "online"
+)
+
+// ReplicaInfo master记录的从节点信息
+type ReplicaInfo struct {
+	IP            string
+	ListeningPort int
+	State         string
+	AckOffset     int64     // AckOffset 从节点通过 REPLCONF ACK 上报的复制偏移量
+	LastAckTime   time.Time // LastAckTime 最近一次收到 ACK 的时间
+}
+
+/*
+Master 主节点的复制状态。
+写命令在执行后通过 Feed 传播到复制积压缓冲区和所有在线的从节点，Feed 与 AOF 共用同一条写入路径。
+*/
+type Master struct {
+	replID      string
+	backlogSize int
+	backlog     *Backlog                          // backlog 在第一个从节点连接时才创建
+	replicas    map[redis.Connection]*ReplicaInfo // replicas 从节点连接与从节点信息的映射
+	currentDB   int                               // currentDB 上一条传播的命令所在的数据库，切换数据库时需要传播select命令
+	mutex       sync.Mutex
+	closeChan   chan struct{}
+}
+
+// pingInterval master定时向从节点传播PING，从节点依靠它判断连接是否超时
+const pingInterval = 10 * time.Second
+
+func NewMaster(backlogSize int) *Master {
+	m := &Master{
+		replID:      NewReplID(),
+		backlogSize: backlogSize,
+		replicas:    make(map[redis.Connection]*ReplicaInfo),
+		currentDB:   -1,
+		closeChan:   make(chan struct{}),
+	}
+	go m.pingLoop()
+	return m
+}
+
+// NewReplID 生成一个随机的40位十六进制 replication id
+func NewReplID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (m *Master) ReplID() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.replID
+}
+
+// Offset master当前的复制偏移量
+func (m *Master) Offset() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.backlog == nil {
+		return 0
+	}
+	return m.backlog.Offset()
+}
+
+// Feed 传播一条写命令，没有从节点连接过的情况下不做任何处理
+func (m *Master) Feed(command [][]byte, dbIdx int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.backlog == nil {
+		return
+	}
+	var payload []byte
+	// 切换数据库，需要先传播select命令
+	if dbIdx != m.currentDB {
+		selectCmd := redis.NewStringArrayCommand([]string{"SELECT", strconv.Itoa(dbIdx)})
+		payload = append(payload, redis.Encode(selectCmd)...)
+		m.currentDB = dbIdx
+	}
+	payload = append(payload, redis.Encode(redis.NewArrayCommand(command))...)
+	m.feedPayload(payload)
+}
+
+// feedPayload 写入backlog并发送给所有在线的从节点，调用者需要持有锁
+func (m *Master) feedPayload(payload []byte) {
+	m.backlog.Append(payload)
+	for conn, replica := range m.replicas {
+		// 清理已经断开的从节点
+		if !conn.Active() {
+			delete(m.replicas, conn)
+			continue
+		}
+		if replica.State == ReplicaStateOnline {
+			conn.SendCommand(redis.NewRawCommand(payload))
+		}
+	}
+}
+
+func (m *Master) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	ping := redis.Encode(redis.NewStringArrayCommand([]string{"PING"}))
+	for {
+		select {
+		case <-m.closeChan:
+			return
+		case <-ticker.C:
+			m.mutex.Lock()
+			if m.backlog != nil && len(m.replicas) > 0 {
+				m.feedPayload(ping)
+			}
+			m.mutex.Unlock()
+		}
+	}
+}
+
+// Close 停止定时PING
+func (m *Master) Close() {
+	close(m.closeChan)
+}
+
+// ReplConf 记录从节点在握手阶段通过 REPLCONF listening-port 上报的端口
+func (m *Master) ReplConf(conn redis.Connection, listeningPort int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.getOrCreateReplica(conn).ListeningPort = listeningPort
+}
+
+// Ack 记录从节点上报的复制偏移量
+func (m *Master) Ack(conn redis.Connection, offset int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if replica, ok := m.replicas[conn]; ok {
+		replica.AckOffset = offset
+		replica.LastAckTime = time.Now()
+	}
+}
+
+// TryPartialSync 尝试部分重同步，从节点请求的偏移量还在backlog中时，只发送缺少的命令流
+func (m *Master) TryPartialSync(conn redis.Connection, replID string, offset int64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.backlog == nil || replID != m.replID {
+		return false
+	}
+	data, ok := m.backlog.ReadFrom(offset)
+	if !ok {
+		return false
+	}
+	conn.SendCommand(redis.NewSingleLineCommand([]byte("CONTINUE " + m.replID)))
+	if len(data) > 0 {
+		conn.SendCommand(redis.NewRawCommand(data))
+	}
+	replica := m.getOrCreateReplica(conn)
+	replica.State = ReplicaStateOnline
+	replica.LastAckTime = time.Now()
+	return true
+}
+
+// FullSync 全量同步，发送 FULLRESYNC 和RDB数据，之后的写命令会持续传播给该从节点。
+// rdb 必须是在调用 FullSync 之前、没有新写命令的情况下生成的快照
+func (m *Master) FullSync(conn redis.Connection, rdb []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.backlog == nil {
+		m.backlog = NewBacklog(m.backlogSize)
+	}
+	offset := m.backlog.Offset()
+	reply := "FULLRESYNC " + m.replID + " " + strconv.FormatInt(offset, 10)
+	conn.SendCommand(redis.NewSingleLineCommand([]byte(reply)))
+	// RDB以 $len\r\n 开头，末尾没有 \r\n
+	header := []byte("$" + strconv.Itoa(len(rdb)) + redis.CRLF)
+	conn.SendCommand(redis.NewRawCommand(append(header, rdb...)))
+	replica := m.getOrCreateReplica(conn)
+	replica.State = ReplicaStateOnline
+	replica.AckOffset = offset
+	replica.LastAckTime = time.Now()
+	// 新的从节点不知道当前的数据库，下一条命令前需要传播select
+	m.currentDB = -1
+}
+
+// Reset 更换 replication id 并断开所有从节点，在节点的主从身份改变时使用
+func (m *Master) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.replID = NewReplID()
+	m.backlog = nil
+	m.currentDB = -1
+	for conn := range m.replicas {
+		if conn.Active() {
+			conn.Close()
+		}
+	}
+	m.replicas = make(map[redis.Connection]*ReplicaInfo)
+}
+
+// Replicas 获取所有在线从节点信息的拷贝
+func (m *Master) Replicas() []ReplicaInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := make([]ReplicaInfo, 0, len(m.replicas))
+	for conn, replica := range m.replicas {
+		if !conn.Active() {
+			delete(m.replicas, conn)
+			continue
+		}
+		if replica.State == ReplicaStateOnline {
+			result = append(result, *replica)
+		}
+	}
+	return result
+}
+
+// IsReplica conn是否是从节点的复制连接
+func (m *Master) IsReplica(conn redis.Connection) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.replicas[conn]
+	return ok
+}
+
+// BacklogInfo 返回backlog的第一个字节偏移量和数据长度，backlog不存在时返回false
+func (m *Master) BacklogInfo() (first int64, histLen int64, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.backlog == nil {
+		return 0, 0, false
+	}
+	first = m.backlog.FirstOffset()
+	return first, m.backlog.Offset() - first + 1, true
+}
+
+func (m *Master) BacklogSize() int {
+	return m.backlogSize
+}
+
+func (m *Master) getOrCreateReplica(conn redis.Connection) *ReplicaInfo {
+	replica, ok := m.replicas[conn]
+	if !ok {
+		replica = &ReplicaInfo{State: ReplicaStateWaitBgSave}
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr()); err == nil {
+			replica.IP = host
+		}
+		m.replicas[conn] = replica
+	}
+	return replica
+}
@@ -0,0 +1,302 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 从节点与master连接的状态
+const (
+	LinkStateConnect    = "connect"    // 等待建立连接
+	LinkStateConnecting = "connecting" // 正在握手
+	LinkStateSync       = "sync"       // 正在接收RDB
+	LinkStateConnected  = "connected"  // 正在接收命令流
+)
+
+// LoadRDBCommand 从节点接收到RDB后提交给executor的内部命令，参数为RDB数据
+const LoadRDBCommand = "replica-load-rdb"
+
+const (
+	replicaDialTimeout  = 5 * time.Second
+	replicaMaxBackoff   = 30 * time.Second
+	replicaAckInterval  = 1 * time.Second
+	replicaReadDeadline = 60 * time.Second
+)
+
+/*
+Replica 从节点到master的复制连接。
+连接master后依次发送 PING、REPLCONF 和 PSYNC，然后接收RDB或者增量命令流，
+接收到的命令都会绑定到 MasterConnection 并提交到数据库的executor中执行。
+*/
+type Replica struct {
+	masterHost    string
+	masterPort    int
+	listeningPort int
+	db            database.DB
+	masterConn    *MasterConnection // masterConn 代表master的虚拟客户端，断线重连后保留，以便部分重同步时继续使用已选择的数据库
+	replID        string            // replID master的 replication id，为空表示需要全量同步
+	offset        int64             // offset 已经接收的复制偏移量
+	state         atomic.Value
+	lastIO        int64 // lastIO 最近一次收到master数据的unix时间戳
+	conn          net.Conn
+	connLock      sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+func NewReplica(masterHost string, masterPort int, listeningPort int, db database.DB) *Replica {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Replica{
+		masterHost:    masterHost,
+		masterPort:    masterPort,
+		listeningPort: listeningPort,
+		db:            db,
+		masterConn:    NewMasterConnection(net.JoinHostPort(masterHost, strconv.Itoa(masterPort))),
+		offset:        -1,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	r.state.Store(LinkStateConnect)
+	return r
+}
+
+// Start 启动复制，连接断开后会按照指数退避自动重连
+func (r *Replica) Start() {
+	go r.replicationLoop()
+}
+
+// Stop 停止复制并断开与master的连接
+func (r *Replica) Stop() {
+	r.cancel()
+	r.connLock.Lock()
+	if r.conn != nil {
+		_ = r.conn.Close()
+	}
+	r.connLock.Unlock()
+}
+
+func (r *Replica) MasterHost() string {
+	return r.masterHost
+}
+
+func (r *Replica) MasterPort() int {
+	return r.masterPort
+}
+
+func (r *Replica) MasterAddr() string {
+	return r.masterConn.RemoteAddr()
+}
+
+func (r *Replica) State() string {
+	return r.state.Load().(string)
+}
+
+// Offset 从节点的复制偏移量
+func (r *Replica) Offset() int64 {
+	return atomic.LoadInt64(&r.offset)
+}
+
+// LastIOSecondsAgo 距离最近一次收到master数据的秒数
+func (r *Replica) LastIOSecondsAgo() int {
+	last := atomic.LoadInt64(&r.lastIO)
+	if last == 0 {
+		return -1
+	}
+	return int(time.Now().Unix() - last)
+}
+
+func (r *Replica) replicationLoop() {
+	backoff := time.Second
+	for {
+		err := r.syncWithMaster()
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.state.Store(LinkStateConnect)
+		log.Errorf("replication link with master %s broken: %v, retry in %v", r.MasterAddr(), err, backoff)
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > replicaMaxBackoff {
+			backoff = replicaMaxBackoff
+		}
+	}
+}
+
+func (r *Replica) syncWithMaster() error {
+	r.state.Store(LinkStateConnecting)
+	conn, err := net.DialTimeout("tcp", r.MasterAddr(), replicaDialTimeout)
+	if err != nil {
+		return err
+	}
+	r.connLock.Lock()
+	r.conn = conn
+	r.connLock.Unlock()
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	if err := r.handshake(conn, reader); err != nil {
+		return err
+	}
+	if err := r.psync(conn, reader); err != nil {
+		return err
+	}
+	r.state.Store(LinkStateConnected)
+	log.Info("replication with master %s connected, offset: %d", r.MasterAddr(), r.Offset())
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	go r.ackLoop(ctx, conn)
+	return r.receiveCommands(conn, reader)
+}
+
+// handshake 发送 PING 和 REPLCONF，检查master是否可用
+func (r *Replica) handshake(conn net.Conn, reader *bufio.Reader) error {
+	if _, err := r.request(conn, reader, "PING"); err != nil {
+		return err
+	}
+	if _, err := r.request(conn, reader, "REPLCONF", "listening-port", strconv.Itoa(r.listeningPort)); err != nil {
+		return err
+	}
+	_, err := r.request(conn, reader, "REPLCONF", "capa", "psync2")
+	return err
+}
+
+// psync 发送 PSYNC 请求，master返回 FULLRESYNC 时接收并加载RDB，返回 CONTINUE 时直接接收增量命令
+func (r *Replica) psync(conn net.Conn, reader *bufio.Reader) error {
+	replID, offset := "?", "-1"
+	if r.replID != "" {
+		replID, offset = r.replID, strconv.FormatInt(r.Offset()+1, 10)
+	}
+	reply, err := r.request(conn, reader, "PSYNC", replID, offset)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(reply.Parts()[0]))
+	switch strings.ToUpper(fields[0]) {
+	case "FULLRESYNC":
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid FULLRESYNC reply: %s", reply.Parts()[0])
+		}
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC offset: %s", fields[2])
+		}
+		r.state.Store(LinkStateSync)
+		if err := r.receiveRDB(conn, reader); err != nil {
+			return err
+		}
+		r.replID = fields[1]
+		atomic.StoreInt64(&r.offset, masterOffset)
+		log.Info("full resync with master %s finished, replid: %s, offset: %d", r.MasterAddr(), r.replID, masterOffset)
+	case "CONTINUE":
+		// master更换了replid，继续使用新的replid
+		if len(fields) == 2 {
+			r.replID = fields[1]
+		}
+		log.Info("partial resync with master %s accepted, offset: %d", r.MasterAddr(), r.Offset())
+	default:
+		return fmt.Errorf("unexpected PSYNC reply: %s", reply.Parts()[0])
+	}
+	return nil
+}
+
+// receiveRDB 接收 $len\r\n 开头的RDB数据，并提交到executor加载
+func (r *Replica) receiveRDB(conn net.Conn, reader *bufio.Reader) error {
+	_ = conn.SetReadDeadline(time.Now().Add(replicaReadDeadline))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 || line[0] != redis.BulkPrefix {
+		return fmt.Errorf("invalid rdb bulk header: %s", string(line))
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+	if err != nil {
+		return fmt.Errorf("invalid rdb bulk length: %s", string(line))
+	}
+	rdb := make([]byte, size)
+	if _, err := io.ReadFull(reader, rdb); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&r.lastIO, time.Now().Unix())
+	r.submit(redis.NewCommand([][]byte{[]byte(LoadRDBCommand), rdb}))
+	return nil
+}
+
+// receiveCommands 持续接收master传播的命令流，直到连接断开
+func (r *Replica) receiveCommands(conn net.Conn, reader *bufio.Reader) error {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(replicaReadDeadline))
+		cmd, err := redis.Decode(redis.NewBlockingReader(reader))
+		if err != nil {
+			return err
+		}
+		if cmd.Type() != redis.CommandTypeArray {
+			continue
+		}
+		atomic.StoreInt64(&r.lastIO, time.Now().Unix())
+		// 复制偏移量按照命令的RESP长度计算
+		size := int64(len(redis.Encode(cmd)))
+		if cmd.Name() != "ping" {
+			r.submit(cmd)
+		}
+		atomic.AddInt64(&r.offset, size)
+	}
+}
+
+// ackLoop 每秒向master上报当前的复制偏移量
+func (r *Replica) ackLoop(ctx context.Context, conn net.Conn) {
+	ticker := time.NewTicker(replicaAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ack := redis.NewStringArrayCommand([]string{"REPLCONF", "ACK", strconv.FormatInt(r.Offset(), 10)})
+			if _, err := conn.Write(redis.Encode(ack)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (r *Replica) submit(cmd *redis.RespCommand) {
+	cmd.BindConnection(r.masterConn)
+	r.db.SubmitCommand(cmd)
+}
+
+// request 发送一条命令并等待master的回复，master返回错误时转换为error
+func (r *Replica) request(conn net.Conn, reader *bufio.Reader, args ...string) (*redis.RespCommand, error) {
+	_ = conn.SetDeadline(time.Now().Add(replicaDialTimeout))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	if _, err := conn.Write(redis.Encode(redis.NewStringArrayCommand(args))); err != nil {
+		return nil, err
+	}
+	reply, err := redis.Decode(redis.NewBlockingReader(reader))
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type() == redis.CommandTypeError {
+		return nil, errors.New(strings.TrimSpace(string(redis.Encode(reply))))
+	}
+	return reply, nil
+}
@@ -0,0 +1,305 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"redigo/pkg/config"
+	"redigo/pkg/redis"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type commandExecutor func(s *Sentinel, command redis.Command) *redis.RespCommand
+
+var (
+	executors         = make(map[string]commandExecutor)
+	sentinelExecutors = make(map[string]commandExecutor)
+)
+
+func init() {
+	executors["ping"] = execPing
+	executors["info"] = execInfo
+	executors["role"] = execRole
+	executors["subscribe"] = execSubscribe
+	executors["psubscribe"] = execPSubscribe
+	executors["sentinel"] = execSentinel
+
+	sentinelExecutors["myid"] = execMyID
+	sentinelExecutors["masters"] = execMasters
+	sentinelExecutors["master"] = execMaster
+	sentinelExecutors["replicas"] = execReplicas
+	sentinelExecutors["slaves"] = execReplicas
+	sentinelExecutors["sentinels"] = execSentinels
+	sentinelExecutors["get-master-addr-by-name"] = execGetMasterAddrByName
+	sentinelExecutors["is-master-down-by-addr"] = execIsMasterDownByAddr
+	sentinelExecutors["failover"] = execFailover
+}
+
+func execPing(_ *Sentinel, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) == 1 {
+		return redis.NewBulkStringCommand(command.Args()[0])
+	}
+	return redis.NewSingleLineCommand([]byte("PONG"))
+}
+
+func execSubscribe(s *Sentinel, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) == 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("subscribe"))
+	}
+	s.hub.Subscribe(command.Connection(), command.Args())
+	return nil
+}
+
+func execPSubscribe(s *Sentinel, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) == 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("psubscribe"))
+	}
+	patterns := make([]string, len(command.Args()))
+	for i, arg := range command.Args() {
+		patterns[i] = string(arg)
+	}
+	s.hub.PSubscribe(command.Connection(), patterns)
+	return nil
+}
+
+// execSentinel SENTINEL <subcommand> [arguments...]
+func execSentinel(s *Sentinel, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) == 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sentinel"))
+	}
+	executor, ok := sentinelExecutors[strings.ToLower(string(args[0]))]
+	if !ok {
+		return redis.NewErrorCommand(fmt.Errorf("ERR Unknown sentinel subcommand '%s'", string(args[0])))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return executor(s, redis.NewCommand(args))
+}
+
+func execMyID(s *Sentinel, _ redis.Command) *redis.RespCommand {
+	return redis.NewBulkStringCommand([]byte(s.runID))
+}
+
+// sortedMasters 按名称排序的master列表
+func (s *Sentinel) sortedMasters() []*masterInstance {
+	masters := make([]*masterInstance, 0, len(s.masters))
+	for _, m := range s.masters {
+		masters = append(masters, m)
+	}
+	sort.Slice(masters, func(i, j int) bool {
+		return masters[i].name < masters[j].name
+	})
+	return masters
+}
+
+func masterFields(m *masterInstance) *redis.RespCommand {
+	host, port := m.ipPort()
+	return redis.NewStringArrayCommand([]string{
+		"name", m.name,
+		"ip", host,
+		"port", port,
+		"flags", m.masterFlags(),
+		"role-reported", m.role,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", failoverStateNames[m.failoverState],
+	})
+}
+
+func execMasters(s *Sentinel, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) != 0 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sentinel masters"))
+	}
+	masters := s.sortedMasters()
+	parts := make([][]byte, len(masters))
+	for i, m := range masters {
+		parts[i] = redis.Encode(masterFields(m))
+	}
+	return redis.NewNestedArrayCommand(parts)
+}
+
+// lookupMaster 解析 SENTINEL <subcommand> <master-name> 中的master
+func (s *Sentinel) lookupMaster(command redis.Command, name string) (*masterInstance, *redis.RespCommand) {
+	if len(command.Args()) != 1 {
+		return nil, redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sentinel " + name))
+	}
+	m, ok := s.masters[string(command.Args()[0])]
+	if !ok {
+		return nil, redis.NewErrorCommand(redis.NoSuchMasterError)
+	}
+	return m, nil
+}
+
+func execMaster(s *Sentinel, command redis.Command) *redis.RespCommand {
+	m, errReply := s.lookupMaster(command, "master")
+	if errReply != nil {
+		return errReply
+	}
+	return masterFields(m)
+}
+
+func execReplicas(s *Sentinel, command redis.Command) *redis.RespCommand {
+	m, errReply := s.lookupMaster(command, "replicas")
+	if errReply != nil {
+		return errReply
+	}
+	addrs := make([]string, 0, len(m.replicas))
+	for addr := range m.replicas {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	parts := make([][]byte, len(addrs))
+	for i, addr := range addrs {
+		replica := m.replicas[addr]
+		host, port, _ := net.SplitHostPort(addr)
+		linkStatus := "err"
+		if replica.masterLinkUp {
+			linkStatus = "ok"
+		}
+		parts[i] = redis.Encode(redis.NewStringArrayCommand([]string{
+			"name", addr,
+			"ip", host,
+			"port", port,
+			"flags", replica.flags("slave"),
+			"role-reported", replica.role,
+			"master-host", replica.masterHost,
+			"master-port", strconv.Itoa(replica.masterPort),
+			"master-link-status", linkStatus,
+			"slave-repl-offset", strconv.FormatInt(replica.replOffset, 10),
+		}))
+	}
+	return redis.NewNestedArrayCommand(parts)
+}
+
+func execSentinels(s *Sentinel, command redis.Command) *redis.RespCommand {
+	m, errReply := s.lookupMaster(command, "sentinels")
+	if errReply != nil {
+		return errReply
+	}
+	parts := make([][]byte, 0, len(m.sentinels))
+	for _, peer := range m.sentinels {
+		host, port, _ := net.SplitHostPort(peer.addr)
+		flags := "sentinel"
+		if peer.sdown {
+			flags += ",s_down"
+		}
+		parts = append(parts, redis.Encode(redis.NewStringArrayCommand([]string{
+			"name", peer.runID,
+			"ip", host,
+			"port", port,
+			"runid", peer.runID,
+			"flags", flags,
+			"leader", peer.leader,
+			"leader-epoch", strconv.FormatInt(peer.leaderEpoch, 10),
+		})))
+	}
+	return redis.NewNestedArrayCommand(parts)
+}
+
+// execGetMasterAddrByName SENTINEL get-master-addr-by-name <master-name>，返回当前master的 ip 和 port
+func execGetMasterAddrByName(s *Sentinel, command redis.Command) *redis.RespCommand {
+	if len(command.Args()) != 1 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sentinel get-master-addr-by-name"))
+	}
+	m, ok := s.masters[string(command.Args()[0])]
+	if !ok {
+		return redis.NilCommand
+	}
+	host, port := m.ipPort()
+	return redis.NewStringArrayCommand([]string{host, port})
+}
+
+// execIsMasterDownByAddr SENTINEL is-master-down-by-addr <ip> <port> <current-epoch> <runid>
+// runid 为 * 时只询问master的状态，否则请求在 current-epoch 中为 runid 投票。
+// 回复：<down-state> <leader-runid> <leader-epoch>
+func execIsMasterDownByAddr(s *Sentinel, command redis.Command) *redis.RespCommand {
+	args := command.Args()
+	if len(args) != 4 {
+		return redis.NewErrorCommand(redis.CreateWrongArgumentNumberError("sentinel is-master-down-by-addr"))
+	}
+	epoch, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return redis.NewErrorCommand(redis.ValueNotIntegerOrOutOfRangeError)
+	}
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	var m *masterInstance
+	for _, master := range s.masters {
+		if master.addr == addr {
+			m = master
+			break
+		}
+	}
+	down, leader, leaderEpoch := 0, "*", int64(0)
+	if m != nil {
+		if m.sdown && m.role == "master" {
+			down = 1
+		}
+		if runID := string(args[3]); runID != "*" {
+			leader, leaderEpoch = s.voteLeader(m, runID, epoch)
+		}
+	}
+	return redis.NewNestedArrayCommand([][]byte{
+		redis.Encode(redis.NewNumberCommand(down)),
+		redis.Encode(redis.NewBulkStringCommand([]byte(leader))),
+		redis.Encode(redis.NewNumberCommand(int(leaderEpoch))),
+	})
+}
+
+// execFailover SENTINEL failover <master-name>，不需要其他sentinel同意，强制进行故障转移
+func execFailover(s *Sentinel, command redis.Command) *redis.RespCommand {
+	m, errReply := s.lookupMaster(command, "failover")
+	if errReply != nil {
+		return errReply
+	}
+	if m.failoverState != failoverNone {
+		return redis.NewErrorCommand(redis.FailoverInProgressError)
+	}
+	if s.selectReplica(m) == nil {
+		return redis.NewErrorCommand(redis.NoGoodReplicaError)
+	}
+	s.startFailover(m)
+	m.failoverState = failoverSelectReplica
+	return redis.OKCommand
+}
+
+func execRole(s *Sentinel, _ redis.Command) *redis.RespCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	masters := s.sortedMasters()
+	names := make([]string, len(masters))
+	for i, m := range masters {
+		names[i] = m.name
+	}
+	return redis.NewNestedArrayCommand([][]byte{
+		redis.Encode(redis.NewBulkStringCommand([]byte("sentinel"))),
+		redis.Encode(redis.NewStringArrayCommand(names)),
+	})
+}
+
+func execInfo(s *Sentinel, _ redis.Command) *redis.RespCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	builder := strings.Builder{}
+	builder.WriteString("# Server" + redis.CRLF)
+	builder.WriteString("redis_mode:sentinel" + redis.CRLF)
+	builder.WriteString("os:" + runtime.GOOS + " " + runtime.GOARCH + redis.CRLF)
+	builder.WriteString("process_id:" + strconv.Itoa(os.Getpid()) + redis.CRLF)
+	builder.WriteString("run_id:" + s.runID + redis.CRLF)
+	builder.WriteString("tcp_address:" + config.Properties.Address + redis.CRLF)
+	builder.WriteString(redis.CRLF + "# Sentinel" + redis.CRLF)
+	builder.WriteString("sentinel_masters:" + strconv.Itoa(len(s.masters)) + redis.CRLF)
+	builder.WriteString("sentinel_current_epoch:" + strconv.FormatInt(s.currentEpoch, 10) + redis.CRLF)
+	for i, m := range s.sortedMasters() {
+		builder.WriteString(fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			i, m.name, m.status(), m.addr, len(m.replicas), len(m.sentinels)+1) + redis.CRLF)
+	}
+	return redis.NewBulkStringCommand([]byte(builder.String()))
+}
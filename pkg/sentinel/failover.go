@@ -0,0 +1,254 @@
+package sentinel
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxFailoverDelay 发起故障转移前的最大随机等待时间
+const maxFailoverDelay = 3 * monitorInterval
+
+// askSentinels master主观下线后，询问其他sentinel是否也认为master已经下线。
+// 处于选举阶段时请求中带上自己的run id，请求对方为自己投票
+func (s *Sentinel) askSentinels(m *masterInstance) {
+	s.mutex.Lock()
+	if !m.sdown {
+		s.mutex.Unlock()
+		return
+	}
+	host, port := m.ipPort()
+	runID, epoch := "*", s.currentEpoch
+	if m.failoverState == failoverWaitStart {
+		runID, epoch = s.runID, m.failoverEpoch
+	}
+	peers := make([]*sentinelPeer, 0, len(m.sentinels))
+	for _, peer := range m.sentinels {
+		peers = append(peers, peer)
+	}
+	s.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *sentinelPeer) {
+			defer wg.Done()
+			reply, err := peer.link.request(requestTimeout, "SENTINEL", "is-master-down-by-addr",
+				host, port, strconv.FormatInt(epoch, 10), runID)
+			if err != nil || len(reply.Parts()) != 3 {
+				return
+			}
+			parts := reply.Parts()
+			leaderEpoch, err := strconv.ParseInt(string(parts[2]), 10, 64)
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			peer.masterDown = string(parts[0]) == "1"
+			peer.replyTime = time.Now()
+			if leader := string(parts[1]); leader != "*" {
+				peer.leader, peer.leaderEpoch = leader, leaderEpoch
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// checkObjectivelyDown 认为master下线的sentinel数量达到quorum时，判定master客观下线
+func (s *Sentinel) checkObjectivelyDown(m *masterInstance) {
+	down := false
+	if m.sdown {
+		count := 1
+		for _, peer := range m.sentinels {
+			// 只统计最近的回复
+			if peer.masterDown && time.Since(peer.replyTime) < 5*monitorInterval {
+				count++
+			}
+		}
+		down = count >= m.quorum
+	}
+	if down && !m.odown {
+		m.odownSince, m.failoverDelay = time.Now(), randomFailoverDelay()
+		s.publishEvent("+odown", m.event("master", m.addr)+" #quorum "+strconv.Itoa(m.quorum))
+	} else if !down && m.odown {
+		s.publishEvent("-odown", m.event("master", m.addr))
+	}
+	m.odown = down
+}
+
+// voteLeader 在 epoch 中为 runID 投票，每个纪元只投一次票，返回本sentinel在该纪元投票选出的leader
+func (s *Sentinel) voteLeader(m *masterInstance, runID string, epoch int64) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.publishEvent("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, s.currentEpoch
+		s.publishEvent("+vote-for-leader", runID+" "+strconv.FormatInt(epoch, 10))
+		// 已经为其他sentinel投票，推迟自己发起故障转移
+		if runID != s.runID {
+			m.lastFailover = time.Now()
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader 统计 failoverEpoch 中的投票结果，得票数达到 max(quorum, 多数派) 的sentinel成为leader
+func (s *Sentinel) electedLeader(m *masterInstance) string {
+	votes := make(map[string]int)
+	if m.leaderEpoch == m.failoverEpoch && m.leader != "" {
+		votes[m.leader]++
+	}
+	for _, peer := range m.sentinels {
+		if peer.leaderEpoch == m.failoverEpoch && peer.leader != "" {
+			votes[peer.leader]++
+		}
+	}
+	needed := (len(m.sentinels)+1)/2 + 1
+	if m.quorum > needed {
+		needed = m.quorum
+	}
+	for runID, count := range votes {
+		if count >= needed {
+			return runID
+		}
+	}
+	return ""
+}
+
+// startFailover 进入新的纪元并为自己投票，开始故障转移
+func (s *Sentinel) startFailover(m *masterInstance) {
+	s.currentEpoch++
+	m.failoverEpoch = s.currentEpoch
+	m.failoverState = failoverWaitStart
+	m.failoverStart = time.Now()
+	m.lastFailover = m.failoverStart
+	s.publishEvent("+new-epoch", strconv.FormatInt(s.currentEpoch, 10))
+	s.publishEvent("+try-failover", m.event("master", m.addr))
+	s.voteLeader(m, s.runID, m.failoverEpoch)
+}
+
+func (s *Sentinel) abortFailover(m *masterInstance, reason string) {
+	s.publishEvent(reason, m.event("master", m.addr))
+	m.failoverState = failoverNone
+	m.promoted = nil
+	m.failoverDelay = randomFailoverDelay()
+}
+
+func randomFailoverDelay() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxFailoverDelay)))
+}
+
+// failoverStep 推进故障转移的状态机，需要向从节点发送命令时返回 pending，由调用者在释放锁之后执行
+func (s *Sentinel) failoverStep(m *masterInstance) (pending func()) {
+	if m.failoverState != failoverNone && time.Since(m.failoverStart) > m.failoverTimeout {
+		s.abortFailover(m, "-failover-abort-timeout")
+		return nil
+	}
+	switch m.failoverState {
+	case failoverNone:
+		if m.odown && time.Since(m.odownSince) >= m.failoverDelay &&
+			time.Since(m.lastFailover) > 2*m.failoverTimeout+m.failoverDelay {
+			s.startFailover(m)
+		}
+	case failoverWaitStart:
+		leader := s.electedLeader(m)
+		if leader != s.runID {
+			return nil
+		}
+		s.publishEvent("+elected-leader", m.event("master", m.addr))
+		m.failoverState = failoverSelectReplica
+		return s.failoverStep(m)
+	case failoverSelectReplica:
+		replica := s.selectReplica(m)
+		if replica == nil {
+			s.abortFailover(m, "-failover-abort-no-good-slave")
+			return nil
+		}
+		s.publishEvent("+selected-slave", m.event("slave", replica.addr))
+		epoch := m.failoverEpoch
+		return func() {
+			_, err := replica.link.request(requestTimeout, "REPLICAOF", "NO", "ONE")
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			// 发送失败时下一次检查重新选择，发送期间故障转移可能已经终止
+			if err != nil || m.failoverState != failoverSelectReplica || m.failoverEpoch != epoch {
+				return
+			}
+			m.promoted = replica
+			m.failoverState = failoverWaitPromotion
+			s.publishEvent("+failover-state-wait-promotion", m.event("slave", replica.addr))
+		}
+	case failoverWaitPromotion:
+		if m.promoted.role != "master" {
+			return nil
+		}
+		promoted := m.promoted
+		host, port, _ := net.SplitHostPort(promoted.addr)
+		var requests []replicaOfRequest
+		for _, replica := range m.replicas {
+			if replica != promoted && !replica.sdown {
+				requests = append(requests, replicaOfRequest{replica: replica, host: host, port: port})
+			}
+		}
+		return func() {
+			errs := sendReplicaOf(requests)
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			if m.failoverState != failoverWaitPromotion || m.promoted != promoted {
+				return
+			}
+			s.publishEvent("+promoted-slave", m.event("slave", promoted.addr))
+			for i, request := range requests {
+				if errs[i] == nil {
+					s.publishEvent("+slave-reconf-sent", m.event("slave", request.replica.addr))
+				}
+			}
+			// 未能重新配置的从节点以及旧master，会在它们可用后由 refreshReplicas 修正
+			m.configEpoch = m.failoverEpoch
+			s.switchMaster(m, promoted.addr)
+			s.publishEvent("+failover-end", m.event("master", m.addr))
+		}
+	}
+	return nil
+}
+
+// replicaOfRequest 需要在释放锁之后发送给从节点的 REPLICAOF host port
+type replicaOfRequest struct {
+	replica *instance
+	host    string
+	port    string
+}
+
+// sendReplicaOf 并发地发送 REPLICAOF 命令，返回每个请求的错误，调用时不能持有 s.mutex
+func sendReplicaOf(requests []replicaOfRequest) []error {
+	errs := make([]error, len(requests))
+	wg := sync.WaitGroup{}
+	for i, request := range requests {
+		wg.Add(1)
+		go func(i int, request replicaOfRequest) {
+			defer wg.Done()
+			_, errs[i] = request.replica.link.request(requestTimeout, "REPLICAOF", request.host, request.port)
+		}(i, request)
+	}
+	wg.Wait()
+	return errs
+}
+
+// selectReplica 选择可用并且复制偏移量最大的从节点
+func (s *Sentinel) selectReplica(m *masterInstance) *instance {
+	var best *instance
+	for _, replica := range m.replicas {
+		if replica.sdown || replica.role != "slave" || time.Since(replica.lastReply) > 5*monitorInterval {
+			continue
+		}
+		if best == nil || replica.replOffset > best.replOffset ||
+			(replica.replOffset == best.replOffset && replica.addr < best.addr) {
+			best = replica
+		}
+	}
+	return best
+}
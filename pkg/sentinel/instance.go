@@ -0,0 +1,172 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 故障转移的状态
+const (
+	failoverNone          = iota
+	failoverWaitStart     // 等待选举出leader
+	failoverSelectReplica // 选择需要提升的从节点
+	failoverWaitPromotion // 等待从节点提升为master
+)
+
+var failoverStateNames = []string{"none", "wait_start", "select_slave", "wait_promotion"}
+
+// instance 被监控的数据节点，master和replica共用
+type instance struct {
+	addr         string
+	link         *link
+	lastReply    time.Time // lastReply 最近一次收到有效回复的时间，超过 down-after 没有回复判定为主观下线
+	sdown        bool
+	role         string // 以下字段来自 INFO replication
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	replicaAddrs []string
+	done         chan struct{} // done 关闭后停止hello频道的订阅
+}
+
+func newInstance(addr string) *instance {
+	return &instance{
+		addr:      addr,
+		link:      newLink(addr),
+		lastReply: time.Now(),
+		done:      make(chan struct{}),
+	}
+}
+
+func (i *instance) close() {
+	close(i.done)
+	i.link.close()
+}
+
+func (i *instance) masterAddr() string {
+	return net.JoinHostPort(i.masterHost, strconv.Itoa(i.masterPort))
+}
+
+// parseInfo 解析 INFO replication 的回复，更新实例的角色和复制信息
+func (i *instance) parseInfo(info string) {
+	i.replicaAddrs = i.replicaAddrs[:0]
+	for _, line := range strings.Split(info, "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "role":
+			i.role = value
+		case key == "master_host":
+			i.masterHost = value
+		case key == "master_port":
+			i.masterPort, _ = strconv.Atoi(value)
+		case key == "master_link_status":
+			i.masterLinkUp = value == "up"
+		case key == "slave_repl_offset":
+			i.replOffset, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "slave") && strings.Contains(value, "ip="):
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=100,lag=0
+			var ip, port string
+			for _, field := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(field, "=")
+				if k == "ip" {
+					ip = v
+				} else if k == "port" {
+					port = v
+				}
+			}
+			if ip != "" && port != "" {
+				i.replicaAddrs = append(i.replicaAddrs, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+}
+
+func (i *instance) flags(kind string) string {
+	if i.sdown {
+		return kind + ",s_down"
+	}
+	return kind
+}
+
+// sentinelPeer 监控同一个master的其他sentinel，通过hello消息发现
+type sentinelPeer struct {
+	runID       string
+	addr        string
+	link        *link
+	lastReply   time.Time
+	sdown       bool
+	masterDown  bool      // masterDown 对方是否认为master已经下线
+	replyTime   time.Time // replyTime 最近一次 is-master-down-by-addr 回复的时间
+	leader      string    // leader 对方在 leaderEpoch 中投票选出的leader
+	leaderEpoch int64
+}
+
+func newSentinelPeer(runID string, addr string) *sentinelPeer {
+	return &sentinelPeer{runID: runID, addr: addr, link: newLink(addr), lastReply: time.Now()}
+}
+
+// masterInstance 被监控的master，包括它的从节点、其他sentinel以及故障转移的状态
+type masterInstance struct {
+	*instance
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	configEpoch     int64 // configEpoch 当前master配置的纪元，故障转移成功后更新为故障转移的纪元
+	odown           bool
+	odownSince      time.Time
+	failoverDelay   time.Duration // failoverDelay 客观下线后随机等待一段时间再发起故障转移，避免多个sentinel同时发起选举导致选票分散
+	replicas        map[string]*instance
+	sentinels       map[string]*sentinelPeer
+	leader          string // leader 本sentinel在 leaderEpoch 中投票选出的leader
+	leaderEpoch     int64
+	failoverState   int
+	failoverEpoch   int64
+	failoverStart   time.Time
+	lastFailover    time.Time // lastFailover 上一次尝试故障转移的时间，两次尝试之间至少间隔 2*failoverTimeout
+	promoted        *instance
+}
+
+func (m *masterInstance) ipPort() (string, string) {
+	host, port, _ := net.SplitHostPort(m.addr)
+	return host, port
+}
+
+func (m *masterInstance) masterFlags() string {
+	flags := m.flags("master")
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failoverState != failoverNone {
+		flags += ",failover_in_progress"
+	}
+	return flags
+}
+
+// status INFO sentinel 中显示的master状态
+func (m *masterInstance) status() string {
+	if m.odown {
+		return "odown"
+	}
+	if m.sdown {
+		return "sdown"
+	}
+	return "ok"
+}
+
+// event 事件消息中的实例描述，格式：<type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>
+func (m *masterInstance) event(kind string, addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	masterHost, masterPort := m.ipPort()
+	if kind == "master" {
+		return fmt.Sprintf("master %s %s %s", m.name, host, port)
+	}
+	return fmt.Sprintf("%s %s %s %s @ %s %s %s", kind, addr, host, port, m.name, masterHost, masterPort)
+}
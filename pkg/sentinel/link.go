@@ -0,0 +1,107 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"redigo/pkg/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+// link sentinel与被监控实例、其他sentinel之间的命令连接，连接断开后在下一次请求时重新建立
+type link struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+func newLink(addr string) *link {
+	return &link{addr: addr}
+}
+
+// request 发送一条命令并等待回复，对方返回错误回复时同时返回error
+func (l *link) request(timeout time.Duration, args ...string) (*redis.RespCommand, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		l.conn = conn
+		l.reader = bufio.NewReader(conn)
+	}
+	_ = l.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := l.conn.Write(redis.Encode(redis.NewStringArrayCommand(args))); err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	reply, err := redis.Decode(redis.NewBlockingReader(l.reader))
+	if err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	if reply.Type() == redis.CommandTypeError {
+		return reply, errors.New(strings.TrimSpace(string(redis.Encode(reply))[1:]))
+	}
+	return reply, nil
+}
+
+// localIP 连接使用的本地ip，还没有建立连接时返回空字符串
+func (l *link) localIP() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		return ""
+	}
+	host, _, _ := net.SplitHostPort(l.conn.LocalAddr().String())
+	return host
+}
+
+func (l *link) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closeLocked()
+}
+
+func (l *link) closeLocked() {
+	if l.conn != nil {
+		_ = l.conn.Close()
+		l.conn = nil
+		l.reader = nil
+	}
+}
+
+// subscribe 订阅实例上的频道，收到的每条消息都交给 onMessage 处理，直到连接断开或者 done 关闭
+func subscribe(addr string, channel string, done <-chan struct{}, onMessage func(message string)) error {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		_ = conn.Close()
+	}()
+	if _, err := conn.Write(redis.Encode(redis.NewStringArrayCommand([]string{"SUBSCRIBE", channel}))); err != nil {
+		return err
+	}
+	reader := redis.NewBlockingReader(bufio.NewReader(conn))
+	for {
+		reply, err := redis.Decode(reader)
+		if err != nil {
+			return err
+		}
+		parts := reply.Parts()
+		if len(parts) == 3 && string(parts[0]) == "message" {
+			onMessage(string(parts[2]))
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"redigo/pkg/config"
+	"redigo/pkg/interface/database"
+	"redigo/pkg/pubsub"
+	"redigo/pkg/redis"
+	"redigo/pkg/util/log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	helloChannel    = "__sentinel__:hello"
+	monitorInterval = 1 * time.Second
+	helloInterval   = 2 * time.Second
+	requestTimeout  = 500 * time.Millisecond
+
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 180 * time.Second
+)
+
+/*
+Sentinel 哨兵模式的服务器，监控master及其从节点，在master客观下线后选举leader并完成故障转移。
+Sentinel 不保存数据，只处理 SENTINEL、PING、INFO、ROLE 和订阅相关的命令，
+客户端可以订阅 +sdown、+odown、+switch-master 等频道获取事件通知。
+*/
+type Sentinel struct {
+	runID        string
+	ip           string // ip hello消息中宣告的ip，为空时使用连接的本地ip
+	port         int    // port hello消息中宣告的端口
+	currentEpoch int64
+	masters      map[string]*masterInstance
+	hub          *pubsub.Hub
+	mutex        sync.Mutex
+	lastHello    time.Time
+	closeChan    chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewSentinel(address string, props config.SentinelProperties) *Sentinel {
+	_, portStr, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portStr)
+	if props.AnnouncePort > 0 {
+		port = props.AnnouncePort
+	}
+	s := &Sentinel{
+		runID:     newRunID(),
+		ip:        props.AnnounceIP,
+		port:      port,
+		masters:   make(map[string]*masterInstance),
+		hub:       pubsub.MakeHub(),
+		closeChan: make(chan struct{}),
+	}
+	for _, master := range props.Masters {
+		m := &masterInstance{
+			instance:        newInstance(master.Address),
+			name:            master.Name,
+			quorum:          master.Quorum,
+			downAfter:       time.Duration(master.DownAfterMilliseconds) * time.Millisecond,
+			failoverTimeout: time.Duration(master.FailoverTimeout) * time.Millisecond,
+			replicas:        make(map[string]*instance),
+			sentinels:       make(map[string]*sentinelPeer),
+		}
+		if m.quorum <= 0 {
+			m.quorum = 1
+		}
+		if m.downAfter <= 0 {
+			m.downAfter = defaultDownAfter
+		}
+		if m.failoverTimeout <= 0 {
+			m.failoverTimeout = defaultFailoverTimeout
+		}
+		s.masters[m.name] = m
+	}
+	return s
+}
+
+func newRunID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (s *Sentinel) SubmitCommand(command redis.Command) {
+	reply := s.Execute(command)
+	if reply != nil {
+		command.Connection().SendCommand(reply)
+	}
+}
+
+func (s *Sentinel) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, m := range s.masters {
+			m.close()
+			for _, replica := range m.replicas {
+				replica.close()
+			}
+			for _, peer := range m.sentinels {
+				peer.link.close()
+			}
+		}
+	})
+}
+
+// ExecuteLoop 哨兵的监控循环，每秒检查一次所有被监控的实例
+func (s *Sentinel) ExecuteLoop() error {
+	log.Info("sentinel started, run id: %s", s.runID)
+	s.mutex.Lock()
+	for _, m := range s.masters {
+		s.watchHello(m.instance)
+	}
+	s.mutex.Unlock()
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeChan:
+			return nil
+		case <-ticker.C:
+			s.monitor()
+		}
+	}
+}
+
+func (s *Sentinel) Execute(command redis.Command) *redis.RespCommand {
+	if executor, ok := executors[command.Name()]; ok {
+		return executor(s, command)
+	}
+	return redis.NewErrorCommand(redis.CreateUnknownCommandError(command.Name()))
+}
+
+func (s *Sentinel) ForEach(dbIdx int, fun func(key string, entry *database.Entry, expire *time.Time) bool) {
+	panic("foreach is not available in sentinel mode")
+}
+
+func (s *Sentinel) Len(dbIdx int) int {
+	panic("len is not available in sentinel mode")
+}
+
+func (s *Sentinel) OnConnectionClosed(conn redis.Connection) {
+	s.hub.UnSubscribeAll(conn)
+}
+
+// publishEvent 向订阅了 channel 的客户端发送事件
+func (s *Sentinel) publishEvent(channel string, message string) {
+	log.Info("sentinel event %s %s", channel, message)
+	s.hub.Publish(channel, []byte(message))
+}
+
+// monitor 并发地检查每个master
+func (s *Sentinel) monitor() {
+	s.mutex.Lock()
+	sendHello := time.Since(s.lastHello) >= helloInterval
+	if sendHello {
+		s.lastHello = time.Now()
+	}
+	masters := make([]*masterInstance, 0, len(s.masters))
+	for _, m := range s.masters {
+		masters = append(masters, m)
+	}
+	s.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, m := range masters {
+		wg.Add(1)
+		go func(m *masterInstance) {
+			defer wg.Done()
+			s.monitorMaster(m, sendHello)
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (s *Sentinel) monitorMaster(m *masterInstance, sendHello bool) {
+	s.pingInstances(m, sendHello)
+
+	s.mutex.Lock()
+	fixes := s.refreshReplicas(m)
+	s.checkSubjectivelyDown(m)
+	s.mutex.Unlock()
+	sendReplicaOf(fixes)
+
+	s.askSentinels(m)
+
+	s.mutex.Lock()
+	s.checkObjectivelyDown(m)
+	pending := s.failoverStep(m)
+	s.mutex.Unlock()
+	// 故障转移中的网络请求在释放锁之后进行，避免阻塞其他master的检查和客户端命令
+	if pending != nil {
+		pending()
+	}
+}
+
+// pingInstances 向master和从节点发送 INFO replication，向其他sentinel发送 PING，需要时在数据节点上发布hello消息
+func (s *Sentinel) pingInstances(m *masterInstance, sendHello bool) {
+	s.mutex.Lock()
+	nodes := []*instance{m.instance}
+	for _, replica := range m.replicas {
+		nodes = append(nodes, replica)
+	}
+	peers := make([]*sentinelPeer, 0, len(m.sentinels))
+	for _, peer := range m.sentinels {
+		peers = append(peers, peer)
+	}
+	hello := s.helloMessage(m)
+	s.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *instance) {
+			defer wg.Done()
+			reply, err := node.link.request(requestTimeout, "INFO", "replication")
+			if ip := s.announceIP(node.link); sendHello && err == nil && ip != "" {
+				_, _ = node.link.request(requestTimeout, "PUBLISH", helloChannel, ip+","+hello)
+			}
+			if err != nil || reply.Type() != redis.CommandTypeBulk {
+				return
+			}
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			node.lastReply = time.Now()
+			node.parseInfo(string(reply.Parts()[0]))
+		}(node)
+	}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *sentinelPeer) {
+			defer wg.Done()
+			if _, err := peer.link.request(requestTimeout, "PING"); err != nil {
+				return
+			}
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			peer.lastReply = time.Now()
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// refreshReplicas 根据master的INFO发现新的从节点，返回纠正配置错误的从节点需要发送的 REPLICAOF 命令
+func (s *Sentinel) refreshReplicas(m *masterInstance) []replicaOfRequest {
+	for _, addr := range m.replicaAddrs {
+		if _, ok := m.replicas[addr]; ok || addr == m.addr {
+			continue
+		}
+		replica := newInstance(addr)
+		m.replicas[addr] = replica
+		s.watchHello(replica)
+		s.publishEvent("+slave", m.event("slave", addr))
+	}
+	// 故障转移过程中或者master不可用时，从节点的配置可能正处于变化中，不做修改
+	if m.failoverState != failoverNone || m.sdown || m.role != "master" {
+		return nil
+	}
+	host, port := m.ipPort()
+	var requests []replicaOfRequest
+	for addr, replica := range m.replicas {
+		if replica.sdown || replica.role == "" {
+			continue
+		}
+		if replica.role == "master" {
+			// 旧的master重新上线，需要转换成新master的从节点
+			s.publishEvent("+convert-to-slave", m.event("slave", addr))
+		} else if replica.masterAddr() != m.addr {
+			s.publishEvent("+fix-slave-config", m.event("slave", addr))
+		} else {
+			continue
+		}
+		requests = append(requests, replicaOfRequest{replica: replica, host: host, port: port})
+		// 等待下一次INFO刷新角色，避免重复发送
+		replica.role = ""
+	}
+	return requests
+}
+
+// checkSubjectivelyDown 超过 down-after 没有收到有效回复的实例判定为主观下线
+func (s *Sentinel) checkSubjectivelyDown(m *masterInstance) {
+	check := func(kind string, addr string, lastReply time.Time, sdown *bool) {
+		down := time.Since(lastReply) > m.downAfter
+		if down && !*sdown {
+			s.publishEvent("+sdown", m.event(kind, addr))
+		} else if !down && *sdown {
+			s.publishEvent("-sdown", m.event(kind, addr))
+		}
+		*sdown = down
+	}
+	check("master", m.addr, m.lastReply, &m.sdown)
+	for addr, replica := range m.replicas {
+		check("slave", addr, replica.lastReply, &replica.sdown)
+	}
+	for _, peer := range m.sentinels {
+		check("sentinel", peer.addr, peer.lastReply, &peer.sdown)
+	}
+}
+
+// watchHello 订阅数据节点上的hello频道，实例关闭前断线会每秒重试
+func (s *Sentinel) watchHello(node *instance) {
+	go func() {
+		for {
+			err := subscribe(node.addr, helloChannel, node.done, func(message string) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.processHello(message)
+			})
+			select {
+			case <-node.done:
+				return
+			case <-s.closeChan:
+				return
+			case <-time.After(time.Second):
+				log.Debug("sentinel subscribe hello channel on %s error: %v", node.addr, err)
+			}
+		}
+	}()
+}
+
+// helloMessage 格式：ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch，
+// 返回ip之后的部分，ip在发送时由 announceIP 决定
+func (s *Sentinel) helloMessage(m *masterInstance) string {
+	host, port := m.ipPort()
+	return strings.Join([]string{
+		strconv.Itoa(s.port), s.runID, strconv.FormatInt(s.currentEpoch, 10),
+		m.name, host, port, strconv.FormatInt(m.configEpoch, 10),
+	}, ",")
+}
+
+// announceIP 没有配置 announceIp 时，与Redis相同，宣告连接该实例使用的本地ip，
+// 避免 address 监听 0.0.0.0 时其他sentinel无法连接
+func (s *Sentinel) announceIP(l *link) string {
+	if s.ip != "" {
+		return s.ip
+	}
+	return l.localIP()
+}
+
+// processHello 处理其他sentinel的hello消息，发现新的sentinel，并接受纪元更新的master配置
+func (s *Sentinel) processHello(message string) {
+	fields := strings.Split(message, ",")
+	if len(fields) != 8 || fields[2] == s.runID {
+		return
+	}
+	m, ok := s.masters[fields[4]]
+	if !ok {
+		return
+	}
+	epoch, err1 := strconv.ParseInt(fields[3], 10, 64)
+	masterEpoch, err2 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.publishEvent("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+	runID, addr := fields[2], net.JoinHostPort(fields[0], fields[1])
+	if _, ok := m.sentinels[runID]; !ok {
+		// 相同地址的sentinel重启后会更换run id，删除旧的记录
+		for id, peer := range m.sentinels {
+			if peer.addr == addr {
+				peer.link.close()
+				delete(m.sentinels, id)
+			}
+		}
+		m.sentinels[runID] = newSentinelPeer(runID, addr)
+		s.publishEvent("+sentinel", m.event("sentinel", addr))
+	}
+	masterAddr := net.JoinHostPort(fields[5], fields[6])
+	if masterEpoch > m.configEpoch {
+		m.configEpoch = masterEpoch
+		if masterAddr != m.addr {
+			s.switchMaster(m, masterAddr)
+		}
+	}
+}
+
+// switchMaster 将master切换到 newAddr，原来的master和其他从节点都成为新master的从节点
+func (s *Sentinel) switchMaster(m *masterInstance, newAddr string) {
+	oldAddr := m.addr
+	replicaAddrs := []string{oldAddr}
+	for addr, replica := range m.replicas {
+		replica.close()
+		if addr != newAddr {
+			replicaAddrs = append(replicaAddrs, addr)
+		}
+	}
+	m.close()
+	m.instance = newInstance(newAddr)
+	s.watchHello(m.instance)
+	m.replicas = make(map[string]*instance)
+	for _, addr := range replicaAddrs {
+		replica := newInstance(addr)
+		m.replicas[addr] = replica
+		s.watchHello(replica)
+	}
+	m.odown = false
+	m.failoverState = failoverNone
+	m.promoted = nil
+	oldHost, oldPort, _ := net.SplitHostPort(oldAddr)
+	newHost, newPort, _ := net.SplitHostPort(newAddr)
+	s.publishEvent("+switch-master", fmt.Sprintf("%s %s %s %s %s", m.name, oldHost, oldPort, newHost, newPort))
+}
@@ -3,6 +3,7 @@ package tcp
 import (
 	"bufio"
 	"bytes"
+	"redigo/pkg/redis"
 	"sync"
 )
 
@@ -11,6 +12,9 @@ var writeBufferPool = sync.Pool{New: func() interface{} {
 	return &bytes.Buffer{}
 }}
 
+// readerSize bufio.Reader 默认的缓冲区大小
+const readerSize = 4096
+
 // readerPool 读请求的readerPool，只在非linux系统下的reader goroutine中使用
 var readerPool = sync.Pool{New: func() interface{} {
 	return bufio.NewReader(nil)
@@ -20,3 +24,14 @@ var readerPool = sync.Pool{New: func() interface{} {
 var rawBytesPool = sync.Pool{New: func() interface{} {
 	return make([]byte, 1024)
 }}
+
+// queuedSize 事务队列中的命令占用的内存
+func queuedSize(queue []*redis.RespCommand) int64 {
+	var size int64
+	for _, command := range queue {
+		for _, part := range command.Parts() {
+			size += int64(len(part))
+		}
+	}
+	return size
+}
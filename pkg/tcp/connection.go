@@ -102,6 +102,11 @@ func (c *Connection) Close() {
 	}
 }
 
+// BufferSize 读缓冲区和事务队列占用的内存，回复直接写入连接，不计算写缓冲区
+func (c *Connection) BufferSize() int64 {
+	return readerSize + queuedSize(c.cmdQueue)
+}
+
 func (c *Connection) SendCommand(command *redis.RespCommand) {
 	c.replyChan <- command
 }
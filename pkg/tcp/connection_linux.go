@@ -2,8 +2,10 @@ package tcp
 
 import (
 	"bytes"
+	"net"
 	"redigo/pkg/redis"
 	"redigo/pkg/util/buffer"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -21,6 +23,7 @@ type EpollConnection struct {
 	readBuffer  buffer.Buffer
 	writeBuffer *bytes.Buffer
 	active      uint32
+	readCap     int64 // readCap 读缓冲区的容量，由eventloop更新，MEMORY STATS 在executor中读取
 }
 
 func NewEpollConnection(fd int, epollManager *EpollEventLoop) *EpollConnection {
@@ -33,6 +36,7 @@ func NewEpollConnection(fd int, epollManager *EpollEventLoop) *EpollConnection {
 		epollManager: epollManager,
 		writeBuffer:  &bytes.Buffer{},
 		readBuffer:   buffer.NewRingBuffer(1024),
+		readCap:      1024,
 		active:       1,
 		wMutex:       &sync.Mutex{},
 	}
@@ -53,7 +57,17 @@ func (c *EpollConnection) ReadBuffered() (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return c.readBuffer.Write(buf[:n])
+	n, err = c.readBuffer.Write(buf[:n])
+	atomic.StoreInt64(&c.readCap, int64(c.readBuffer.Cap()))
+	return n, err
+}
+
+// BufferSize 读写缓冲区和事务队列占用的内存
+func (c *EpollConnection) BufferSize() int64 {
+	c.wMutex.Lock()
+	size := int64(c.writeBuffer.Cap())
+	c.wMutex.Unlock()
+	return size + atomic.LoadInt64(&c.readCap) + queuedSize(c.cmdQueue)
 }
 
 func (c *EpollConnection) ReadLoop() error {
@@ -78,8 +92,14 @@ func (c *EpollConnection) SendCommand(command *redis.RespCommand) {
 	}
 	n, err := c.Write(payload)
 	// 如果write缓冲区满会返回EAGAIN，此时需要等待EPOLLOUT
-	if err != nil && err == syscall.EAGAIN {
-		// 把没有写完的部分写入缓冲区
+	if err != nil && err != syscall.EAGAIN {
+		return
+	}
+	if n < 0 {
+		n = 0
+	}
+	// 把没有写完的部分写入缓冲区，比如主从复制时发送的大RDB
+	if n < len(payload) {
 		c.writeBuffer.Write(payload[n:])
 	}
 }
@@ -125,5 +145,15 @@ func (c *EpollConnection) Active() bool {
 }
 
 func (c *EpollConnection) RemoteAddr() string {
-	panic("operation not available")
+	sa, err := syscall.Getpeername(c.fd)
+	if err != nil {
+		return ""
+	}
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.JoinHostPort(net.IP(addr.Addr[:]).String(), strconv.Itoa(addr.Port))
+	case *syscall.SockaddrInet6:
+		return net.JoinHostPort(net.IP(addr.Addr[:]).String(), strconv.Itoa(addr.Port))
+	}
+	return ""
 }
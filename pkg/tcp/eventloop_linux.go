@@ -32,7 +32,6 @@ type EpollEventLoop struct {
 	onReadEvent func(conn *EpollConnection) error
 	waitMsec    int
 	ioHandlers  []*EpollIOHandler
-	nextHandler int
 	closeChan   chan struct{}
 }
 
@@ -64,6 +63,11 @@ func (e *EpollEventLoop) Listen(address string) error {
 		e.closeChan <- struct{}{}
 		return err
 	}
+	// 允许重启后立即重新绑定处于 TIME_WAIT 状态的地址
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		e.closeChan <- struct{}{}
+		return err
+	}
 	// Socket Bind 地址
 	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: ipAddr, Port: sockPort}); err != nil {
 		e.closeChan <- struct{}{}
@@ -150,7 +154,7 @@ func (e *EpollEventLoop) onEpollEvents() error {
 	}
 	// 有事件，继续无阻塞循环
 	e.waitMsec = 0
-	for _, event := range events {
+	for _, event := range events[:n] {
 		// 通过fd查询到一个连接对象
 		v, ok := e.conns.Load(int(event.Fd))
 		if !ok {
@@ -176,13 +180,10 @@ func (e *EpollEventLoop) onEpollEvents() error {
 	return nil
 }
 
-// DispatchIO 主循环将io事件交给某个handler处理，handler通过round-robin策略选择
+// DispatchIO 主循环将io事件交给某个handler处理，同一个连接的事件总是交给同一个handler，避免并发读写连接的buffer
 func (e *EpollEventLoop) DispatchIO(conn redis.Connection, flag byte) {
-	if e.nextHandler == len(e.ioHandlers) {
-		e.nextHandler = 0
-	}
-	e.ioHandlers[e.nextHandler].tasks <- IOTask{conn: conn, flag: flag}
-	e.nextHandler++
+	fd := conn.(*EpollConnection).fd
+	e.ioHandlers[fd%len(e.ioHandlers)].tasks <- IOTask{conn: conn, flag: flag}
 }
 
 func (e *EpollIOHandler) Handle() {
@@ -65,10 +65,15 @@ func (es *EpollServer) Start() error {
 func (es *EpollServer) onReadEvent(conn *EpollConnection) error {
 	// 尽可能一次读取所有可读数据，减少Read系统调用
 	for {
+		n, err := conn.ReadBuffered()
 		// socket无数据可读
-		if _, err := conn.ReadBuffered(); err == syscall.EAGAIN {
+		if err == syscall.EAGAIN {
 			break
 		}
+		// 读取出错或者对端已关闭，等待close事件处理
+		if err != nil || n == 0 {
+			return err
+		}
 		// 将buffer中的数据全部decode，并提交到DB处理
 		for {
 			conn.readBuffer.MarkReadIndex()
@@ -76,10 +81,10 @@ func (es *EpollServer) onReadEvent(conn *EpollConnection) error {
 			if err != nil && err != io.EOF {
 				return fmt.Errorf("decode error: %w", err)
 			}
-			// buffer中数据不完整
+			// buffer中数据不完整，继续从socket读取
 			if err == io.EOF || command == nil {
 				conn.readBuffer.ResetReadIndex()
-				return nil
+				break
 			}
 			command.BindConnection(conn)
 			es.db.SubmitCommand(command)
@@ -127,6 +132,18 @@ func (es *EpollServer) eventloop(ctx context.Context) {
 	}
 }
 
+// ForEachClient 遍历所有客户端连接和它们的缓冲区大小
+func (es *EpollServer) ForEachClient(fun func(conn redis.Connection, bufferSize int64)) {
+	if es.em == nil {
+		return
+	}
+	es.em.conns.Range(func(_, value any) bool {
+		conn := value.(*EpollConnection)
+		fun(conn, conn.BufferSize())
+		return true
+	})
+}
+
 func (es *EpollServer) Close() {
 	es.cancel()
 }
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"redigo/interface/database"
+	"redigo/pkg/redis"
 	"sync"
 	"syscall"
 )
@@ -102,6 +103,15 @@ func (s *GoNetServer) acceptLoop(ctx context.Context) error {
 	}
 }
 
+// ForEachClient 遍历所有客户端连接和它们的缓冲区大小
+func (s *GoNetServer) ForEachClient(fun func(conn redis.Connection, bufferSize int64)) {
+	s.activeConns.Range(func(key, _ any) bool {
+		conn := key.(*Connection)
+		fun(conn, conn.BufferSize())
+		return true
+	})
+}
+
 func (s *GoNetServer) commandExecutor(ctx context.Context) {
 	execErr := s.db.ExecuteLoop()
 	if execErr != nil {
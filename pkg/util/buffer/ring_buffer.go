@@ -104,7 +104,7 @@ func (r *RingBuffer) Cap() int {
 }
 
 func (r *RingBuffer) MarkReadIndex() {
-	r.readMark = r.rIdx
+	r.readMark, r.lengthMark = r.rIdx, r.length
 }
 
 func (r *RingBuffer) ResetReadIndex() {
@@ -116,3 +116,23 @@ func TestRingBuffer_ReadBytes2(t *testing.T) {
 	}
 	fmt.Println(cmd.Parts())
 }
+
+func TestRingBuffer_ResetReadIndex(t *testing.T) {
+	buf := NewRingBuffer(16)
+	_, _ = buf.Write([]byte("*2\r\n$4\r\nPING"))
+	buf.MarkReadIndex()
+	if _, err := redis.Decode(buf); err == nil {
+		t.Error("expect incomplete command error")
+		t.FailNow()
+	}
+	buf.ResetReadIndex()
+	_, _ = buf.Write([]byte("\r\n$5\r\nhello\r\n"))
+	cmd, err := redis.Decode(buf)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if cmd.Name() != "ping" || string(cmd.Args()[0]) != "hello" {
+		t.Errorf("expect: ping hello, got: %s", cmd.Parts())
+	}
+}
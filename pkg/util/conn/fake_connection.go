@@ -31,33 +31,56 @@ func (f *FakeConnection) SendCommand(reply *redis.RespCommand) {
 }
 
 func (f *FakeConnection) SelectDB(index int) {
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	f.RealConn.SelectDB(index)
 }
 
 func (f *FakeConnection) DBIndex() int {
+	// 没有关联真实连接时使用0号数据库
+	if f.RealConn == nil {
+		return 0
+	}
 	return f.RealConn.DBIndex()
 }
 
+// 关联了真实连接时，事务和watch的状态都保存在真实连接中。
+// 真实连接的goroutine在等待回复，executor修改这些状态时不会并发访问
+
 func (f *FakeConnection) SetMulti(b bool) {
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	f.RealConn.SetMulti(b)
 }
 
 func (f *FakeConnection) IsMulti() bool {
-	return false
+	if f.RealConn == nil {
+		return false
+	}
+	return f.RealConn.IsMulti()
 }
 
 func (f *FakeConnection) AddWatching(key string, version int64) {
-
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	f.RealConn.AddWatching(key, version)
 }
 
 func (f *FakeConnection) GetWatching() map[string]int64 {
-
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	return f.RealConn.GetWatching()
 }
 
 func (f *FakeConnection) UnWatch() {
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	f.RealConn.UnWatch()
 }
 
 func (f *FakeConnection) Active() bool {
@@ -69,9 +92,15 @@ func (f *FakeConnection) RemoteAddr() string {
 }
 
 func (f *FakeConnection) EnqueueCommand(command *redis.RespCommand) {
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	f.RealConn.EnqueueCommand(command)
 }
 
 func (f *FakeConnection) GetQueuedCommands() []*redis.RespCommand {
-	panic("method not allowed")
+	if f.RealConn == nil {
+		panic("method not allowed")
+	}
+	return f.RealConn.GetQueuedCommands()
 }
@@ -85,6 +85,15 @@ func (p *Pool) Put(element interface{}) {
 	p.cache <- element
 }
 
+// Release discard a resource which was taken from Pool and can't be reused, so that Pool can create a new one
+func (p *Pool) Release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.size > 0 {
+		p.size--
+	}
+}
+
 func (p *Pool) Cap() int {
 	return p.capacity
 }